@@ -13,11 +13,15 @@ import (
 	"github.com/hoshinonyaruko/gensokyo/idmap"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
 	"github.com/hoshinonyaruko/gensokyo/requestid"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 	"github.com/tencent-connect/botgo/dto"
 )
 
 // ProcessC2CMessage 处理C2C消息 群私聊
 func (p *Processors) ProcessC2CMessage(data *dto.WSC2CMessageData) error {
+	// 统计收到的消息和最后消息时间
+	stats.AddMessageReceived()
+
 	// 打印data结构体
 	PrintStructWithFieldNames(data)
 
@@ -36,26 +40,24 @@ func (p *Processors) ProcessC2CMessage(data *dto.WSC2CMessageData) error {
 		requestID := requestid.NewRequestID()
 		echostr := AppIDString + "_" + requestID
 
-		//将真实id转为int userid64
-		userid64, err := idmap.StoreIDv2(data.Author.ID)
+		//将真实id转为int userid64 messageID64
+		ids, err := idmap.StoreIDsv2(data.Author.ID, data.ID)
 		if err != nil {
 			log.Fatalf("Error storing ID: %v", err)
 		}
+		userid64, messageID64 := ids[0], ids[1]
 
 		//收到私聊信息调用的具体还原步骤
 		//1,idmap还原真实userid,
 		//发信息使用的是userid
 
-		messageID64, err := idmap.StoreIDv2(data.ID)
-		if err != nil {
-			log.Fatalf("Error storing ID: %v", err)
-		}
 		messageID := int(messageID64)
-		messageText := data.Content
+		parsedMessage := handlers.ConvertToMessage(data)
+		messageText := parsedMessage.String()
 		// 如果在Array模式下, 则处理Message为Segment格式
 		var segmentedMessages interface{} = messageText
 		if config.GetArrayValue() {
-			segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+			segmentedMessages = parsedMessage.Array()
 		}
 		privateMsg := OnebotPrivateMessage{
 			RawMessage:  messageText,
@@ -94,6 +96,18 @@ func (p *Processors) ProcessC2CMessage(data *dto.WSC2CMessageData) error {
 		//储存当前群或频道号的类型 私信不需要
 		//idmap.WriteConfigv2(data.ChannelID, "type", "group_private")
 
+		// 记录消息 用于get_msg
+		recordMessage(idmap.MessageRecord{
+			MessageID:   messageID64,
+			RealID:      data.ID,
+			MessageType: "private",
+			Scene:       "group_private",
+			UserID:      userid64,
+			Target:      data.Author.ID,
+			Content:     messageText,
+			Time:        privateMsg.Time,
+		})
+
 		// 调试
 		PrintStructWithFieldNames(privateMsg)
 
@@ -105,25 +119,20 @@ func (p *Processors) ProcessC2CMessage(data *dto.WSC2CMessageData) error {
 		//将私聊信息转化为群信息(特殊需求情况下)
 
 		//转换at
-		messageText := handlers.RevertTransformedText(data)
+		messageText := handlers.ConvertToMessage(data).String()
 		//转换appid
 		AppIDString := strconv.FormatUint(p.Settings.AppID, 10)
 		// 构造echo（使用非自增 request_id）
 		requestID := requestid.NewRequestID()
 		echostr := AppIDString + "_" + requestID
 		//把userid作为群号
-		//映射str的userid到int
-		userid64, err := idmap.StoreIDv2(data.Author.ID)
-		if err != nil {
-			mylog.Printf("Error storing ID: %v", err)
-			return nil
-		}
-		//映射str的messageID到int
-		messageID64, err := idmap.StoreIDv2(data.ID)
+		//映射str的userid和messageID到int
+		ids, err := idmap.StoreIDsv2(data.Author.ID, data.ID)
 		if err != nil {
 			mylog.Printf("Error storing ID: %v", err)
 			return nil
 		}
+		userid64, messageID64 := ids[0], ids[1]
 		messageID := int(messageID64)
 		//todo 判断array模式 然后对Message处理成array格式
 		groupMsg := OnebotGroupMessage{
@@ -179,6 +188,19 @@ func (p *Processors) ProcessC2CMessage(data *dto.WSC2CMessageData) error {
 		echo.AddMsgID(AppIDString, userid64, data.ID)
 		echo.AddMsgType(AppIDString, userid64, "group_private")
 
+		// 记录消息 用于get_msg
+		recordMessage(idmap.MessageRecord{
+			MessageID:   messageID64,
+			RealID:      data.ID,
+			MessageType: "group",
+			Scene:       "group_private",
+			GroupID:     userid64,
+			UserID:      userid64,
+			Target:      data.Author.ID,
+			Content:     messageText,
+			Time:        groupMsg.Time,
+		})
+
 		//调试
 		PrintStructWithFieldNames(groupMsg)
 
@@ -14,12 +14,16 @@ import (
 	"github.com/hoshinonyaruko/gensokyo/idmap"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
 	"github.com/hoshinonyaruko/gensokyo/requestid"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 
 	"github.com/tencent-connect/botgo/dto"
 )
 
 // ProcessChannelDirectMessage 处理频道私信消息 这里我们是被动收到
 func (p *Processors) ProcessChannelDirectMessage(data *dto.WSDirectMessageData) error {
+	// 统计收到的消息和最后消息时间
+	stats.AddMessageReceived()
+
 	// 打印data结构体
 	//PrintStructWithFieldNames(data)
 
@@ -67,11 +71,12 @@ func (p *Processors) ProcessChannelDirectMessage(data *dto.WSDirectMessageData)
 			log.Fatalf("Error storing ID: %v", err)
 		}
 		messageID := int(messageID64)
-		messageText := data.Content
+		parsedMessage := handlers.ConvertToMessage(data)
+		messageText := parsedMessage.String()
 		// 如果在Array模式下, 则处理Message为Segment格式
 		var segmentedMessages interface{} = messageText
 		if config.GetArrayValue() {
-			segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+			segmentedMessages = parsedMessage.Array()
 		}
 		privateMsg := OnebotPrivateMessage{
 			RawMessage:  messageText,
@@ -107,6 +112,20 @@ func (p *Processors) ProcessChannelDirectMessage(data *dto.WSDirectMessageData)
 		echo.AddMsgID(AppIDString, userid64, data.ID)
 		echo.AddMsgType(AppIDString, userid64, "guild_private")
 
+		// 记录消息 用于get_msg
+		recordMessage(idmap.MessageRecord{
+			MessageID:   messageID64,
+			RealID:      data.ID,
+			MessageType: "private",
+			Scene:       "guild_private",
+			UserID:      userid64,
+			Nickname:    data.Member.Nick,
+			Target:      data.ChannelID,
+			GuildID:     data.GuildID,
+			Content:     messageText,
+			Time:        privateMsg.Time,
+		})
+
 		// 调试
 		PrintStructWithFieldNames(privateMsg)
 
@@ -125,7 +144,7 @@ func (p *Processors) ProcessChannelDirectMessage(data *dto.WSDirectMessageData)
 			}
 			// 获取s（保留但不用于 echostr，因为使用 request_id）
 			//转换at
-			messageText := handlers.RevertTransformedText(data)
+			messageText := handlers.ConvertToMessage(data).String()
 			//转换appid
 			AppIDString := strconv.FormatUint(p.Settings.AppID, 10)
 			//构造echo
@@ -198,6 +217,19 @@ func (p *Processors) ProcessChannelDirectMessage(data *dto.WSDirectMessageData)
 			idmap.WriteConfigv2(data.ChannelID, "type", "guild_private")
 			//todo 完善频道类型信息转换
 
+			// 记录消息 用于get_msg
+			recordMessage(idmap.MessageRecord{
+				RealID:      data.ID,
+				MessageType: "guild",
+				Scene:       "guild_private",
+				UserID:      userid64,
+				Nickname:    data.Member.Nick,
+				Target:      data.ChannelID,
+				GuildID:     data.GuildID,
+				Content:     messageText,
+				Time:        onebotMsg.Time,
+			})
+
 			//调试
 			PrintStructWithFieldNames(onebotMsg)
 
@@ -216,7 +248,8 @@ func (p *Processors) ProcessChannelDirectMessage(data *dto.WSDirectMessageData)
 			//转成int再互转 适用于群场景私聊
 			idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "guild_id", data.GuildID)
 			//转换at
-			messageText := handlers.RevertTransformedText(data)
+			parsedMessage := handlers.ConvertToMessage(data)
+			messageText := parsedMessage.String()
 			//转换appid
 			AppIDString := strconv.FormatUint(p.Settings.AppID, 10)
 			//构造echo
@@ -239,7 +272,7 @@ func (p *Processors) ProcessChannelDirectMessage(data *dto.WSDirectMessageData)
 			// 如果在Array模式下, 则处理Message为Segment格式
 			var segmentedMessages interface{} = messageText
 			if config.GetArrayValue() {
-				segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+				segmentedMessages = parsedMessage.Array()
 			}
 			groupMsg := OnebotGroupMessage{
 				RawMessage:  messageText,
@@ -297,6 +330,21 @@ func (p *Processors) ProcessChannelDirectMessage(data *dto.WSDirectMessageData)
 			idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "type", "guild_private")
 			echo.AddMsgType(AppIDString, ChannelID64, "guild_private")
 
+			// 记录消息 用于get_msg
+			recordMessage(idmap.MessageRecord{
+				MessageID:   messageID64,
+				RealID:      data.ID,
+				MessageType: "group",
+				Scene:       "guild_private",
+				GroupID:     ChannelID64,
+				UserID:      userid64,
+				Nickname:    data.Member.Nick,
+				Target:      data.ChannelID,
+				GuildID:     data.GuildID,
+				Content:     messageText,
+				Time:        groupMsg.Time,
+			})
+
 			//调试
 			PrintStructWithFieldNames(groupMsg)
 
@@ -14,16 +14,21 @@ import (
 	"github.com/hoshinonyaruko/gensokyo/idmap"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
 	"github.com/hoshinonyaruko/gensokyo/requestid"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 
 	"github.com/tencent-connect/botgo/dto"
 )
 
 // ProcessGroupMessage 处理群组消息
 func (p *Processors) ProcessGroupMessage(data *dto.WSGroupATMessageData) error {
+	// 统计收到的消息和最后消息时间
+	stats.AddMessageReceived()
+
 	// 获取s（保留以防需要）
 
 	// 转换at
-	messageText := handlers.RevertTransformedText(data)
+	parsedMessage := handlers.ConvertToMessage(data)
+	messageText := parsedMessage.String()
 
 	// 转换appid
 	AppIDString := strconv.FormatUint(p.Settings.AppID, 10)
@@ -32,29 +37,17 @@ func (p *Processors) ProcessGroupMessage(data *dto.WSGroupATMessageData) error {
 	requestID := requestid.NewRequestID()
 	echostr := AppIDString + "_" + requestID
 
-	// 映射str的GroupID到int
-	GroupID64, err := idmap.StoreIDv2(data.GroupID)
+	// 映射str的GroupID userid messageID到int lotus模式下只需要一次请求
+	ids, err := idmap.StoreIDsv2(data.GroupID, data.Author.ID, data.ID)
 	if err != nil {
 		return fmt.Errorf("failed to convert ChannelID to int: %v", err)
 	}
-
-	// 映射str的userid到int
-	userid64, err := idmap.StoreIDv2(data.Author.ID)
-	if err != nil {
-		mylog.Printf("Error storing ID: %v", err)
-		return nil
-	}
-	//映射str的messageID到int
-	messageID64, err := idmap.StoreIDv2(data.ID)
-	if err != nil {
-		mylog.Printf("Error storing ID: %v", err)
-		return nil
-	}
+	GroupID64, userid64, messageID64 := ids[0], ids[1], ids[2]
 	messageID := int(messageID64)
 	// 如果在Array模式下, 则处理Message为Segment格式
 	var segmentedMessages interface{} = messageText
 	if config.GetArrayValue() {
-		segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+		segmentedMessages = parsedMessage.Array()
 	}
 	groupMsg := OnebotGroupMessage{
 		RawMessage:  messageText,
@@ -146,6 +139,7 @@ func (p *Processors) ProcessGroupMessage(data *dto.WSGroupATMessageData) error {
 				Content: autoReplyMsg,
 				MsgID:   data.ID,
 			}
+			replyMsg.MsgSeq, _ = echo.NextMsgSeq(data.ID)
 			if _, err := p.Apiv2.PostGroupMessage(context.TODO(), data.GroupID, replyMsg); err != nil {
 				mylog.Printf("发送自动回复失败: %v", err)
 			} else {
@@ -155,6 +149,19 @@ func (p *Processors) ProcessGroupMessage(data *dto.WSGroupATMessageData) error {
 		return nil // 不上报到ws服务器
 	}
 
+	// 记录消息 用于get_msg
+	recordMessage(idmap.MessageRecord{
+		MessageID:   messageID64,
+		RealID:      data.ID,
+		MessageType: "group",
+		Scene:       "group",
+		GroupID:     GroupID64,
+		UserID:      userid64,
+		Target:      data.GroupID,
+		Content:     messageText,
+		Time:        groupMsg.Time,
+	})
+
 	// 调试
 	PrintStructWithFieldNames(groupMsg)
 
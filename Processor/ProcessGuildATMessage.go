@@ -15,11 +15,15 @@ import (
 	"github.com/hoshinonyaruko/gensokyo/mylog"
 
 	"github.com/hoshinonyaruko/gensokyo/requestid"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 	"github.com/tencent-connect/botgo/dto"
 )
 
 // ProcessGuildATMessage 处理消息，执行逻辑并可能使用 api 发送响应
 func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
+	// 统计收到的消息和最后消息时间
+	stats.AddMessageReceived()
+
 	if !p.Settings.GlobalChannelToGroup {
 		// 将时间字符串转换为时间戳
 		t, err := time.Parse(time.RFC3339, string(data.Timestamp))
@@ -28,7 +32,8 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 		}
 		//获取s（保留以防需要）
 		//转换at
-		messageText := handlers.RevertTransformedText(data)
+		parsedMessage := handlers.ConvertToMessage(data)
+		messageText := parsedMessage.String()
 
 		// 检测单纯@bot的情况（内容为空或只有空格）
 		if messageText == "" || strings.TrimSpace(messageText) == "" {
@@ -38,6 +43,7 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 				Content: "你好！我是机器人，请问有什么可以帮助你的吗？\n你可以使用 /help 查看可用命令。",
 				MsgID:   data.ID,
 			}
+			replyMsg.MsgSeq, _ = echo.NextMsgSeq(data.ID)
 			// 发送回复到频道
 			if _, err := p.Api.PostMessage(context.TODO(), data.ChannelID, replyMsg); err != nil {
 				mylog.Printf("发送@bot提示消息失败: %v", err)
@@ -62,7 +68,7 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 		// 如果在Array模式下, 则处理Message为Segment格式
 		var segmentedMessages interface{} = messageText
 		if config.GetArrayValue() {
-			segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+			segmentedMessages = parsedMessage.Array()
 		}
 		// 处理onebot_channel_message逻辑
 		onebotMsg := OnebotChannelMessage{
@@ -137,6 +143,7 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 					Content: autoReplyMsg,
 					MsgID:   data.ID,
 				}
+				replyMsg.MsgSeq, _ = echo.NextMsgSeq(data.ID)
 				if _, err := p.Api.PostMessage(context.TODO(), data.ChannelID, replyMsg); err != nil {
 					mylog.Printf("发送自动回复失败: %v", err)
 				} else {
@@ -146,6 +153,19 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 			return nil // 不上报到ws服务器
 		}
 
+		// 记录消息 用于get_msg
+		recordMessage(idmap.MessageRecord{
+			RealID:      data.ID,
+			MessageType: "guild",
+			Scene:       "guild",
+			UserID:      userid64,
+			Nickname:    data.Member.Nick,
+			Target:      data.ChannelID,
+			GuildID:     data.GuildID,
+			Content:     messageText,
+			Time:        onebotMsg.Time,
+		})
+
 		//调试
 		PrintStructWithFieldNames(onebotMsg)
 
@@ -159,16 +179,14 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 		// GlobalChannelToGroup为true时的处理逻辑
 		//将频道转化为一个群
 		// 获取s（保留但不用于 echostr，因为使用 request_id）
-		//将channelid写入ini,可取出guild_id
-		ChannelID64, err := idmap.StoreIDv2(data.ChannelID)
-		if err != nil {
-			mylog.Printf("Error storing ID: %v", err)
+		//将channelid写入ini,可取出guild_id 同时登记到频道下的群 用于成员变更通知
+		ChannelID64 := p.registerChannel(data.ChannelID, data.GuildID)
+		if ChannelID64 == 0 {
 			return nil
 		}
-		//转成int再互转
-		idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "guild_id", data.GuildID)
 		//转换at和图片
-		messageText := handlers.RevertTransformedText(data)
+		parsedMessage := handlers.ConvertToMessage(data)
+		messageText := parsedMessage.String()
 
 		// 检测单纯@bot的情况（内容为空或只有空格）
 		if messageText == "" || strings.TrimSpace(messageText) == "" {
@@ -178,6 +196,7 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 				Content: "你好！我是机器人，请问有什么可以帮助你的吗？\n你可以使用 /help 查看可用命令。",
 				MsgID:   data.ID,
 			}
+			replyMsg.MsgSeq, _ = echo.NextMsgSeq(data.ID)
 			// 以群消息方式发送回复
 			if _, err := p.Apiv2.PostGroupMessage(context.TODO(), data.GroupID, replyMsg); err != nil {
 				mylog.Printf("发送@bot提示消息失败: %v", err)
@@ -210,7 +229,7 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 		// 如果在Array模式下, 则处理Message为Segment格式
 		var segmentedMessages interface{} = messageText
 		if config.GetArrayValue() {
-			segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+			segmentedMessages = parsedMessage.Array()
 		}
 		groupMsg := OnebotGroupMessage{
 			RawMessage:  messageText,
@@ -283,6 +302,7 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 					Content: autoReplyMsg,
 					MsgID:   data.ID,
 				}
+				replyMsg.MsgSeq, _ = echo.NextMsgSeq(data.ID)
 				if _, err := p.Apiv2.PostGroupMessage(context.TODO(), data.GroupID, replyMsg); err != nil {
 					mylog.Printf("发送自动回复失败: %v", err)
 				} else {
@@ -292,6 +312,21 @@ func (p *Processors) ProcessGuildATMessage(data *dto.WSATMessageData) error {
 			return nil // 不上报到ws服务器
 		}
 
+		// 记录消息 用于get_msg
+		recordMessage(idmap.MessageRecord{
+			MessageID:   messageID64,
+			RealID:      data.ID,
+			MessageType: "group",
+			Scene:       "guild",
+			GroupID:     ChannelID64,
+			UserID:      userid64,
+			Nickname:    data.Member.Nick,
+			Target:      data.ChannelID,
+			GuildID:     data.GuildID,
+			Content:     messageText,
+			Time:        groupMsg.Time,
+		})
+
 		//调试
 		PrintStructWithFieldNames(groupMsg)
 
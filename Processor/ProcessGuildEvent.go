@@ -0,0 +1,264 @@
+package Processor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+)
+
+// 子频道信息 仿照go-cqhttp的ChannelInfo
+type OnebotChannelInfo struct {
+	OwnerGuildID  string `json:"owner_guild_id"`
+	ChannelID     string `json:"channel_id"`
+	ChannelType   int    `json:"channel_type"`
+	ChannelName   string `json:"channel_name"`
+	ParentID      string `json:"parent_id,omitempty"`
+	CreatorTinyID string `json:"creator_tiny_id"`
+}
+
+// 子频道创建 更新 删除通知 仿照go-cqhttp的channel_created channel_updated channel_destroyed
+type OnebotChannelNotice struct {
+	PostType    string             `json:"post_type"`
+	NoticeType  string             `json:"notice_type"`
+	Time        int64              `json:"time"`
+	SelfID      int64              `json:"self_id"`
+	SelfTinyID  string             `json:"self_tiny_id"`
+	GuildID     string             `json:"guild_id"`
+	ChannelID   string             `json:"channel_id"`
+	GroupID     int64              `json:"group_id,omitempty"` // 开启global_channel_to_group时子频道对应的群号
+	UserID      int64              `json:"user_id"`
+	OperatorID  int64              `json:"operator_id"`
+	ChannelInfo *OnebotChannelInfo `json:"channel_info,omitempty"`
+	OldInfo     *OnebotChannelInfo `json:"old_info,omitempty"`
+	NewInfo     *OnebotChannelInfo `json:"new_info,omitempty"`
+}
+
+// 子频道事件对应的notice_type
+var channelNoticeTypes = map[dto.EventType]string{
+	dto.EventChannelCreate: "channel_created",
+	dto.EventChannelUpdate: "channel_updated",
+	dto.EventChannelDelete: "channel_destroyed",
+}
+
+// ProcessGuildEvent 机器人加入或退出频道时上报notice 加入时登记频道下的子频道
+// 开启global_channel_to_group时对频道下登记的每个子频道上报group_increase和group_decrease user_id为机器人自己
+func (p *Processors) ProcessGuildEvent(eventType dto.EventType, data *dto.WSGuildData) error {
+	if eventType == dto.EventGuildUpdate {
+		// onebot没有对应的通知
+		mylog.Printf("频道[%s]资料更新: %s", data.ID, data.Name)
+		return nil
+	}
+	guildID64, err := idmap.StoreIDv2(data.ID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	var operatorID64 int64
+	if data.OpUserID != "" {
+		if operatorID64, err = idmap.StoreIDv2(data.OpUserID); err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return nil
+		}
+	}
+
+	eventTime := time.Now().Unix()
+	selfID := int64(p.Settings.AppID)
+	joined := eventType == dto.EventGuildCreate
+	if joined {
+		mylog.Printf("机器人加入了频道[%s]%s", data.ID, data.Name)
+		if joinedAt, err := data.JoinedAt.Time(); err == nil {
+			eventTime = joinedAt.Unix()
+		}
+		for _, channel := range data.Channels {
+			if channel != nil {
+				p.registerChannel(channel.ID, data.ID)
+			}
+		}
+	} else {
+		mylog.Printf("机器人退出了频道[%s]%s", data.ID, data.Name)
+	}
+
+	if p.Settings.GlobalChannelToGroup {
+		// 与registerChannel登记的群号一致 按频道下的每个子频道分别上报
+		groups := guildGroups(data.ID)
+		if len(groups) == 0 {
+			mylog.Printf("频道[%s]下没有登记的子频道 不上报机器人进出", data.ID)
+			return nil
+		}
+		for _, groupID := range groups {
+			groupNotice := OnebotGroupMemberNotice{
+				PostType:   "notice",
+				NoticeType: "group_increase",
+				SubType:    "invite",
+				Time:       eventTime,
+				SelfID:     selfID,
+				GroupID:    groupID,
+				UserID:     selfID,
+				OperatorID: operatorID64,
+			}
+			if !joined {
+				groupNotice.NoticeType = "group_decrease"
+				groupNotice.SubType = "kick_me"
+			}
+			p.BroadcastMessageToAll(structToMap(groupNotice))
+		}
+		if !joined {
+			// 退出后子频道不再属于机器人 重新加入时再登记
+			writeGuildGroups(data.ID, nil)
+		}
+		return nil
+	}
+
+	guildNotice := OnebotGuildMemberNotice{
+		PostType:   "notice",
+		NoticeType: "guild_channel_member_increase",
+		SubType:    "invite",
+		Time:       eventTime,
+		SelfID:     selfID,
+		GuildID:    guildID64,
+		UserID:     selfID,
+		OperatorID: operatorID64,
+	}
+	if !joined {
+		guildNotice.NoticeType = "guild_channel_member_decrease"
+		guildNotice.SubType = "kick_me"
+	}
+	p.BroadcastMessageToAll(structToMap(guildNotice))
+	return nil
+}
+
+// ProcessChannelEvent 子频道创建 更新 删除时上报go-cqhttp格式的notice
+// 创建和更新时登记子频道 之后可以直接向新的子频道发送信息
+func (p *Processors) ProcessChannelEvent(eventType dto.EventType, data *dto.WSChannelData) error {
+	noticeType, ok := channelNoticeTypes[eventType]
+	if !ok {
+		return nil
+	}
+	var operatorID64 int64
+	if data.OpUserID != "" {
+		var err error
+		if operatorID64, err = idmap.StoreIDv2(data.OpUserID); err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return nil
+		}
+	}
+
+	info := &OnebotChannelInfo{
+		OwnerGuildID:  data.GuildID,
+		ChannelID:     data.ID,
+		ChannelType:   int(data.Type),
+		ChannelName:   data.Name,
+		ParentID:      data.ParentID,
+		CreatorTinyID: data.OwnerID,
+	}
+	notice := OnebotChannelNotice{
+		PostType:   "notice",
+		NoticeType: noticeType,
+		Time:       time.Now().Unix(),
+		SelfID:     int64(p.Settings.AppID),
+		GuildID:    data.GuildID,
+		ChannelID:  data.ID,
+		UserID:     operatorID64,
+		OperatorID: operatorID64,
+	}
+	switch eventType {
+	case dto.EventChannelUpdate:
+		// 旧的名称来自上次登记的记录
+		oldInfo := *info
+		if name, err := idmap.ReadConfigv2(data.ID, "channel_name"); err == nil {
+			oldInfo.ChannelName = name
+		}
+		notice.OldInfo = &oldInfo
+		notice.NewInfo = info
+		notice.GroupID = p.registerChannel(data.ID, data.GuildID)
+	case dto.EventChannelCreate:
+		notice.ChannelInfo = info
+		notice.GroupID = p.registerChannel(data.ID, data.GuildID)
+	default:
+		notice.ChannelInfo = info
+		if p.Settings.GlobalChannelToGroup {
+			notice.GroupID, _ = idmap.StoreIDv2(data.ID)
+			removeGuildGroup(data.GuildID, notice.GroupID)
+		}
+	}
+	if data.Name != "" && eventType != dto.EventChannelDelete {
+		idmap.WriteConfigv2(data.ID, "channel_name", data.Name)
+	}
+	mylog.Printf("频道[%s]子频道[%s]%s: %s", data.GuildID, data.ID, data.Name, eventType)
+	p.BroadcastMessageToAll(structToMap(notice))
+	return nil
+}
+
+// 登记子频道的类型和所属频道 与收到频道信息时写入的记录一致
+// 开启global_channel_to_group时返回子频道对应的群号 并登记到频道下的群
+func (p *Processors) registerChannel(channelID, guildID string) int64 {
+	if !p.Settings.GlobalChannelToGroup {
+		idmap.WriteConfigv2(channelID, "type", "guild")
+		return 0
+	}
+	ChannelID64, err := idmap.StoreIDv2(channelID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return 0
+	}
+	idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "guild_id", guildID)
+	idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "type", "guild")
+	addGuildGroup(guildID, ChannelID64)
+	return ChannelID64
+}
+
+// 频道下已登记为群的子频道的群号 以逗号分隔保存在频道的group_ids中
+// 频道本身不能收发信息 开启global_channel_to_group时 频道级别的通知按这些群分别上报
+func guildGroups(guildID string) []int64 {
+	value, err := idmap.ReadConfigv2(guildID, "group_ids")
+	if err != nil || value == "" {
+		return nil
+	}
+	var groups []int64
+	for _, s := range strings.Split(value, ",") {
+		if groupID, err := strconv.ParseInt(s, 10, 64); err == nil {
+			groups = append(groups, groupID)
+		}
+	}
+	return groups
+}
+
+// 把群号登记到频道下 已登记时不重复写入
+func addGuildGroup(guildID string, groupID int64) {
+	groups := guildGroups(guildID)
+	for _, g := range groups {
+		if g == groupID {
+			return
+		}
+	}
+	writeGuildGroups(guildID, append(groups, groupID))
+}
+
+// 子频道删除后不再上报它的通知
+func removeGuildGroup(guildID string, groupID int64) {
+	groups := guildGroups(guildID)
+	kept := groups[:0]
+	for _, g := range groups {
+		if g != groupID {
+			kept = append(kept, g)
+		}
+	}
+	if len(kept) != len(groups) {
+		writeGuildGroups(guildID, kept)
+	}
+}
+
+func writeGuildGroups(guildID string, groups []int64) {
+	values := make([]string, len(groups))
+	for i, g := range groups {
+		values[i] = strconv.FormatInt(g, 10)
+	}
+	if err := idmap.WriteConfigv2(guildID, "group_ids", strings.Join(values, ",")); err != nil {
+		mylog.Printf("登记频道[%s]下的群失败: %v", guildID, err)
+	}
+}
@@ -0,0 +1,170 @@
+package Processor
+
+import (
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+)
+
+// 群成员增加 减少通知 频道转换为群时使用
+type OnebotGroupMemberNotice struct {
+	PostType   string `json:"post_type"`
+	NoticeType string `json:"notice_type"`
+	SubType    string `json:"sub_type"`
+	Time       int64  `json:"time"`
+	SelfID     int64  `json:"self_id"`
+	GroupID    int64  `json:"group_id"`
+	UserID     int64  `json:"user_id"`
+	OperatorID int64  `json:"operator_id"`
+}
+
+// 群名片变更通知 频道成员资料更新时上报 拿不到旧名片
+type OnebotGroupCardNotice struct {
+	PostType   string `json:"post_type"`
+	NoticeType string `json:"notice_type"`
+	Time       int64  `json:"time"`
+	SelfID     int64  `json:"self_id"`
+	GroupID    int64  `json:"group_id"`
+	UserID     int64  `json:"user_id"`
+	CardNew    string `json:"card_new"`
+	CardOld    string `json:"card_old"`
+}
+
+// 频道成员变更通知 仿照go-cqhttp的guild_channel_*通知
+type OnebotGuildMemberNotice struct {
+	PostType   string   `json:"post_type"`
+	NoticeType string   `json:"notice_type"`
+	SubType    string   `json:"sub_type,omitempty"`
+	Time       int64    `json:"time"`
+	SelfID     int64    `json:"self_id"`
+	GuildID    int64    `json:"guild_id"`
+	UserID     int64    `json:"user_id"`
+	OperatorID int64    `json:"operator_id"`
+	Nickname   string   `json:"nickname"`
+	Roles      []string `json:"roles"`
+}
+
+// ProcessGuildMemberEvent 将频道成员的加入 资料更新 退出上报为notice
+// 开启global_channel_to_group时 成员事件没有子频道 对频道下已登记为群的每个子频道上报group_increase等通知
+func (p *Processors) ProcessGuildMemberEvent(eventType dto.EventType, data *dto.WSGuildMemberData) error {
+	if data.User == nil {
+		mylog.Printf("频道成员事件%s缺少用户信息", eventType)
+		return nil
+	}
+	ids, err := idmap.StoreIDsv2(data.GuildID, data.User.ID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	guildID64, userID64 := ids[0], ids[1]
+
+	// 操作者为空时是成员自己
+	operatorID64 := userID64
+	if data.OpUserID != "" && data.OpUserID != data.User.ID {
+		if operatorID64, err = idmap.StoreIDv2(data.OpUserID); err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return nil
+		}
+	}
+	selfOperated := operatorID64 == userID64
+
+	eventTime := time.Now().Unix()
+	if joinedAt, err := data.JoinedAt.Time(); err == nil && eventType == dto.EventGuildMemberAdd {
+		eventTime = joinedAt.Unix()
+	}
+	nickname := data.Nick
+	if nickname == "" {
+		nickname = data.User.Username
+	}
+
+	if p.Settings.GlobalChannelToGroup {
+		// 频道不是群 按频道下已登记的子频道分别上报 群号与消息和发送使用的一致
+		groups := guildGroups(data.GuildID)
+		if len(groups) == 0 {
+			mylog.Printf("频道[%s]下还没有登记的子频道 不上报成员变更: %s", data.GuildID, eventType)
+			return nil
+		}
+		for _, groupID := range groups {
+			notice := groupMemberNotice(eventType, selfOperated, OnebotGroupMemberNotice{
+				PostType:   "notice",
+				Time:       eventTime,
+				SelfID:     int64(p.Settings.AppID),
+				GroupID:    groupID,
+				UserID:     userID64,
+				OperatorID: operatorID64,
+			}, nickname)
+			if notice == nil {
+				return nil
+			}
+			p.BroadcastMessageToAll(structToMap(notice))
+		}
+		mylog.Printf("频道[%s]成员[%s]变更: %s 上报到%d个群", data.GuildID, data.User.ID, eventType, len(groups))
+		return nil
+	}
+
+	notice := OnebotGuildMemberNotice{
+		PostType:   "notice",
+		NoticeType: guildMemberNoticeTypes[eventType],
+		SubType:    guildMemberSubType(eventType, selfOperated),
+		Time:       eventTime,
+		SelfID:     int64(p.Settings.AppID),
+		GuildID:    guildID64,
+		UserID:     userID64,
+		OperatorID: operatorID64,
+		Nickname:   nickname,
+		Roles:      data.Roles,
+	}
+	mylog.Printf("频道[%s]成员[%s]变更: %s", data.GuildID, data.User.ID, eventType)
+	p.BroadcastMessageToAll(structToMap(notice))
+	return nil
+}
+
+// 频道成员事件对应的notice_type
+var guildMemberNoticeTypes = map[dto.EventType]string{
+	dto.EventGuildMemberAdd:    "guild_channel_member_increase",
+	dto.EventGuildMemberUpdate: "guild_channel_member_update",
+	dto.EventGuildMemberRemove: "guild_channel_member_decrease",
+}
+
+// 加入时区分主动加入和被邀请 退出时区分主动退出和被移出
+func guildMemberSubType(eventType dto.EventType, selfOperated bool) string {
+	switch eventType {
+	case dto.EventGuildMemberAdd:
+		if selfOperated {
+			return "approve"
+		}
+		return "invite"
+	case dto.EventGuildMemberRemove:
+		if selfOperated {
+			return "leave"
+		}
+		return "kick"
+	}
+	return ""
+}
+
+// 转换为群通知 资料更新上报为group_card
+func groupMemberNotice(eventType dto.EventType, selfOperated bool, notice OnebotGroupMemberNotice, nickname string) interface{} {
+	switch eventType {
+	case dto.EventGuildMemberAdd:
+		notice.NoticeType = "group_increase"
+	case dto.EventGuildMemberRemove:
+		notice.NoticeType = "group_decrease"
+	case dto.EventGuildMemberUpdate:
+		return OnebotGroupCardNotice{
+			PostType:   notice.PostType,
+			NoticeType: "group_card",
+			Time:       notice.Time,
+			SelfID:     notice.SelfID,
+			GroupID:    notice.GroupID,
+			UserID:     notice.UserID,
+			CardNew:    nickname,
+		}
+	default:
+		return nil
+	}
+	notice.SubType = guildMemberSubType(eventType, selfOperated)
+	return notice
+}
@@ -13,11 +13,15 @@ import (
 	"github.com/hoshinonyaruko/gensokyo/idmap"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
 	"github.com/hoshinonyaruko/gensokyo/requestid"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 	"github.com/tencent-connect/botgo/dto"
 )
 
 // ProcessGuildNormalMessage 处理频道常规消息
 func (p *Processors) ProcessGuildNormalMessage(data *dto.WSMessageData) error {
+	// 统计收到的消息和最后消息时间
+	stats.AddMessageReceived()
+
 	if !p.Settings.GlobalChannelToGroup {
 		// 将时间字符串转换为时间戳
 		t, err := time.Parse(time.RFC3339, string(data.Timestamp))
@@ -26,7 +30,8 @@ func (p *Processors) ProcessGuildNormalMessage(data *dto.WSMessageData) error {
 		}
 		//获取s（保留以防需要）
 		//转换at
-		messageText := handlers.RevertTransformedText(data)
+		parsedMessage := handlers.ConvertToMessage(data)
+		messageText := parsedMessage.String()
 		//转换appid
 		AppIDString := strconv.FormatUint(p.Settings.AppID, 10)
 		// 构造echostr（使用非自增 request_id）
@@ -41,7 +46,7 @@ func (p *Processors) ProcessGuildNormalMessage(data *dto.WSMessageData) error {
 		// 如果在Array模式下, 则处理Message为Segment格式
 		var segmentedMessages interface{} = messageText
 		if config.GetArrayValue() {
-			segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+			segmentedMessages = parsedMessage.Array()
 		}
 		// 处理onebot_channel_message逻辑
 		onebotMsg := OnebotChannelMessage{
@@ -108,6 +113,19 @@ func (p *Processors) ProcessGuildNormalMessage(data *dto.WSMessageData) error {
 		idmap.WriteConfigv2(data.ChannelID, "type", "guild")
 		//todo 完善频道ob信息
 
+		// 记录消息 用于get_msg
+		recordMessage(idmap.MessageRecord{
+			RealID:      data.ID,
+			MessageType: "guild",
+			Scene:       "guild",
+			UserID:      userid64,
+			Nickname:    data.Member.Nick,
+			Target:      data.ChannelID,
+			GuildID:     data.GuildID,
+			Content:     messageText,
+			Time:        onebotMsg.Time,
+		})
+
 		//调试
 		PrintStructWithFieldNames(onebotMsg)
 
@@ -120,16 +138,14 @@ func (p *Processors) ProcessGuildNormalMessage(data *dto.WSMessageData) error {
 		// GlobalChannelToGroup为true时的处理逻辑
 		//将频道转化为一个群
 		// 获取s（保留但不用于 echostr，因为使用 request_id）
-		//将channelid写入ini,可取出guild_id
-		ChannelID64, err := idmap.StoreIDv2(data.ChannelID)
-		if err != nil {
-			mylog.Printf("Error storing ID: %v", err)
+		//将channelid写入ini,可取出guild_id 同时登记到频道下的群 用于成员变更通知
+		ChannelID64 := p.registerChannel(data.ChannelID, data.GuildID)
+		if ChannelID64 == 0 {
 			return nil
 		}
-		//转成int再互转
-		idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "guild_id", data.GuildID)
 		//转换at
-		messageText := handlers.RevertTransformedText(data)
+		parsedMessage := handlers.ConvertToMessage(data)
+		messageText := parsedMessage.String()
 		//转换appid
 		AppIDString := strconv.FormatUint(p.Settings.AppID, 10)
 		// 构造echostr（使用非自增 request_id）
@@ -152,7 +168,7 @@ func (p *Processors) ProcessGuildNormalMessage(data *dto.WSMessageData) error {
 		// 如果在Array模式下, 则处理Message为Segment格式
 		var segmentedMessages interface{} = messageText
 		if config.GetArrayValue() {
-			segmentedMessages = handlers.ConvertToSegmentedMessage(data)
+			segmentedMessages = parsedMessage.Array()
 		}
 		groupMsg := OnebotGroupMessage{
 			RawMessage:  messageText,
@@ -207,6 +223,21 @@ func (p *Processors) ProcessGuildNormalMessage(data *dto.WSMessageData) error {
 		//储存当前群或频道号的类型
 		idmap.WriteConfigv2(fmt.Sprint(ChannelID64), "type", "guild")
 
+		// 记录消息 用于get_msg
+		recordMessage(idmap.MessageRecord{
+			MessageID:   messageID64,
+			RealID:      data.ID,
+			MessageType: "group",
+			Scene:       "guild",
+			GroupID:     ChannelID64,
+			UserID:      userid64,
+			Nickname:    data.Member.Nick,
+			Target:      data.ChannelID,
+			GuildID:     data.GuildID,
+			Content:     messageText,
+			Time:        groupMsg.Time,
+		})
+
 		//调试
 		PrintStructWithFieldNames(groupMsg)
 
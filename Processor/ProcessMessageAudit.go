@@ -0,0 +1,68 @@
+package Processor
+
+import (
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/handlers"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+)
+
+// 频道主动消息审核结果通知 onebot没有对应的通知 notice_type为message_audit
+// audit_id与发送时回执中的audit_id对应 通过时message_id为审核后的消息
+type OnebotAuditNotice struct {
+	PostType   string      `json:"post_type"`
+	NoticeType string      `json:"notice_type"`
+	SubType    string      `json:"sub_type"` // pass或reject
+	Time       int64       `json:"time"`
+	SelfID     int64       `json:"self_id"`
+	AuditID    string      `json:"audit_id"`
+	GuildID    string      `json:"guild_id"`
+	ChannelID  string      `json:"channel_id"`
+	GroupID    int64       `json:"group_id,omitempty"` // 开启global_channel_to_group时子频道对应的群号
+	MessageID  int64       `json:"message_id,omitempty"`
+	Action     string      `json:"action,omitempty"` // 发起发送的action 找不到对应的发送时为空
+	Echo       interface{} `json:"echo,omitempty"`
+	RequestID  interface{} `json:"request_id,omitempty"`
+}
+
+// ProcessMessageAudit 将频道主动消息的审核结果上报为notice 通过时保存最终的message_id
+func (p *Processors) ProcessMessageAudit(eventType dto.EventType, data *dto.WSMessageAuditData) error {
+	passed := eventType == dto.EventMessageAuditPass
+	result := handlers.CompleteAudit((*dto.MessageAudit)(data), passed)
+
+	notice := OnebotAuditNotice{
+		PostType:   "notice",
+		NoticeType: "message_audit",
+		SubType:    "reject",
+		Time:       time.Now().Unix(),
+		SelfID:     int64(p.Settings.AppID),
+		AuditID:    data.AuditID,
+		GuildID:    data.GuildID,
+		ChannelID:  data.ChannelID,
+		MessageID:  result.MessageID,
+		Action:     result.Action,
+	}
+	if passed {
+		notice.SubType = "pass"
+	}
+	if auditTime, err := time.Parse(time.RFC3339, data.AuditTime); err == nil {
+		notice.Time = auditTime.Unix()
+	}
+	if p.Settings.UseRequestID {
+		notice.RequestID = result.Echo
+	} else {
+		notice.Echo = result.Echo
+	}
+	if p.Settings.GlobalChannelToGroup && data.ChannelID != "" {
+		var err error
+		if notice.GroupID, err = idmap.StoreIDv2(data.ChannelID); err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return nil
+		}
+	}
+	mylog.Printf("子频道[%s]消息审核%s audit_id: %s", data.ChannelID, notice.SubType, data.AuditID)
+	p.BroadcastMessageToAll(structToMap(notice))
+	return nil
+}
@@ -0,0 +1,136 @@
+package Processor
+
+import (
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+)
+
+// 群消息撤回通知
+type OnebotGroupRecallNotice struct {
+	PostType   string `json:"post_type"`
+	NoticeType string `json:"notice_type"`
+	Time       int64  `json:"time"`
+	SelfID     int64  `json:"self_id"`
+	GroupID    int64  `json:"group_id"`
+	UserID     int64  `json:"user_id"`
+	OperatorID int64  `json:"operator_id"`
+	MessageID  int64  `json:"message_id"`
+}
+
+// 私聊消息撤回通知
+type OnebotFriendRecallNotice struct {
+	PostType   string `json:"post_type"`
+	NoticeType string `json:"notice_type"`
+	Time       int64  `json:"time"`
+	SelfID     int64  `json:"self_id"`
+	UserID     int64  `json:"user_id"`
+	OperatorID int64  `json:"operator_id"`
+	MessageID  int64  `json:"message_id"`
+}
+
+// 子频道消息撤回通知 仿照go-cqhttp的guild_channel_recall
+type OnebotGuildRecallNotice struct {
+	PostType   string `json:"post_type"`
+	NoticeType string `json:"notice_type"`
+	Time       int64  `json:"time"`
+	SelfID     int64  `json:"self_id"`
+	GuildID    string `json:"guild_id"`
+	ChannelID  string `json:"channel_id"`
+	UserID     int64  `json:"user_id"`
+	OperatorID int64  `json:"operator_id"`
+	MessageID  int64  `json:"message_id"`
+}
+
+// ProcessMessageDelete 将频道消息和频道私信的撤回上报为notice 并在消息记录中标记撤回
+// 按消息上报时的类型选择group_recall friend_recall或guild_channel_recall 没有记录时按事件和配置推断
+func (p *Processors) ProcessMessageDelete(eventType dto.EventType, data *dto.MessageDelete) error {
+	if data.Message.ID == "" {
+		return nil
+	}
+	authorID := data.OpUser.ID
+	if data.Message.Author != nil {
+		authorID = data.Message.Author.ID
+	}
+	operatorID := data.OpUser.ID
+	if operatorID == "" {
+		operatorID = authorID
+	}
+	ids, err := idmap.StoreIDsv2(data.Message.ID, authorID, operatorID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	messageID64, userID64, operatorID64 := ids[0], ids[1], ids[2]
+
+	record, err := idmap.MarkMessageDeleted(messageID64, operatorID64)
+	if err != nil && err != idmap.ErrKeyNotFound {
+		mylog.Printf("标记消息撤回失败: %v", err)
+	}
+	messageType := record.MessageType
+	if err != nil {
+		messageType = p.recallMessageType(eventType)
+	}
+
+	now := time.Now().Unix()
+	selfID := int64(p.Settings.AppID)
+	var notice interface{}
+	switch messageType {
+	case "group":
+		groupID64 := record.GroupID
+		if groupID64 == 0 {
+			if groupID64, err = idmap.StoreIDv2(data.Message.ChannelID); err != nil {
+				mylog.Printf("Error storing ID: %v", err)
+				return nil
+			}
+		}
+		notice = OnebotGroupRecallNotice{
+			PostType:   "notice",
+			NoticeType: "group_recall",
+			Time:       now,
+			SelfID:     selfID,
+			GroupID:    groupID64,
+			UserID:     userID64,
+			OperatorID: operatorID64,
+			MessageID:  messageID64,
+		}
+	case "private":
+		notice = OnebotFriendRecallNotice{
+			PostType:   "notice",
+			NoticeType: "friend_recall",
+			Time:       now,
+			SelfID:     selfID,
+			UserID:     userID64,
+			OperatorID: operatorID64,
+			MessageID:  messageID64,
+		}
+	default:
+		notice = OnebotGuildRecallNotice{
+			PostType:   "notice",
+			NoticeType: "guild_channel_recall",
+			Time:       now,
+			SelfID:     selfID,
+			GuildID:    data.Message.GuildID,
+			ChannelID:  data.Message.ChannelID,
+			UserID:     userID64,
+			OperatorID: operatorID64,
+			MessageID:  messageID64,
+		}
+	}
+	mylog.Printf("消息[%s]被撤回: %s", data.Message.ID, eventType)
+	p.BroadcastMessageToAll(structToMap(notice))
+	return nil
+}
+
+// 没有消息记录时 按收到消息时的转换规则推断上报的消息类型
+func (p *Processors) recallMessageType(eventType dto.EventType) string {
+	if eventType == dto.EventDirectMessageDelete && !p.Settings.GlobalPrivateToChannel {
+		return "private"
+	}
+	if p.Settings.GlobalChannelToGroup {
+		return "group"
+	}
+	return "guild"
+}
@@ -0,0 +1,85 @@
+package Processor
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+)
+
+// 表情表态 仿照go-cqhttp的ReactionInfo
+type OnebotReactionInfo struct {
+	EmojiID    string `json:"emoji_id"`
+	EmojiIndex int    `json:"emoji_index"`
+	EmojiType  int    `json:"emoji_type"`
+	Clicked    bool   `json:"clicked"`
+}
+
+// 表情表态变化通知 仿照go-cqhttp的message_reactions_updated
+// 事件只包含变化的表态 current_reactions中只有这一个表情
+type OnebotReactionNotice struct {
+	PostType         string               `json:"post_type"`
+	NoticeType       string               `json:"notice_type"`
+	SubType          string               `json:"sub_type"` // add或remove
+	Time             int64                `json:"time"`
+	SelfID           int64                `json:"self_id"`
+	GuildID          string               `json:"guild_id"`
+	ChannelID        string               `json:"channel_id"`
+	GroupID          int64                `json:"group_id,omitempty"` // 开启global_channel_to_group时子频道对应的群号
+	UserID           int64                `json:"user_id"`
+	OperatorID       int64                `json:"operator_id"`
+	MessageID        int64                `json:"message_id"`
+	CurrentReactions []OnebotReactionInfo `json:"current_reactions"`
+}
+
+// ProcessMessageReaction 将频道消息的表情表态和取消表态上报为notice
+func (p *Processors) ProcessMessageReaction(eventType dto.EventType, data *dto.WSMessageReactionData) error {
+	if data.Target.Type != dto.ReactionTargetTypeMsg {
+		mylog.Printf("忽略帖子或评论的表情表态: %s", data.Target.ID)
+		return nil
+	}
+	ids, err := idmap.StoreIDsv2(data.UserID, data.Target.ID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return nil
+	}
+	userID64, messageID64 := ids[0], ids[1]
+
+	added := eventType == dto.EventMessageReactionAdd
+	reaction := OnebotReactionInfo{
+		EmojiID:   data.Emoji.ID,
+		EmojiType: data.Emoji.Type,
+		Clicked:   added,
+	}
+	// 系统表情的id即为表情序号
+	if index, err := strconv.Atoi(data.Emoji.ID); err == nil && data.Emoji.Type == 1 {
+		reaction.EmojiIndex = index
+	}
+	notice := OnebotReactionNotice{
+		PostType:         "notice",
+		NoticeType:       "message_reactions_updated",
+		SubType:          "remove",
+		Time:             time.Now().Unix(),
+		SelfID:           int64(p.Settings.AppID),
+		GuildID:          data.GuildID,
+		ChannelID:        data.ChannelID,
+		UserID:           userID64,
+		OperatorID:       userID64,
+		MessageID:        messageID64,
+		CurrentReactions: []OnebotReactionInfo{reaction},
+	}
+	if added {
+		notice.SubType = "add"
+	}
+	if p.Settings.GlobalChannelToGroup {
+		if notice.GroupID, err = idmap.StoreIDv2(data.ChannelID); err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return nil
+		}
+	}
+	mylog.Printf("子频道[%s]消息[%s]表情表态%s: %s", data.ChannelID, data.Target.ID, notice.SubType, data.Emoji.ID)
+	p.BroadcastMessageToAll(structToMap(notice))
+	return nil
+}
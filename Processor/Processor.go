@@ -11,6 +11,7 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/hoshinonyaruko/gensokyo/callapi"
 	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/httppost"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
 	"github.com/hoshinonyaruko/gensokyo/wsclient"
 	"github.com/tencent-connect/botgo/dto"
@@ -230,6 +231,16 @@ func (p *Processors) SendMessageToAllClients(message map[string]interface{}) err
 func (p *Processors) BroadcastMessageToAll(message map[string]interface{}) error {
 	var errors []string
 
+	// 发送到http上报地址 先复制一份 避免与ws发送时对message的修改并发
+	// 上报和快速操作都是异步的,不阻塞事件处理
+	for _, httpClient := range httppost.GetClients() {
+		event := make(map[string]interface{}, len(message))
+		for k, v := range message {
+			event[k] = v
+		}
+		go p.postEventToHTTP(httpClient, event)
+	}
+
 	// 发送到我们作为客户端的Wsclient
 	for _, client := range p.Wsclient {
 		err := client.SendMessage(message)
@@ -0,0 +1,172 @@
+package Processor
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/template"
+	"github.com/tencent-connect/botgo/dto"
+)
+
+// 在临时目录中使用默认配置模板和bolt后端的idmap
+func TestMain(m *testing.M) {
+	wd, err := os.Getwd()
+	if err != nil {
+		panic(err)
+	}
+	dir, err := os.MkdirTemp("", "gensokyo-processor-test")
+	if err != nil {
+		panic(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile("config.yml", []byte(template.ConfigTemplate), 0644); err != nil {
+		panic(err)
+	}
+	if _, err := config.LoadConfig(filepath.Join(dir, "config.yml")); err != nil {
+		panic(err)
+	}
+	idmap.InitializeDB()
+	code := m.Run()
+	idmap.CloseDB()
+	os.Chdir(wd)
+	os.RemoveAll(dir)
+	// url包在init中于当前目录创建的短链接数据库
+	os.Remove("gensokyo.db")
+	os.Exit(code)
+}
+
+// 记录上报的事件
+type recordingClient struct {
+	events []map[string]interface{}
+}
+
+func (c *recordingClient) SendMessage(message map[string]interface{}) error {
+	c.events = append(c.events, message)
+	return nil
+}
+
+func (c *recordingClient) Close() error { return nil }
+
+func newTestProcessors(channelToGroup bool) (*Processors, *recordingClient) {
+	client := &recordingClient{}
+	return &Processors{
+		Settings:        &config.Settings{AppID: 100, GlobalChannelToGroup: channelToGroup},
+		WsServerClients: []callapi.WebSocketServerClienter{client},
+	}, client
+}
+
+// 上报的通知类型和群号
+type groupNotice struct {
+	noticeType string
+	groupID    int64
+}
+
+func groupNotices(events []map[string]interface{}) []groupNotice {
+	var notices []groupNotice
+	for _, e := range events {
+		// structToMap经过json 数字为float64
+		groupID, _ := e["group_id"].(float64)
+		noticeType, _ := e["notice_type"].(string)
+		notices = append(notices, groupNotice{noticeType, int64(groupID)})
+	}
+	return notices
+}
+
+func TestGuildMemberEventChannelToGroup(t *testing.T) {
+	p, client := newTestProcessors(true)
+	// 子频道在收到信息或子频道事件时登记
+	c1 := p.registerChannel("member-c1", "member-g")
+	c2 := p.registerChannel("member-c2", "member-g")
+	p.registerChannel("member-other-c", "member-other-g")
+	for _, groupID := range []int64{c1, c2} {
+		if guildID, err := idmap.ReadConfigv2(fmt.Sprint(groupID), "guild_id"); err != nil || guildID != "member-g" {
+			t.Fatalf("group %d guild_id = %q, %v", groupID, guildID, err)
+		}
+	}
+
+	member := func(guildID string) *dto.WSGuildMemberData {
+		return &dto.WSGuildMemberData{GuildID: guildID, Nick: "新名片", User: &dto.User{ID: "member-u"}}
+	}
+	cases := []struct {
+		name      string
+		eventType dto.EventType
+		guildID   string
+		before    func()
+		want      []groupNotice
+	}{
+		{name: "join", eventType: dto.EventGuildMemberAdd, guildID: "member-g",
+			want: []groupNotice{{"group_increase", c1}, {"group_increase", c2}}},
+		{name: "card", eventType: dto.EventGuildMemberUpdate, guildID: "member-g",
+			want: []groupNotice{{"group_card", c1}, {"group_card", c2}}},
+		{name: "leave after channel deleted", eventType: dto.EventGuildMemberRemove, guildID: "member-g",
+			before: func() {
+				p.ProcessChannelEvent(dto.EventChannelDelete, &dto.WSChannelData{ID: "member-c2", GuildID: "member-g"})
+				client.events = nil
+			},
+			want: []groupNotice{{"group_decrease", c1}}},
+		{name: "no registered channel", eventType: dto.EventGuildMemberAdd, guildID: "member-empty-g"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.before != nil {
+				tc.before()
+			}
+			client.events = nil
+			if err := p.ProcessGuildMemberEvent(tc.eventType, member(tc.guildID)); err != nil {
+				t.Fatal(err)
+			}
+			if got := groupNotices(client.events); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("notices = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGuildMemberEventGuildNotice(t *testing.T) {
+	p, client := newTestProcessors(false)
+	if err := p.ProcessGuildMemberEvent(dto.EventGuildMemberAdd, &dto.WSGuildMemberData{GuildID: "guild-g", User: &dto.User{ID: "guild-u"}}); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.events) != 1 || client.events[0]["notice_type"] != "guild_channel_member_increase" {
+		t.Fatalf("events = %v", client.events)
+	}
+	guildID, _ := idmap.LookupIDv2("guild-g")
+	if client.events[0]["guild_id"] != float64(guildID) {
+		t.Errorf("guild_id = %v, want %d", client.events[0]["guild_id"], guildID)
+	}
+}
+
+func TestGuildEventChannelToGroup(t *testing.T) {
+	p, client := newTestProcessors(true)
+	joined := &dto.WSGuildData{ID: "bot-g", Channels: []*dto.Channel{{ID: "bot-c1"}, {ID: "bot-c2"}}}
+	if err := p.ProcessGuildEvent(dto.EventGuildCreate, joined); err != nil {
+		t.Fatal(err)
+	}
+	// 与收到子频道信息时使用同一个群号
+	c1 := p.registerChannel("bot-c1", "bot-g")
+	c2 := p.registerChannel("bot-c2", "bot-g")
+	want := []groupNotice{{"group_increase", c1}, {"group_increase", c2}}
+	if got := groupNotices(client.events); !reflect.DeepEqual(got, want) {
+		t.Errorf("join notices = %v, want %v", got, want)
+	}
+
+	client.events = nil
+	if err := p.ProcessGuildEvent(dto.EventGuildDelete, &dto.WSGuildData{ID: "bot-g"}); err != nil {
+		t.Fatal(err)
+	}
+	want = []groupNotice{{"group_decrease", c1}, {"group_decrease", c2}}
+	if got := groupNotices(client.events); !reflect.DeepEqual(got, want) {
+		t.Errorf("leave notices = %v, want %v", got, want)
+	}
+	if groups := guildGroups("bot-g"); len(groups) != 0 {
+		t.Errorf("groups after leave = %v, want none", groups)
+	}
+}
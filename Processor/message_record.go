@@ -0,0 +1,21 @@
+package Processor
+
+import (
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+)
+
+// 记录收到的消息 用于get_msg和撤回 MessageID为0时根据RealID转换
+func recordMessage(record idmap.MessageRecord) {
+	if record.MessageID == 0 {
+		messageID64, err := idmap.StoreIDv2(record.RealID)
+		if err != nil {
+			mylog.Printf("Error storing ID: %v", err)
+			return
+		}
+		record.MessageID = messageID64
+	}
+	if err := idmap.StoreMessage(record); err != nil {
+		mylog.Printf("保存消息记录失败: %v", err)
+	}
+}
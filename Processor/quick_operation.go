@@ -0,0 +1,182 @@
+// 处理http上报返回的快速操作
+package Processor
+
+import (
+	"fmt"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/httppost"
+	"github.com/hoshinonyaruko/gensokyo/message"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/stats"
+)
+
+// 快速操作禁言的默认时长 与onebot v11标准一致 30分钟
+const defaultQuickBanDuration = 30 * 60
+
+// quickOperationClient 快速操作没有调用方需要回执,只记录日志
+type quickOperationClient struct {
+	action string
+}
+
+func (c *quickOperationClient) SendMessage(message map[string]interface{}) error {
+	mylog.Printf("快速操作[%s]执行结果: %v\n", c.action, message)
+	return nil
+}
+
+// 上报事件到http地址,并执行返回的快速操作
+func (p *Processors) postEventToHTTP(client *httppost.HttpPostClient, event map[string]interface{}) {
+	operation, err := client.PostEvent(event)
+	if err != nil {
+		mylog.Printf("http上报失败: %v\n", err)
+		stats.AddPacketLost()
+		return
+	}
+	stats.AddPacketSent()
+	if len(operation) == 0 {
+		return
+	}
+	mylog.Printf("收到http上报地址[%s]的快速操作: %v\n", client.URL(), operation)
+	p.handleQuickOperation(event, operation)
+}
+
+// handleQuickOperation 执行消息事件的快速操作 reply at_sender delete ban
+// 回复复用事件的echo/request_id,从而取得echo.AddMsgIDWithKey记录的被动msg_id
+func (p *Processors) handleQuickOperation(event map[string]interface{}, operation map[string]interface{}) {
+	if postType, _ := event["post_type"].(string); postType != "message" {
+		mylog.Printf("暂不支持post_type为[%v]的快速操作\n", event["post_type"])
+		return
+	}
+	messageType, _ := event["message_type"].(string)
+	echoKey := quickOperationEchoKey(event)
+	userID := quickOperationID(event["user_id"])
+
+	if reply, ok := operation["reply"]; ok && !isEmptyReply(reply) {
+		// at_sender只对群消息生效 默认为true
+		atSender := messageType == "group" || messageType == "guild"
+		if v, ok := operation["at_sender"].(bool); ok {
+			atSender = atSender && v
+		}
+		if atSender && userID != "" {
+			reply = prependAtSender(reply, userID)
+		}
+
+		params := callapi.ParamsContent{
+			UserID:    userID,
+			GroupID:   quickOperationID(event["group_id"]),
+			Message:   reply,
+			RequestID: echoKey,
+		}
+		var action string
+		switch messageType {
+		case "group":
+			action = "send_group_msg"
+		case "private":
+			action = "send_private_msg"
+		case "guild":
+			action = "send_guild_channel_msg"
+			params.GuildID = quickOperationID(event["guild_id"])
+			params.ChannelID = quickOperationID(event["channel_id"])
+		default:
+			mylog.Printf("未知的message_type[%s],跳过快速回复\n", messageType)
+		}
+		if action != "" {
+			p.callQuickOperation(action, params, echoKey)
+		}
+	}
+
+	if del, _ := operation["delete"].(bool); del {
+		p.callQuickOperation("delete_msg", callapi.ParamsContent{
+			GroupID:   quickOperationID(event["group_id"]),
+			UserID:    userID,
+			MessageID: quickOperationID(event["message_id"]),
+		}, echoKey)
+	}
+
+	if ban, _ := operation["ban"].(bool); ban && messageType == "group" {
+		duration := defaultQuickBanDuration
+		if v, ok := operation["ban_duration"].(float64); ok && v > 0 {
+			duration = int(v)
+		}
+		p.callQuickOperation("set_group_ban", callapi.ParamsContent{
+			GroupID:  quickOperationID(event["group_id"]),
+			UserID:   userID,
+			Duration: duration,
+		}, echoKey)
+	}
+}
+
+// 以和onebot应用端调用action相同的方式执行快速操作
+func (p *Processors) callQuickOperation(action string, params callapi.ParamsContent, echoKey interface{}) {
+	if !callapi.HasHandler(action) {
+		mylog.Printf("暂不支持快速操作[%s]: 没有对应的action\n", action)
+		return
+	}
+	message := callapi.ActionMessage{
+		Action: action,
+		Params: params,
+		Echo:   echoKey,
+	}
+	if echoKey != nil {
+		message.RequestID = echoKey
+	}
+	callapi.CallAPIFromDict(&quickOperationClient{action: action}, p.Api, p.Apiv2, message)
+}
+
+// 取得事件的request_id或echo 两者都没有时返回nil
+func quickOperationEchoKey(event map[string]interface{}) interface{} {
+	if v, ok := event["request_id"].(string); ok && v != "" {
+		return v
+	}
+	if v, ok := event["echo"].(string); ok && v != "" {
+		return v
+	}
+	return nil
+}
+
+// 事件经过structToMap后数字为float64,转换为handler期望的字符串形式
+func quickOperationID(v interface{}) string {
+	switch id := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return fmt.Sprintf("%.0f", id)
+	case string:
+		return id
+	default:
+		return fmt.Sprint(id)
+	}
+}
+
+func isEmptyReply(reply interface{}) bool {
+	switch v := reply.(type) {
+	case nil:
+		return true
+	case string:
+		return v == ""
+	case []interface{}:
+		return len(v) == 0
+	}
+	return false
+}
+
+// 在回复前加上at发送者 支持字符串(CQ码)和消息段数组两种格式
+func prependAtSender(reply interface{}, userID string) interface{} {
+	switch v := reply.(type) {
+	case string:
+		return message.Message{message.At(userID), message.Text(" ")}.String() + v
+	case []interface{}:
+		at := map[string]interface{}{
+			"type": "at",
+			"data": map[string]interface{}{"qq": userID},
+		}
+		text := map[string]interface{}{
+			"type": "text",
+			"data": map[string]interface{}{"text": " "},
+		}
+		return append([]interface{}{at, text}, v...)
+	case map[string]interface{}:
+		return prependAtSender([]interface{}{v}, userID)
+	}
+	return reply
+}
@@ -20,7 +20,7 @@ type APIMessage interface {
 type RichMediaMessage struct {
 	EventID    string `json:"event_id,omitempty"`  // 要回复的事件id, 逻辑同MsgID（用于被动回复）
 	MsgID      string `json:"msg_id,omitempty"`    // 要回复的消息id（用于被动回复，与EventID二选一）
-	FileType   uint64 `json:"file_type,omitempty"` // 业务类型，图片，文件，语音，视频 文件类型，取值:1图片,2视频,3语音(目前语音只支持silk格式)
+	FileType   uint64 `json:"file_type,omitempty"` // 业务类型，图片，文件，语音，视频 文件类型，取值:1图片,2视频,3语音(目前语音只支持silk格式),4文件
 	URL        string `json:"url,omitempty"`
 	SrvSendMsg bool   `json:"srv_send_msg,omitempty"` // true:直接发送(占用主动消息频次), false:仅上传获取file_info
 	Content    string `json:"content,omitempty"`      // msg_type=7时需要填入空格
@@ -96,9 +96,9 @@ func (msg MessageReference) GetSendType() SendType {
 
 // Markdown markdown 消息
 type Markdown struct {
-	TemplateID int               `json:"template_id"` // 模版 id
-	Params     []*MarkdownParams `json:"params"`      // 模版参数
-	Content    string            `json:"content"`     // 原生 markdown
+	TemplateID int               `json:"template_id,omitempty"` // 模版 id
+	Params     []*MarkdownParams `json:"params,omitempty"`      // 模版参数
+	Content    string            `json:"content,omitempty"`     // 原生 markdown
 }
 
 // MarkdownParams markdown 模版参数 键值对
@@ -16,6 +16,7 @@ type WSPayloadBase struct {
 	OPCode OPCode    `json:"op"`
 	Seq    uint32    `json:"s,omitempty"`
 	Type   EventType `json:"t,omitempty"`
+	ID     string    `json:"id,omitempty"` // 事件id 可作为event_id被动回复
 }
 
 // 以下为发送到 websocket 的 data
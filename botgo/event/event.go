@@ -59,8 +59,19 @@ var eventParseFuncMap = map[dto.OPCode]map[dto.EventType]eventParseFunc{
 
 type eventParseFunc func(event *dto.WSPayload, message []byte) error
 
+// eventObservers 事件分发前的观察者，开发者可以用来实现事件数量的监控
+var eventObservers []func(eventType dto.EventType)
+
+// RegisterEventObserver 注册事件观察者，需要在启动前注册
+func RegisterEventObserver(observer func(eventType dto.EventType)) {
+	eventObservers = append(eventObservers, observer)
+}
+
 // ParseAndHandle 处理回调事件
 func ParseAndHandle(payload *dto.WSPayload) error {
+	for _, observer := range eventObservers {
+		observer(payload.Type)
+	}
 	// 指定类型的 handler
 	if h, ok := eventParseFuncMap[payload.OPCode][payload.Type]; ok {
 		return h(payload, payload.RawMessage)
@@ -7,6 +7,7 @@ import (
 // DefaultHandlers 默认的 handler 结构，管理所有支持的 handler 类型
 var DefaultHandlers struct {
 	Ready       ReadyHandler
+	Resumed     ResumedHandler
 	ErrorNotify ErrorNotifyHandler
 	Plain       PlainEventHandler
 
@@ -39,6 +40,9 @@ var DefaultHandlers struct {
 // ReadyHandler 可以处理 ws 的 ready 事件
 type ReadyHandler func(event *dto.WSPayload, data *dto.WSReadyData)
 
+// ResumedHandler 可以处理 ws 的 resumed 事件 即重连后恢复了原来的 session
+type ResumedHandler func(event *dto.WSPayload)
+
 // ErrorNotifyHandler 当 ws 连接发生错误的时候，会回调，方便使用方监控相关错误
 // 比如 reconnect invalidSession 等错误，错误可以转换为 bot.Err
 type ErrorNotifyHandler func(err error)
@@ -114,6 +118,8 @@ func RegisterHandlers(handlers ...interface{}) dto.Intent {
 		switch handle := h.(type) {
 		case ReadyHandler:
 			DefaultHandlers.Ready = handle
+		case ResumedHandler:
+			DefaultHandlers.Resumed = handle
 		case ErrorNotifyHandler:
 			DefaultHandlers.ErrorNotify = handle
 		case PlainEventHandler:
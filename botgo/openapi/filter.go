@@ -3,6 +3,7 @@ package openapi
 import (
 	"net/http"
 	"sync"
+	"time"
 )
 
 // 提供一组过滤器支持，开发者可以通过请求过滤器和返回过滤器，实现模调上报，耗时监控等能力。
@@ -65,3 +66,26 @@ func DoRespFilterChains(req *http.Request, resp *http.Response) error {
 	}
 	return nil
 }
+
+// RequestMetric 一次请求的结果，用于耗时和错误码监控
+type RequestMetric struct {
+	Version    string // v1 或 v2，对应发起请求的 client
+	Method     string
+	StatusCode int
+	Body       []byte // 返回包体，失败时可从中解析错误码
+	Latency    time.Duration
+}
+
+var metricObservers []func(m RequestMetric)
+
+// RegisterMetricObserver 注册请求结果观察者，需要在启动前注册
+func RegisterMetricObserver(observer func(m RequestMetric)) {
+	metricObservers = append(metricObservers, observer)
+}
+
+// DoMetricObservers 请求结束后通知所有观察者
+func DoMetricObservers(m RequestMetric) {
+	for _, observer := range metricObservers {
+		observer(m)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-resty/resty/v2" // resty 是一个优秀的 rest api 客户端，可以极大的减少开发基于 rest 标准接口求请求的封装工作量
@@ -67,8 +68,11 @@ func (o *openAPI) WithTimeout(duration time.Duration) openapi.OpenAPI {
 	return o
 }
 
-// Transport 透传请求
+// Transport 透传请求 url 以 / 开头时按正式或沙箱环境补全域名
 func (o *openAPI) Transport(ctx context.Context, method, url string, body interface{}) ([]byte, error) {
+	if strings.HasPrefix(url, "/") {
+		url = o.getURL(uri(url))
+	}
 	resp, err := o.request(ctx).SetBody(body).Execute(method, url)
 	return resp.Body(), err
 }
@@ -102,6 +106,13 @@ func (o *openAPI) setupClient() {
 		OnAfterResponse(
 			func(client *resty.Client, resp *resty.Response) error {
 				log.Infof("%v", respInfo(resp))
+				openapi.DoMetricObservers(openapi.RequestMetric{
+					Version:    "v1",
+					Method:     resp.Request.Method,
+					StatusCode: resp.StatusCode(),
+					Body:       resp.Body(),
+					Latency:    resp.Time(),
+				})
 				// 执行请求后过滤器
 				if err := openapi.DoRespFilterChains(resp.Request.RawRequest, resp.RawResponse); err != nil {
 					return err
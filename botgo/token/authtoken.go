@@ -19,6 +19,20 @@ import (
 // https://bots.qq.com/app/getAppAccessToken
 var getAccessTokenURL = "https://bots.qq.com/app/getAppAccessToken"
 
+// refreshObservers token刷新结果的观察者，开发者可以用来监控刷新成功失败次数
+var refreshObservers []func(err error)
+
+// RegisterRefreshObserver 注册token刷新结果观察者，需要在启动前注册
+func RegisterRefreshObserver(observer func(err error)) {
+	refreshObservers = append(refreshObservers, observer)
+}
+
+func notifyRefreshObservers(err error) {
+	for _, observer := range refreshObservers {
+		observer(err)
+	}
+}
+
 // AuthTokenInfo 动态鉴权Token信息
 type AuthTokenInfo struct {
 	accessToken  AccessTokenInfo
@@ -58,6 +72,7 @@ func (atoken *AuthTokenInfo) ForceUpToken(ctx context.Context, reason string) er
 func (atoken *AuthTokenInfo) StartRefreshAccessToken(ctx context.Context, tokenURL, appID, clientSecrent string) (err error) {
 	// 首先，立即获取一次AccessToken
 	tokenInfo, err := queryAccessToken(ctx, tokenURL, appID, clientSecrent)
+	notifyRefreshObservers(err)
 	if err != nil {
 		log.Errorf("无法获取AccessToken: %v", err)
 		//return err
@@ -90,6 +105,7 @@ func (atoken *AuthTokenInfo) StartRefreshAccessToken(ctx context.Context, tokenU
 				}
 				// 查询并获取新的AccessToken
 				tokenInfo, err := queryAccessToken(ctx, tokenURL, appID, clientSecrent)
+				notifyRefreshObservers(err)
 				if err == nil {
 					atoken.setAuthToken(tokenInfo)
 					log.Info("获取到的token是: %s\n", tokenInfo.Token) // 输出获取到的token
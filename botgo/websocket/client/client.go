@@ -235,6 +235,13 @@ func (c *Client) listenMessageAndHandle() {
 			c.readyHandler(payload)
 			continue
 		}
+		// resume 成功后不会再收到 ready
+		if payload.Type == "RESUMED" {
+			if event.DefaultHandlers.Resumed != nil {
+				event.DefaultHandlers.Resumed(payload)
+			}
+			continue
+		}
 		// 解析具体事件，并投递给业务注册的 handler
 		if err := event.ParseAndHandle(payload); err != nil {
 			log.Errorf("%s parseAndHandle failed, %v", c.session, err)
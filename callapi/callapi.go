@@ -3,7 +3,11 @@ package callapi
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
+	"time"
 
+	"github.com/hoshinonyaruko/gensokyo/metrics"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
 	"github.com/tencent-connect/botgo/openapi"
 )
@@ -300,8 +304,15 @@ type ParamsContent struct {
 	GroupID   interface{} `json:"group_id"`           // 每一种onebotv11实现的字段类型都可能不同
 	Message   interface{} `json:"message"`            // 这里使用interface{}因为它可能是多种类型
 	UserID    interface{} `json:"user_id"`            // 这里使用interface{}因为它可能是多种类型
+	MessageID interface{} `json:"message_id,omitempty"` // 撤回等需要message_id的action使用
+	HideTip   bool        `json:"hidetip,omitempty"`    // 撤回频道消息时隐藏小灰条
 	Duration  int         `json:"duration,omitempty"` // 可选的整数
 	Enable    bool        `json:"enable,omitempty"`   // 可选的布尔值
+	EmojiID   interface{} `json:"emoji_id,omitempty"`   // 表情表态的表情id
+	EmojiType int         `json:"emoji_type,omitempty"` // 表情类型 1为系统表情 2为emoji 不填时按emoji_id推断
+	Set       *bool       `json:"set,omitempty"`        // set_msg_emoji_like为false时取消表态
+	Count     int         `json:"count,omitempty"`      // 获取列表时的数量
+	Cookie    string      `json:"cookie,omitempty"`     // 分页游标
 	RequestID interface{} `json:"request_id,omitempty"`
 }
 
@@ -385,12 +396,67 @@ func RegisterHandler(action string, handler HandlerFunc) {
 	handlers[action] = handler
 }
 
+// HasHandler 判断action是否已注册
+func HasHandler(action string) bool {
+	_, ok := handlers[action]
+	return ok
+}
+
+// GetActions 返回所有已注册的action名称,用于http api注册路由
+func GetActions() []string {
+	actions := make([]string, 0, len(handlers))
+	for action := range handlers {
+		actions = append(actions, action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
 // CallAPIFromDict 处理信息 by calling the 对应的 handler.
 func CallAPIFromDict(client Client, api openapi.OpenAPI, apiv2 openapi.OpenAPI, message ActionMessage) {
 	handler, ok := handlers[message.Action]
 	if !ok {
 		mylog.Println("Unsupported action:", message.Action)
+		metrics.ObserveAction("unsupported", "failed", 0)
 		return
 	}
-	handler(client, api, apiv2, message)
+	recorder := &retcodeRecorder{Client: client}
+	start := time.Now()
+	handler(recorder, api, apiv2, message)
+	metrics.ObserveAction(message.Action, recorder.result(), time.Since(start))
+}
+
+// retcodeRecorder 包装Client,记录handler回执中的retcode用于统计action的成功失败
+type retcodeRecorder struct {
+	Client
+	mu        sync.Mutex
+	responded bool
+	failed    bool
+}
+
+func (r *retcodeRecorder) SendMessage(message map[string]interface{}) error {
+	r.mu.Lock()
+	r.responded = true
+	// retcode为1是异步action已受理 不算失败
+	switch retcode := message["retcode"].(type) {
+	case float64:
+		r.failed = r.failed || (retcode != 0 && retcode != 1)
+	case int:
+		r.failed = r.failed || (retcode != 0 && retcode != 1)
+	}
+	r.mu.Unlock()
+	return r.Client.SendMessage(message)
+}
+
+// 任意一次回执失败即视为失败 没有回执时为no_response
+func (r *retcodeRecorder) result() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case !r.responded:
+		return "no_response"
+	case r.failed:
+		return "failed"
+	}
+	return "ok"
 }
@@ -39,12 +39,23 @@ type Settings struct {
 	Array                  bool     `yaml:"array"`
 	Server_dir             string   `yaml:"server_dir"`
 	Lotus                  bool     `yaml:"lotus"`
+	LotusSecret            string   `yaml:"lotus_secret"`  //lotus两端共用的密钥 用于鉴权
+	LotusTimeout           int      `yaml:"lotus_timeout"` //lotus请求超时时间 单位秒
 	Port                   string   `yaml:"port"`
-	WsToken                []string `yaml:"ws_token,omitempty"`         // 连接wss时使用,不是wss可留空 一一对应
-	MasterID               []string `yaml:"master_id,omitempty"`        // 如果需要在群权限判断是管理员是,将user_id填入这里,master_id是一个文本数组
-	EnableWsServer         bool     `yaml:"enable_ws_server,omitempty"` //正向ws开关
-	WsServerToken          string   `yaml:"ws_server_token,omitempty"`  //正向ws token
-	IdentifyFile           bool     `yaml:"identify_file"`              // 域名校验文件
+	WsToken                []string `yaml:"ws_token,omitempty"`          // 连接wss时使用,不是wss可留空 一一对应
+	MasterID               []string `yaml:"master_id,omitempty"`         // 如果需要在群权限判断是管理员是,将user_id填入这里,master_id是一个文本数组
+	EnableWsServer         bool     `yaml:"enable_ws_server,omitempty"`  //正向ws开关
+	WsServerToken          string   `yaml:"ws_server_token,omitempty"`   //正向ws token
+	EnableHttpApi          bool     `yaml:"enable_http_api"`             //http api开关 与正向ws共用token
+	HttpPostAddress        []string `yaml:"http_post_address,omitempty"` //http上报地址 支持多个
+	HttpPostSecret         []string `yaml:"http_post_secret,omitempty"`  //http上报签名密钥 与地址按顺序一一对应
+	HttpPostTimeout        int      `yaml:"http_post_timeout"`           //http上报超时时间 单位秒
+	EnableWebhook          bool     `yaml:"enable_webhook"`              //使用http回调接收事件 代替websocket网关
+	WebhookPath            string   `yaml:"webhook_path"`                //http回调的路径
+	EnableMetrics          bool     `yaml:"enable_metrics"`              //prometheus监控 监听/metrics
+	IdmapBackend           string   `yaml:"idmap_backend"`               //idmap存储后端 bolt或sqlite
+	IdmapSqlitePath        string   `yaml:"idmap_sqlite_path"`           //sqlite后端的数据库文件路径
+	IdentifyFile           bool     `yaml:"identify_file"`               // 域名校验文件
 	Crt                    string   `yaml:"crt"`
 	Key                    string   `yaml:"key"`
 	DeveloperLog           bool     `yaml:"developer_log"`
@@ -64,6 +75,16 @@ type Settings struct {
 	AutoReplyMessage       string   `yaml:"auto_reply_message"`          // 自动回复的消息内容
 	CommandWhitelist       []string `yaml:"command_whitelist,omitempty"` // 指令白名单，只有这些指令会上报到ws服务器
 	ConfigAutoReload       bool     `yaml:"config_auto_reload"`          // 配置文件热加载，检测到config.yml变动时自动重启
+	GroupReplyWindow       int      `yaml:"group_reply_window"`          // 群被动回复的有效期(秒)
+	C2CReplyWindow         int      `yaml:"c2c_reply_window"`            // 单聊被动回复的有效期(秒)
+	GroupReplyFallback     []string `yaml:"group_reply_fallback"`        // 群被动回复过期后按顺序尝试的方式 event active
+	C2CReplyFallback       []string `yaml:"c2c_reply_fallback"`          // 单聊被动回复过期后按顺序尝试的方式 event active
+	SendRateGlobal         int      `yaml:"send_rate_global"`            // 所有发送每秒最多的次数
+	SendRatePassive        int      `yaml:"send_rate_passive"`           // 每个群 用户 子频道被动回复每秒最多的次数
+	SendActiveQuota        int      `yaml:"send_active_quota"`           // 每个群 用户 子频道每天最多的主动消息数
+	SendQueueWait          int      `yaml:"send_queue_wait"`             // 排队等待发送的最长时间(秒)
+	SendRetry              int      `yaml:"send_retry"`                  // 触发频率限制时的重试次数
+	AuditWait              int      `yaml:"audit_wait"`                  // 频道主动消息进入审核时等待审核结果的时间(秒)
 }
 
 // LoadConfig 从文件中加载配置并初始化单例配置
@@ -430,6 +451,33 @@ func GetLotusValue() bool {
 	return instance.Settings.Lotus
 }
 
+// 获取lotus密钥
+func GetLotusSecret() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get LotusSecret value.")
+		return ""
+	}
+	return instance.Settings.LotusSecret
+}
+
+// 获取lotus请求超时时间 默认5秒
+func GetLotusTimeout() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get LotusTimeout value.")
+		return 5
+	}
+	if instance.Settings.LotusTimeout <= 0 {
+		return 5
+	}
+	return instance.Settings.LotusTimeout
+}
+
 // 获取双向ehco
 func GetTwoWayEcho() bool {
 	mu.Lock()
@@ -569,6 +617,133 @@ func GetWsServerToken() string {
 	return instance.Settings.WsServerToken
 }
 
+// 获取http api开关
+func GetEnableHttpApi() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get EnableHttpApi value.")
+		return false
+	}
+	return instance.Settings.EnableHttpApi
+}
+
+// 获取http上报地址
+func GetHttpPostAddress() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.HttpPostAddress
+	}
+	return nil // 返回nil，如果instance为nil
+}
+
+// 获取http上报签名密钥
+func GetHttpPostSecret() []string {
+	mu.Lock()
+	defer mu.Unlock()
+	if instance != nil {
+		return instance.Settings.HttpPostSecret
+	}
+	return nil // 返回nil，如果instance为nil
+}
+
+// 获取http上报超时时间 未设置时默认5秒
+func GetHttpPostTimeout() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get HttpPostTimeout value.")
+		return 5
+	}
+	if instance.Settings.HttpPostTimeout <= 0 {
+		return 5
+	}
+	return instance.Settings.HttpPostTimeout
+}
+
+// 获取webhook开关
+func GetEnableWebhook() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get EnableWebhook value.")
+		return false
+	}
+	return instance.Settings.EnableWebhook
+}
+
+// 获取webhook回调路径 未设置时默认/webhook
+func GetWebhookPath() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil || instance.Settings.WebhookPath == "" {
+		return "/webhook"
+	}
+	if !strings.HasPrefix(instance.Settings.WebhookPath, "/") {
+		return "/" + instance.Settings.WebhookPath
+	}
+	return instance.Settings.WebhookPath
+}
+
+// 获取ClientSecret 用于webhook签名校验
+func GetClientSecret() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get ClientSecret value.")
+		return ""
+	}
+	return instance.Settings.ClientSecret
+}
+
+// 获取prometheus监控开关
+func GetEnableMetrics() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get EnableMetrics value.")
+		return false
+	}
+	return instance.Settings.EnableMetrics
+}
+
+// 获取idmap存储后端 默认bolt
+func GetIdmapBackend() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get IdmapBackend value.")
+		return "bolt"
+	}
+	if instance.Settings.IdmapBackend == "" {
+		return "bolt"
+	}
+	return instance.Settings.IdmapBackend
+}
+
+// 获取sqlite后端的数据库文件路径
+func GetIdmapSqlitePath() string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get IdmapSqlitePath value.")
+		return "idmap.sqlite"
+	}
+	if instance.Settings.IdmapSqlitePath == "" {
+		return "idmap.sqlite"
+	}
+	return instance.Settings.IdmapSqlitePath
+}
+
 // 获取identify_file的值
 func GetIdentifyFile() bool {
 	mu.Lock()
@@ -630,6 +805,143 @@ func GetImageLimit() int {
 	return instance.Settings.ImageLimit
 }
 
+// GetPassiveReplyWindow 获取群(group)或单聊(group_private)被动回复的有效期 未设置时为qq的默认值
+func GetPassiveReplyWindow(scene string) time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+
+	window, defaultWindow := 0, 300
+	if scene == "group_private" {
+		defaultWindow = 3600
+	}
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get passive reply window.")
+	} else if scene == "group_private" {
+		window = instance.Settings.C2CReplyWindow
+	} else {
+		window = instance.Settings.GroupReplyWindow
+	}
+	if window <= 0 {
+		window = defaultWindow
+	}
+	return time.Duration(window) * time.Second
+}
+
+// GetPassiveReplyFallback 获取群(group)或单聊(group_private)被动回复过期后的处理方式
+// 未设置时先尝试event_id再作为主动消息发送 设置为空数组时拒绝发送
+func GetPassiveReplyFallback(scene string) []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get passive reply fallback.")
+		return []string{"event", "active"}
+	}
+	fallback := instance.Settings.GroupReplyFallback
+	if scene == "group_private" {
+		fallback = instance.Settings.C2CReplyFallback
+	}
+	if fallback == nil {
+		return []string{"event", "active"}
+	}
+	return fallback
+}
+
+// 发送调度的默认值 配置为0时使用 小于0时不限制
+const (
+	defaultSendRateGlobal  = 20
+	defaultSendRatePassive = 5
+	defaultSendActiveQuota = 0 // 默认不限制
+	defaultSendQueueWait   = 10
+	defaultSendRetry       = 3
+)
+
+// 配置为0时使用默认值 小于0时返回0代表不限制
+func sendLimit(value, defaultValue int) int {
+	switch {
+	case value == 0:
+		return defaultValue
+	case value < 0:
+		return 0
+	}
+	return value
+}
+
+// GetSendRateGlobal 获取所有发送每秒最多的次数 配置为0时使用默认值 小于0时不限制 返回0代表不限制
+func GetSendRateGlobal() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get send rate global.")
+		return defaultSendRateGlobal
+	}
+	return sendLimit(instance.Settings.SendRateGlobal, defaultSendRateGlobal)
+}
+
+// GetSendRatePassive 获取每个目标被动回复每秒最多的次数 配置为0时使用默认值 小于0时不限制 返回0代表不限制
+func GetSendRatePassive() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get send rate passive.")
+		return defaultSendRatePassive
+	}
+	return sendLimit(instance.Settings.SendRatePassive, defaultSendRatePassive)
+}
+
+// GetSendActiveQuota 获取每个目标每天最多的主动消息数 默认值为不限制 返回0代表不限制
+func GetSendActiveQuota() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get send active quota.")
+		return defaultSendActiveQuota
+	}
+	return sendLimit(instance.Settings.SendActiveQuota, defaultSendActiveQuota)
+}
+
+// GetSendQueueWait 获取排队等待发送的最长时间 0为不等待
+func GetSendQueueWait() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get send queue wait.")
+		return defaultSendQueueWait * time.Second
+	}
+	return time.Duration(sendLimit(instance.Settings.SendQueueWait, defaultSendQueueWait)) * time.Second
+}
+
+// GetSendRetry 获取触发频率限制时的重试次数
+func GetSendRetry() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get send retry.")
+		return defaultSendRetry
+	}
+	return sendLimit(instance.Settings.SendRetry, defaultSendRetry)
+}
+
+// GetAuditWait 获取等待消息审核结果的时间 0为不等待
+func GetAuditWait() time.Duration {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if instance == nil {
+		mylog.Println("Warning: instance is nil when trying to get audit wait.")
+		return 0
+	}
+	if instance.Settings.AuditWait <= 0 {
+		return 0
+	}
+	return time.Duration(instance.Settings.AuditWait) * time.Second
+}
+
 // GetRemovePrefixValue 函数用于获取 remove_prefix 的配置值
 func GetRemovePrefixValue() bool {
 	mu.Lock()
@@ -1,6 +1,7 @@
 package echo
 
 import (
+	"fmt"
 	"strconv"
 	"sync"
 	"time"
@@ -11,6 +12,12 @@ type msgIDWithTime struct {
 	timestamp int64
 }
 
+type msgTypeWithTime struct {
+	msgType   string
+	timestamp int64
+	persisted bool // 持久化的数据会在过期后删除,内存中的类型映射保留
+}
+
 type userIDWithTime struct {
 	userID    int64
 	timestamp int64
@@ -22,22 +29,48 @@ type pendingMessage struct {
 	timestamp int64
 }
 
+type eventWithTime struct {
+	eventID   string
+	timestamp int64
+}
+
+type msgSeqWithTime struct {
+	seq       int
+	timestamp int64 // 第一次回复的时间
+}
+
+// MaxPassiveReplies 同一条消息最多被动回复的次数
+const MaxPassiveReplies = 5
+
+// echo对应的msg_id msg_seq 收到消息的时间 最近事件等被动回复数据的保留时间 与单聊被动回复的有效期一致
+// echo对应的msg_id需要和收到时间一起保留 否则过期的msg_id查不到 不会按配置回退
+const replyExpireSeconds = int64(3600)
+
+// ErrReplyLimit 同一msg_id的被动回复次数已用完
+var ErrReplyLimit = fmt.Errorf("passive reply limit reached: a message can be replied to at most %d times", MaxPassiveReplies)
+
 type EchoMapping struct {
 	mu                 sync.Mutex
-	msgTypeMapping     map[string]string
+	msgTypeMapping     map[string]msgTypeWithTime
 	msgIDMapping       map[string]msgIDWithTime   // 带时间戳
 	msgIDToUserIDMap   map[string]userIDWithTime  // 反向映射带时间戳
 	groupLatestUserMap map[int64]userIDWithTime   // GroupID -> 最近的UserID（解决OneBot不传user_id的问题）
 	groupPendingQueue  map[int64][]pendingMessage // GroupID -> 待处理消息队列（解决并发问题）
+	msgSeqMapping      map[string]msgSeqWithTime  // msg_id -> 已使用的msg_seq
+	msgTimeMapping     map[string]int64           // msg_id -> 收到消息的时间 用于判断被动回复是否过期
+	targetEventMapping map[string]eventWithTime   // 场景:群或用户 -> 最近事件的event_id
 	lastCleanup        int64                      // 上次清理时间
 }
 
 var globalEchoMapping = &EchoMapping{
-	msgTypeMapping:     make(map[string]string),
+	msgTypeMapping:     make(map[string]msgTypeWithTime),
 	msgIDMapping:       make(map[string]msgIDWithTime),
 	msgIDToUserIDMap:   make(map[string]userIDWithTime),
 	groupLatestUserMap: make(map[int64]userIDWithTime),
 	groupPendingQueue:  make(map[int64][]pendingMessage),
+	msgSeqMapping:      make(map[string]msgSeqWithTime),
+	msgTimeMapping:     make(map[string]int64),
+	targetEventMapping: make(map[string]eventWithTime),
 	lastCleanup:        time.Now().Unix(),
 }
 
@@ -50,7 +83,18 @@ func AddMsgType(appid string, s int64, msgType string) {
 	key := globalEchoMapping.GenerateKey(appid, s)
 	globalEchoMapping.mu.Lock()
 	defer globalEchoMapping.mu.Unlock()
-	globalEchoMapping.msgTypeMapping[key] = msgType
+	setMsgType(key, msgType)
+}
+
+// 记录消息类型并持久化 调用方需持有锁
+func setMsgType(key string, msgType string) {
+	data := msgTypeWithTime{
+		msgType:   msgType,
+		timestamp: time.Now().Unix(),
+		persisted: true,
+	}
+	globalEchoMapping.msgTypeMapping[key] = data
+	persistMsgType(key, data)
 }
 
 // 添加echo对应的messageid（带时间戳，自动清理过期数据）
@@ -64,6 +108,8 @@ func AddMsgID(appid string, s int64, msgID string) {
 		msgID:     msgID,
 		timestamp: now,
 	}
+	persistMsgID(key, globalEchoMapping.msgIDMapping[key])
+	setMsgTime(msgID, now)
 
 	// 每10分钟清理一次过期数据（被动回复5分钟有效期，保留双倍时间）
 	if now-globalEchoMapping.lastCleanup > 600 {
@@ -76,7 +122,7 @@ func AddMsgID(appid string, s int64, msgID string) {
 func GetMsgTypeByKey(key string) string {
 	globalEchoMapping.mu.Lock()
 	defer globalEchoMapping.mu.Unlock()
-	return globalEchoMapping.msgTypeMapping[key]
+	return globalEchoMapping.msgTypeMapping[key].msgType
 }
 
 // 根据给定的key获取消息ID
@@ -97,6 +143,7 @@ func AddMsgIDToUserID(msgID string, userID int64) {
 		userID:    userID,
 		timestamp: time.Now().Unix(),
 	}
+	persistMsgUser(msgID, globalEchoMapping.msgIDToUserIDMap[msgID])
 }
 
 // 根据MessageID获取UserID
@@ -117,6 +164,7 @@ func SetGroupLatestUser(groupID int64, userID int64) {
 		userID:    userID,
 		timestamp: time.Now().Unix(),
 	}
+	persistGroupLatest(groupID, globalEchoMapping.groupLatestUserMap[groupID])
 }
 
 // 添加待处理消息到群组队列（解决并发问题）
@@ -131,6 +179,7 @@ func AddGroupPendingMessage(groupID int64, userID int64, msgID string) {
 	}
 
 	globalEchoMapping.groupPendingQueue[groupID] = append(globalEchoMapping.groupPendingQueue[groupID], msg)
+	persistGroupPending(groupID, globalEchoMapping.groupPendingQueue[groupID])
 }
 
 // 获取并移除群组最早的待处理消息（FIFO，解决并发问题）
@@ -153,6 +202,7 @@ func PopGroupPendingMessage(groupID int64) (userID int64, msgID string) {
 	if len(globalEchoMapping.groupPendingQueue[groupID]) == 0 {
 		delete(globalEchoMapping.groupPendingQueue, groupID)
 	}
+	persistGroupPending(groupID, globalEchoMapping.groupPendingQueue[groupID])
 
 	return msg.userID, msg.msgID
 }
@@ -167,18 +217,92 @@ func GetGroupLatestUser(groupID int64) int64 {
 	return 0
 }
 
+// NextMsgSeq 取得被动回复msg_id时使用的下一个msg_seq 从1开始递增
+// 相同msg_id和msg_seq的回复会被qq视为重复 超过回复次数时返回ErrReplyLimit
+// msgID为空(主动消息)时返回0
+func NextMsgSeq(msgID string) (int, error) {
+	if msgID == "" {
+		return 0, nil
+	}
+	globalEchoMapping.mu.Lock()
+	defer globalEchoMapping.mu.Unlock()
+
+	data, ok := globalEchoMapping.msgSeqMapping[msgID]
+	if !ok {
+		data.timestamp = time.Now().Unix()
+	}
+	if data.seq >= MaxPassiveReplies {
+		return 0, ErrReplyLimit
+	}
+	data.seq++
+	globalEchoMapping.msgSeqMapping[msgID] = data
+	persistMsgSeq(msgID, data)
+	return data.seq, nil
+}
+
+// 记录第一次收到msg_id的时间 调用方需持有锁
+func setMsgTime(msgID string, now int64) {
+	if _, ok := globalEchoMapping.msgTimeMapping[msgID]; ok || msgID == "" {
+		return
+	}
+	globalEchoMapping.msgTimeMapping[msgID] = now
+	persist(storeMsgTime, msgID, storedRecord{Timestamp: now})
+}
+
+// GetMsgTime 获取收到msg_id的时间 没有记录时返回false
+func GetMsgTime(msgID string) (int64, bool) {
+	globalEchoMapping.mu.Lock()
+	defer globalEchoMapping.mu.Unlock()
+	timestamp, ok := globalEchoMapping.msgTimeMapping[msgID]
+	return timestamp, ok
+}
+
+// AddTargetEvent 记录群或用户最近一次事件的event_id scene为group或group_private
+// msg_id过期后 可以用仍在有效期内的event_id被动回复
+func AddTargetEvent(scene, target, eventID string) {
+	if eventID == "" {
+		return
+	}
+	key := scene + ":" + target
+	globalEchoMapping.mu.Lock()
+	defer globalEchoMapping.mu.Unlock()
+	globalEchoMapping.targetEventMapping[key] = eventWithTime{
+		eventID:   eventID,
+		timestamp: time.Now().Unix(),
+	}
+	persist(storeTargetEvent, key, storedRecord{Value: eventID, Timestamp: time.Now().Unix()})
+}
+
+// GetTargetEvent 获取群或用户最近一次事件的event_id和收到的时间
+func GetTargetEvent(scene, target string) (string, int64, bool) {
+	globalEchoMapping.mu.Lock()
+	defer globalEchoMapping.mu.Unlock()
+	data, ok := globalEchoMapping.targetEventMapping[scene+":"+target]
+	return data.eventID, data.timestamp, ok
+}
+
 // 清理过期的映射数据（超过10分钟的数据）
 func cleanupExpiredMappings() {
 	globalEchoMapping.mu.Lock()
 	defer globalEchoMapping.mu.Unlock()
 
 	now := time.Now().Unix()
-	expireTime := int64(600) // 10分钟过期
+	expireTime := expireSeconds // 10分钟过期
 
-	// 清理msgIDMapping中的过期数据
+	// 清理msgIDMapping中的过期数据 保留到被动回复数据过期
 	for key, data := range globalEchoMapping.msgIDMapping {
-		if now-data.timestamp > expireTime {
+		if now-data.timestamp > replyExpireSeconds {
 			delete(globalEchoMapping.msgIDMapping, key)
+			persist(storeMsgID, key, nil)
+		}
+	}
+
+	// 类型映射在内存中保留(用于主动消息判断类型),只删除过期的持久化数据
+	for key, data := range globalEchoMapping.msgTypeMapping {
+		if data.persisted && now-data.timestamp > expireTime {
+			data.persisted = false
+			globalEchoMapping.msgTypeMapping[key] = data
+			persist(storeMsgType, key, nil)
 		}
 	}
 
@@ -186,6 +310,7 @@ func cleanupExpiredMappings() {
 	for msgID, data := range globalEchoMapping.msgIDToUserIDMap {
 		if now-data.timestamp > expireTime {
 			delete(globalEchoMapping.msgIDToUserIDMap, msgID)
+			persist(storeMsgUser, msgID, nil)
 		}
 	}
 
@@ -193,6 +318,28 @@ func cleanupExpiredMappings() {
 	for groupID, data := range globalEchoMapping.groupLatestUserMap {
 		if now-data.timestamp > expireTime {
 			delete(globalEchoMapping.groupLatestUserMap, groupID)
+			persist(storeGroupLatest, strconv.FormatInt(groupID, 10), nil)
+		}
+	}
+
+	// 清理msgSeqMapping中的过期数据 保留到单聊被动回复失效
+	for msgID, data := range globalEchoMapping.msgSeqMapping {
+		if now-data.timestamp > replyExpireSeconds {
+			delete(globalEchoMapping.msgSeqMapping, msgID)
+			persist(storeMsgSeq, msgID, nil)
+		}
+	}
+
+	for msgID, timestamp := range globalEchoMapping.msgTimeMapping {
+		if now-timestamp > replyExpireSeconds {
+			delete(globalEchoMapping.msgTimeMapping, msgID)
+			persist(storeMsgTime, msgID, nil)
+		}
+	}
+	for key, data := range globalEchoMapping.targetEventMapping {
+		if now-data.timestamp > replyExpireSeconds {
+			delete(globalEchoMapping.targetEventMapping, key)
+			persist(storeTargetEvent, key, nil)
 		}
 	}
 
@@ -215,6 +362,7 @@ func cleanupExpiredMappings() {
 			} else {
 				delete(globalEchoMapping.groupPendingQueue, groupID)
 			}
+			persistGroupPending(groupID, newQueue)
 		}
 	}
 }
@@ -228,6 +376,8 @@ func AddMsgIDWithKey(key string, msgID string) {
 		msgID:     msgID,
 		timestamp: now,
 	}
+	persistMsgID(key, globalEchoMapping.msgIDMapping[key])
+	setMsgTime(msgID, now)
 	if now-globalEchoMapping.lastCleanup > 600 {
 		go cleanupExpiredMappings()
 		globalEchoMapping.lastCleanup = now
@@ -238,5 +388,5 @@ func AddMsgIDWithKey(key string, msgID string) {
 func AddMsgTypeWithKey(key string, msgType string) {
 	globalEchoMapping.mu.Lock()
 	defer globalEchoMapping.mu.Unlock()
-	globalEchoMapping.msgTypeMapping[key] = msgType
+	setMsgType(key, msgType)
 }
@@ -0,0 +1,41 @@
+package echo
+
+import (
+	"testing"
+	"time"
+)
+
+// 清理后echo对应的msg_id和收到时间一起保留 过期的msg_id仍能按配置回退
+func TestCleanupKeepsMsgIDWithReplyData(t *testing.T) {
+	cases := []struct {
+		name string
+		age  int64
+		kept bool
+	}{
+		{"fresh", 0, true},
+		{"past echo expiry", expireSeconds + 100, true},
+		{"within reply data", replyExpireSeconds - 100, true},
+		{"expired", replyExpireSeconds + 100, false},
+	}
+	now := time.Now().Unix()
+	globalEchoMapping.mu.Lock()
+	for _, tc := range cases {
+		globalEchoMapping.msgIDMapping["echo-"+tc.name] = msgIDWithTime{msgID: "msg-" + tc.name, timestamp: now - tc.age}
+		globalEchoMapping.msgTimeMapping["msg-"+tc.name] = now - tc.age
+	}
+	globalEchoMapping.mu.Unlock()
+
+	cleanupExpiredMappings()
+
+	for _, tc := range cases {
+		msgID := GetMsgIDByKey("echo-" + tc.name)
+		_, hasTime := GetMsgTime("msg-" + tc.name)
+		if kept := msgID != ""; kept != tc.kept {
+			t.Errorf("%s: msg_id kept = %v, want %v", tc.name, kept, tc.kept)
+		}
+		// 两者同时保留或同时清理
+		if hasTime != tc.kept {
+			t.Errorf("%s: msg time kept = %v, want %v", tc.name, hasTime, tc.kept)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package echo
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/metrics"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+)
+
+// 持久化时使用的分类(bolt中对应不同的bucket)
+const (
+	storeMsgID        = "msg_id"
+	storeMsgType      = "msg_type"
+	storeMsgUser      = "msg_user"
+	storeGroupLatest  = "group_latest"
+	storeGroupPending = "group_pending"
+	storeMsgSeq       = "msg_seq"
+	storeMsgTime      = "msg_time"
+	storeTargetEvent  = "target_event"
+)
+
+// 与内存中的过期时间一致 10分钟
+const expireSeconds = int64(600)
+
+// StoreOp 一次写入或删除 Value为nil时表示删除
+type StoreOp struct {
+	Bucket string
+	Key    string
+	Value  []byte
+}
+
+// Store echo映射的持久化后端 用于重启后恢复被动回复需要的msg_id等状态
+type Store interface {
+	// Apply 在一个事务中执行一组写入和删除
+	Apply(ops []StoreOp) error
+	// ForEach 遍历某个分类下的所有数据
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+}
+
+// 持久化的记录 v为msg_id或类型 u为userID m为msg_id s为msg_seq t为时间戳
+type storedRecord struct {
+	Value     string `json:"v,omitempty"`
+	UserID    int64  `json:"u,omitempty"`
+	MsgID     string `json:"m,omitempty"`
+	Seq       int    `json:"s,omitempty"`
+	Timestamp int64  `json:"t"`
+}
+
+var (
+	storeMu   sync.Mutex
+	store     Store
+	storeCh   chan StoreOp
+	storeDone chan struct{}
+)
+
+// SetStore 设置持久化后端,从中恢复未过期的数据,并启动后台写入
+// 需要在收到事件之前调用
+func SetStore(s Store) error {
+	// 恢复数据时会持有globalEchoMapping.mu,不能同时持有storeMu,否则会与persist形成相反的加锁顺序
+	if err := loadFromStore(s); err != nil {
+		return err
+	}
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if store != nil {
+		return nil
+	}
+	store = s
+	storeCh = make(chan StoreOp, 4096)
+	storeDone = make(chan struct{})
+	go runStoreWriter(s, storeCh, storeDone)
+	return nil
+}
+
+// CloseStore 写入剩余的数据并停止后台写入 需要在关闭数据库之前调用
+func CloseStore() {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if store == nil {
+		return
+	}
+	close(storeCh)
+	<-storeDone
+	store = nil
+}
+
+// 将变更放入写入队列 不阻塞消息处理
+func persist(bucket, key string, value interface{}) {
+	storeMu.Lock()
+	defer storeMu.Unlock()
+	if store == nil {
+		return
+	}
+	op := StoreOp{Bucket: bucket, Key: key}
+	if value != nil {
+		data, err := json.Marshal(value)
+		if err != nil {
+			mylog.Printf("echo持久化序列化失败: %v", err)
+			return
+		}
+		op.Value = data
+	}
+	select {
+	case storeCh <- op:
+	default:
+		// 写入跟不上时丢弃 重启后这条映射无法恢复
+		metrics.ObserveEchoPersistDropped(bucket)
+		mylog.Printf("echo持久化队列已满,丢弃[%s:%s]", bucket, key)
+	}
+}
+
+// 后台写入 把已经排队的变更合并到一个事务里
+func runStoreWriter(s Store, ch chan StoreOp, done chan struct{}) {
+	defer close(done)
+	for op := range ch {
+		ops := []StoreOp{op}
+	drain:
+		for len(ops) < 256 {
+			select {
+			case next, ok := <-ch:
+				if !ok {
+					break drain
+				}
+				ops = append(ops, next)
+			default:
+				break drain
+			}
+		}
+		if err := s.Apply(ops); err != nil {
+			mylog.Printf("echo持久化写入失败: %v", err)
+		}
+	}
+}
+
+// 从持久化后端恢复未过期的数据 过期的数据顺便删除
+func loadFromStore(s Store) error {
+	now := time.Now().Unix()
+	var expired []StoreOp
+	var loaded int
+
+	load := func(bucket string, fn func(key string, value []byte) bool) error {
+		return s.ForEach(bucket, func(key string, value []byte) error {
+			if fn(key, value) {
+				loaded++
+			} else {
+				expired = append(expired, StoreOp{Bucket: bucket, Key: key})
+			}
+			return nil
+		})
+	}
+
+	globalEchoMapping.mu.Lock()
+	defer globalEchoMapping.mu.Unlock()
+
+	err := load(storeMsgID, func(key string, value []byte) bool {
+		var r storedRecord
+		if json.Unmarshal(value, &r) != nil || now-r.Timestamp > replyExpireSeconds {
+			return false
+		}
+		globalEchoMapping.msgIDMapping[key] = msgIDWithTime{msgID: r.Value, timestamp: r.Timestamp}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	err = load(storeMsgType, func(key string, value []byte) bool {
+		var r storedRecord
+		if json.Unmarshal(value, &r) != nil || now-r.Timestamp > expireSeconds {
+			return false
+		}
+		globalEchoMapping.msgTypeMapping[key] = msgTypeWithTime{msgType: r.Value, timestamp: r.Timestamp, persisted: true}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	err = load(storeMsgUser, func(key string, value []byte) bool {
+		var r storedRecord
+		if json.Unmarshal(value, &r) != nil || now-r.Timestamp > expireSeconds {
+			return false
+		}
+		globalEchoMapping.msgIDToUserIDMap[key] = userIDWithTime{userID: r.UserID, timestamp: r.Timestamp}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	err = load(storeGroupLatest, func(key string, value []byte) bool {
+		var r storedRecord
+		groupID, convErr := strconv.ParseInt(key, 10, 64)
+		if convErr != nil || json.Unmarshal(value, &r) != nil || now-r.Timestamp > expireSeconds {
+			return false
+		}
+		globalEchoMapping.groupLatestUserMap[groupID] = userIDWithTime{userID: r.UserID, timestamp: r.Timestamp}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	err = load(storeGroupPending, func(key string, value []byte) bool {
+		var records []storedRecord
+		groupID, convErr := strconv.ParseInt(key, 10, 64)
+		if convErr != nil || json.Unmarshal(value, &records) != nil {
+			return false
+		}
+		var queue []pendingMessage
+		for _, r := range records {
+			if now-r.Timestamp <= expireSeconds {
+				queue = append(queue, pendingMessage{userID: r.UserID, msgID: r.MsgID, timestamp: r.Timestamp})
+			}
+		}
+		if len(queue) == 0 {
+			return false
+		}
+		globalEchoMapping.groupPendingQueue[groupID] = queue
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	err = load(storeMsgSeq, func(key string, value []byte) bool {
+		var r storedRecord
+		if json.Unmarshal(value, &r) != nil || now-r.Timestamp > replyExpireSeconds {
+			return false
+		}
+		globalEchoMapping.msgSeqMapping[key] = msgSeqWithTime{seq: r.Seq, timestamp: r.Timestamp}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	err = load(storeMsgTime, func(key string, value []byte) bool {
+		var r storedRecord
+		if json.Unmarshal(value, &r) != nil || now-r.Timestamp > replyExpireSeconds {
+			return false
+		}
+		globalEchoMapping.msgTimeMapping[key] = r.Timestamp
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	err = load(storeTargetEvent, func(key string, value []byte) bool {
+		var r storedRecord
+		if json.Unmarshal(value, &r) != nil || now-r.Timestamp > replyExpireSeconds {
+			return false
+		}
+		globalEchoMapping.targetEventMapping[key] = eventWithTime{eventID: r.Value, timestamp: r.Timestamp}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(expired) > 0 {
+		if err := s.Apply(expired); err != nil {
+			mylog.Printf("清理过期的echo持久化数据失败: %v", err)
+		}
+	}
+	mylog.Printf("从持久化存储恢复了%d条echo映射,清理了%d条过期数据", loaded, len(expired))
+	return nil
+}
+
+// 以下为内存数据到持久化记录的转换 调用方需持有globalEchoMapping.mu
+
+func persistMsgID(key string, data msgIDWithTime) {
+	persist(storeMsgID, key, storedRecord{Value: data.msgID, Timestamp: data.timestamp})
+}
+
+func persistMsgType(key string, data msgTypeWithTime) {
+	persist(storeMsgType, key, storedRecord{Value: data.msgType, Timestamp: data.timestamp})
+}
+
+func persistMsgUser(msgID string, data userIDWithTime) {
+	persist(storeMsgUser, msgID, storedRecord{UserID: data.userID, Timestamp: data.timestamp})
+}
+
+func persistGroupLatest(groupID int64, data userIDWithTime) {
+	persist(storeGroupLatest, strconv.FormatInt(groupID, 10), storedRecord{UserID: data.userID, Timestamp: data.timestamp})
+}
+
+func persistMsgSeq(msgID string, data msgSeqWithTime) {
+	persist(storeMsgSeq, msgID, storedRecord{Seq: data.seq, Timestamp: data.timestamp})
+}
+
+// 队列为空时删除
+func persistGroupPending(groupID int64, queue []pendingMessage) {
+	key := strconv.FormatInt(groupID, 10)
+	if len(queue) == 0 {
+		persist(storeGroupPending, key, nil)
+		return
+	}
+	records := make([]storedRecord, 0, len(queue))
+	for _, msg := range queue {
+		records = append(records, storedRecord{UserID: msg.userID, MsgID: msg.msgID, Timestamp: msg.timestamp})
+	}
+	persist(storeGroupPending, key, records)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+// 异步发送的队列数和每个队列的长度 同一目标的消息进入同一队列 保持发送顺序
+const (
+	asyncQueueCount = 8
+	asyncQueueSize  = 256
+)
+
+var errAsyncQueueFull = errors.New("send queue is full")
+
+var (
+	asyncQueues    [asyncQueueCount]chan func()
+	asyncQueueOnce sync.Once
+)
+
+func startAsyncQueues() {
+	for i := range asyncQueues {
+		asyncQueues[i] = make(chan func(), asyncQueueSize)
+		go func(queue chan func()) {
+			for task := range queue {
+				task()
+			}
+		}(asyncQueues[i])
+	}
+}
+
+// 按group_id user_id channel_id选择队列
+func asyncQueueIndex(params callapi.ParamsContent) int {
+	h := fnv.New32a()
+	fmt.Fprint(h, params.GroupID, params.UserID, params.ChannelID)
+	return int(h.Sum32() % asyncQueueCount)
+}
+
+// asyncHandler 将同步的发送action包装为异步 加入队列后立即返回status为async的回执
+// 实际发送的回执不再返回给应用 失败时记录日志
+func asyncHandler(handler callapi.HandlerFunc) callapi.HandlerFunc {
+	return func(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.OpenAPI, message callapi.ActionMessage) {
+		asyncQueueOnce.Do(startAsyncQueues)
+		task := func() {
+			handler(asyncClient{action: message.Action}, api, apiv2, message)
+		}
+		select {
+		case asyncQueues[asyncQueueIndex(message.Params)] <- task:
+			sendAsyncResponse(client, &message, nil)
+		default:
+			mylog.Printf("异步发送队列已满 丢弃%s", message.Action)
+			sendAsyncResponse(client, &message, errAsyncQueueFull)
+		}
+	}
+}
+
+// 异步action的回执 受理时retcode为1
+func sendAsyncResponse(client callapi.Client, message *callapi.ActionMessage, err error) error {
+	response := ServerResponse{}
+	if config.GetUseRequestID() {
+		response.RequestID = callapi.GetActionEchoKey(*message)
+	} else {
+		response.Echo = message.Echo
+	}
+	if err != nil {
+		response.Message = err.Error()
+		response.RetCode = 100
+		response.Status = "failed"
+	} else {
+		response.RetCode = 1
+		response.Status = "async"
+	}
+	return deliverResponse(client, response)
+}
+
+// asyncClient 接收异步发送完成后的回执 只记录失败
+type asyncClient struct {
+	action string
+}
+
+func (c asyncClient) SendMessage(message map[string]interface{}) error {
+	if message["status"] == "failed" {
+		mylog.Printf("异步%s发送失败: %v", c.action, message["message"])
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/stats"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/errs"
+)
+
+// 消息审核中的错误码 信息会在审核通过后发出
+const auditPendingCode = 304023
+
+// errAuditRejected 频道主动消息审核不通过
+var errAuditRejected = errors.New("消息审核不通过")
+
+// 超过这个时间没有收到审核事件的记录会被清理
+const auditExpire = time.Hour
+
+// pendingAudit 等待审核结果的一次发送
+type pendingAudit struct {
+	auditID   string
+	record    idmap.MessageRecord // 审核通过后以最终的message_id保存
+	action    string              // 发起发送的action
+	echo      interface{}         // 发起发送的action的echo或request_id
+	createdAt time.Time
+	done      chan AuditResult
+}
+
+// AuditResult 消息审核的结果
+type AuditResult struct {
+	AuditID   string
+	Passed    bool
+	MessageID int64       // 审核通过后经过idmap转换的message_id
+	Action    string      // 发起发送的action 找不到对应的发送时为空
+	Echo      interface{} // 发起发送的action的echo或request_id
+}
+
+var (
+	auditMu       sync.Mutex
+	pendingAudits = make(map[string]*pendingAudit)
+)
+
+// 开放平台返回的审核中错误 data中带有audit_id
+func auditIDFromError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var body struct {
+		Code int `json:"code"`
+		Data struct {
+			MessageAudit struct {
+				AuditID string `json:"audit_id"`
+			} `json:"message_audit"`
+		} `json:"data"`
+	}
+	if json.Unmarshal([]byte(errs.Error(err).Text()), &body) != nil || body.Code != auditPendingCode {
+		return ""
+	}
+	return body.Data.MessageAudit.AuditID
+}
+
+// 登记进入审核的发送 审核事件可能很快到达 所以在收到错误后立即登记
+func registerAudit(auditID string, record idmap.MessageRecord) *pendingAudit {
+	audit := &pendingAudit{
+		auditID:   auditID,
+		record:    record,
+		createdAt: time.Now(),
+		done:      make(chan AuditResult, 1),
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	for id, pending := range pendingAudits {
+		if time.Since(pending.createdAt) > auditExpire {
+			delete(pendingAudits, id)
+		}
+	}
+	pendingAudits[auditID] = audit
+	mylog.Printf("信息进入审核 audit_id: %s", auditID)
+	return audit
+}
+
+// 记录发起发送的action 审核通知中带上它的echo或request_id
+func (a *pendingAudit) attach(message *callapi.ActionMessage) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	a.action = message.Action
+	if config.GetUseRequestID() {
+		a.echo = callapi.GetActionEchoKey(*message)
+	} else {
+		a.echo = message.Echo
+	}
+}
+
+// 等待审核结果 超时返回false
+func (a *pendingAudit) wait(deadline time.Time) (AuditResult, bool) {
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case result := <-a.done:
+		return result, true
+	case <-timer.C:
+		return AuditResult{}, false
+	}
+}
+
+// CompleteAudit 收到审核通过或不通过的事件时调用 通过时保存最终的消息记录
+// 返回对应的发送 等待审核结果的action会同时收到结果
+func CompleteAudit(audit *dto.MessageAudit, passed bool) AuditResult {
+	auditMu.Lock()
+	pending, ok := pendingAudits[audit.AuditID]
+	delete(pendingAudits, audit.AuditID)
+	result := AuditResult{AuditID: audit.AuditID, Passed: passed}
+	if ok {
+		result.Action = pending.action
+		result.Echo = pending.echo
+	}
+	auditMu.Unlock()
+
+	if passed {
+		stats.AddMessageSent()
+	}
+	if passed && audit.MessageID != "" {
+		if ok {
+			result.MessageID = recordSentMessage(&dto.Message{ID: audit.MessageID}, pending.record)
+		} else if messageID64, err := idmap.StoreIDv2(audit.MessageID); err == nil {
+			result.MessageID = messageID64
+		} else {
+			mylog.Printf("Error storing ID: %v", err)
+		}
+	}
+	if ok {
+		pending.done <- result
+	} else {
+		mylog.Printf("审核结果没有对应的发送 audit_id: %s", audit.AuditID)
+	}
+	return result
+}
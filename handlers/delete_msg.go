@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+type DeleteMsgResponse struct {
+	Data      interface{} `json:"data"`
+	Message   string      `json:"message"`
+	RetCode   int         `json:"retcode"`
+	Status    string      `json:"status"`
+	Echo      interface{} `json:"echo,omitempty"`
+	RequestID interface{} `json:"request_id,omitempty"`
+}
+
+func init() {
+	callapi.RegisterHandler("delete_msg", handleDeleteMsg)
+}
+
+func handleDeleteMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.OpenAPI, message callapi.ActionMessage) {
+	var response DeleteMsgResponse
+	if config.GetUseRequestID() {
+		response.RequestID = callapi.GetActionEchoKey(message)
+	} else {
+		response.Echo = message.Echo
+	}
+
+	record, err := getMessageRecord(message.Params.MessageID)
+	if err != nil {
+		// 找不到消息视为参数错误
+		mylog.Printf("delete_msg: %v", err)
+		response.Message = err.Error()
+		response.RetCode = 100
+		response.Status = "failed"
+	} else if err = retractMessage(api, apiv2, record, message.Params.HideTip); err != nil {
+		mylog.Printf("撤回消息失败: %v", err)
+		response.Message = sanitizeErrorMessage(err)
+		response.RetCode = -1
+		response.Status = "failed"
+	} else {
+		mylog.Printf("撤回消息成功 message_id: %d real_id: %s", record.MessageID, record.RealID)
+		if _, err := idmap.MarkMessageDeleted(record.MessageID, int64(config.GetAppID())); err != nil {
+			mylog.Printf("标记消息撤回失败: %v", err)
+		}
+		response.Status = "ok"
+	}
+
+	outputMap := structToMap(response)
+	if err := client.SendMessage(outputMap); err != nil {
+		mylog.Printf("Error sending message via client: %v", err)
+	} else {
+		mylog.Printf("响应delete_msg: %+v", outputMap)
+	}
+}
+
+// 根据消息所在的场景选择撤回接口
+func retractMessage(api openapi.OpenAPI, apiv2 openapi.OpenAPI, record idmap.MessageRecord, hideTip bool) error {
+	var options []openapi.RetractMessageOption
+	if hideTip {
+		options = append(options, openapi.RetractMessageOptionHidetip)
+	}
+	switch record.Scene {
+	case "guild":
+		return api.RetractMessage(context.TODO(), record.Target, record.RealID, options...)
+	case "guild_private":
+		return api.RetractDMMessage(context.TODO(), record.GuildID, record.RealID, options...)
+	case "group":
+		// 群和单聊的撤回sdk未封装 使用Transport透传 只传路径 域名随沙箱配置
+		_, err := apiv2.Transport(context.TODO(), "DELETE",
+			fmt.Sprintf("/v2/groups/%s/messages/%s", record.Target, record.RealID), nil)
+		return err
+	case "group_private":
+		_, err := apiv2.Transport(context.TODO(), "DELETE",
+			fmt.Sprintf("/v2/users/%s/messages/%s", record.Target, record.RealID), nil)
+		return err
+	default:
+		return fmt.Errorf("unknown message scene: %s", record.Scene)
+	}
+}
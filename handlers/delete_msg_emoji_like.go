@@ -0,0 +1,20 @@
+package handlers
+
+import (
+	"context"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+func init() {
+	callapi.RegisterHandler("delete_msg_emoji_like", handleDeleteMsgEmojiLike)
+}
+
+// 只能删除机器人自己的表态
+func handleDeleteMsgEmojiLike(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.OpenAPI, message callapi.ActionMessage) {
+	handleMsgEmojiLike(client, api, message, func(channelID, messageID string, emoji dto.Emoji) error {
+		return api.DeleteOwnMessageReaction(context.TODO(), channelID, messageID, emoji)
+	})
+}
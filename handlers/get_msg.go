@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/message"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+type GetMsgResponse struct {
+	Data      *GetMsgData `json:"data"`
+	Message   string      `json:"message"`
+	RetCode   int         `json:"retcode"`
+	Status    string      `json:"status"`
+	Echo      interface{} `json:"echo,omitempty"`
+	RequestID interface{} `json:"request_id,omitempty"`
+}
+
+type GetMsgData struct {
+	Time        int64        `json:"time"`
+	MessageType string       `json:"message_type"`
+	MessageID   int64        `json:"message_id"`
+	RealID      string       `json:"real_id"`
+	GroupID     int64        `json:"group_id,omitempty"`
+	ChannelID   string       `json:"channel_id,omitempty"`
+	GuildID     string       `json:"guild_id,omitempty"`
+	Sender      GetMsgSender `json:"sender"`
+	Message     interface{}  `json:"message"`
+	RawMessage  string       `json:"raw_message"`
+	Deleted     bool         `json:"deleted,omitempty"` // 消息已被撤回
+}
+
+type GetMsgSender struct {
+	UserID   int64  `json:"user_id"`
+	Nickname string `json:"nickname"`
+}
+
+func init() {
+	callapi.RegisterHandler("get_msg", handleGetMsg)
+}
+
+func handleGetMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.OpenAPI, message callapi.ActionMessage) {
+	var response GetMsgResponse
+	if config.GetUseRequestID() {
+		response.RequestID = callapi.GetActionEchoKey(message)
+	} else {
+		response.Echo = message.Echo
+	}
+
+	record, err := getMessageRecord(message.Params.MessageID)
+	if err != nil {
+		mylog.Printf("get_msg: %v", err)
+		response.Message = err.Error()
+		response.RetCode = 100
+		response.Status = "failed"
+	} else {
+		response.Data = messageRecordToData(record)
+		response.Status = "ok"
+	}
+
+	outputMap := structToMap(response)
+	if err := client.SendMessage(outputMap); err != nil {
+		mylog.Printf("Error sending message via client: %v", err)
+	} else {
+		mylog.Printf("响应get_msg: %+v", outputMap)
+	}
+}
+
+// 根据message_id取出消息记录 也接受开放平台的原始消息id
+func getMessageRecord(messageID interface{}) (idmap.MessageRecord, error) {
+	var messageID64 int64
+	switch v := messageID.(type) {
+	case float64:
+		messageID64 = int64(v)
+	case int:
+		messageID64 = int64(v)
+	case int64:
+		messageID64 = v
+	case string:
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			// 频道消息上报的是原始id 只查询已有的映射
+			id, err = idmap.LookupIDv2(v)
+			if err == idmap.ErrKeyNotFound {
+				return idmap.MessageRecord{}, fmt.Errorf("message %s not found", v)
+			} else if err != nil {
+				return idmap.MessageRecord{}, err
+			}
+		}
+		messageID64 = id
+	default:
+		return idmap.MessageRecord{}, fmt.Errorf("invalid message_id: %v", messageID)
+	}
+
+	record, err := idmap.GetMessage(messageID64)
+	if err == idmap.ErrKeyNotFound {
+		return record, fmt.Errorf("message %d not found", messageID64)
+	}
+	return record, err
+}
+
+// 将消息记录转换为onebot的消息对象
+func messageRecordToData(record idmap.MessageRecord) *GetMsgData {
+	data := &GetMsgData{
+		Time:        record.Time,
+		MessageType: record.MessageType,
+		MessageID:   record.MessageID,
+		RealID:      record.RealID,
+		GroupID:     record.GroupID,
+		Sender: GetMsgSender{
+			UserID:   record.UserID,
+			Nickname: record.Nickname,
+		},
+		Message:    record.Content,
+		RawMessage: record.Content,
+		Deleted:    record.Deleted,
+	}
+	if record.MessageType == "guild" {
+		data.ChannelID = record.Target
+		data.GuildID = record.GuildID
+	}
+	if config.GetArrayValue() {
+		data.Message = message.ParseCQ(record.Content).Array()
+	}
+	return data
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+// 默认返回的数量和每次请求的数量
+const (
+	defaultEmojiLikeCount = 20
+	emojiLikePageSize     = 50
+)
+
+type EmojiLikeUsersData struct {
+	Users  []EmojiLikeUser `json:"users"`
+	Cookie string          `json:"cookie"` // 继续获取时传入的分页游标
+	IsEnd  bool            `json:"is_end"`
+}
+
+type EmojiLikeUser struct {
+	UserID   int64  `json:"user_id"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+}
+
+func init() {
+	callapi.RegisterHandler("get_msg_emoji_like_users", handleGetMsgEmojiLikeUsers)
+}
+
+// 按count分页获取表态的用户 传入上次返回的cookie可以继续获取
+func handleGetMsgEmojiLikeUsers(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.OpenAPI, message callapi.ActionMessage) {
+	var response EmojiLikeResponse
+	if config.GetUseRequestID() {
+		response.RequestID = callapi.GetActionEchoKey(message)
+	} else {
+		response.Echo = message.Echo
+	}
+
+	channelID, messageID, err := reactionTarget(message.Params)
+	var emoji dto.Emoji
+	if err == nil {
+		emoji, err = reactionEmoji(message.Params)
+	}
+	if err != nil {
+		mylog.Printf("get_msg_emoji_like_users: %v", err)
+		response.Message = err.Error()
+		response.RetCode = 100
+		response.Status = "failed"
+	} else if data, err := fetchEmojiLikeUsers(api, channelID, messageID, emoji, message.Params.Count, message.Params.Cookie); err != nil {
+		mylog.Printf("获取表态用户失败: %v", err)
+		response.Message = sanitizeErrorMessage(err)
+		response.RetCode = -1
+		response.Status = "failed"
+	} else {
+		response.Data = data
+		response.Status = "ok"
+	}
+
+	outputMap := structToMap(response)
+	if err := client.SendMessage(outputMap); err != nil {
+		mylog.Printf("Error sending message via client: %v", err)
+	} else {
+		mylog.Printf("响应get_msg_emoji_like_users: %+v", outputMap)
+	}
+}
+
+// 逐页获取直到数量足够或没有更多 用户id经过idmap转换
+func fetchEmojiLikeUsers(api openapi.OpenAPI, channelID, messageID string, emoji dto.Emoji, count int, cookie string) (*EmojiLikeUsersData, error) {
+	if count <= 0 {
+		count = defaultEmojiLikeCount
+	}
+	data := &EmojiLikeUsersData{Users: []EmojiLikeUser{}, Cookie: cookie}
+	for len(data.Users) < count {
+		limit := count - len(data.Users)
+		if limit > emojiLikePageSize {
+			limit = emojiLikePageSize
+		}
+		page, err := api.GetMessageReactionUsers(context.TODO(), channelID, messageID, emoji, &dto.MessageReactionPager{
+			Cookie: data.Cookie,
+			Limit:  strconv.Itoa(limit),
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range page.Users {
+			userID64, err := idmap.StoreIDv2(user.ID)
+			if err != nil {
+				mylog.Printf("Error storing ID: %v", err)
+				continue
+			}
+			data.Users = append(data.Users, EmojiLikeUser{
+				UserID:   userID64,
+				Nickname: user.Username,
+				Avatar:   user.Avatar,
+			})
+		}
+		data.Cookie = page.Cookie
+		data.IsEnd = page.IsEnd || page.Cookie == "" || len(page.Users) == 0
+		if data.IsEnd {
+			break
+		}
+	}
+	return data, nil
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/hoshinonyaruko/gensokyo/callapi"
 	"github.com/hoshinonyaruko/gensokyo/config"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 	"github.com/tencent-connect/botgo/openapi"
 )
 
@@ -17,24 +18,13 @@ type GetStatusResponse struct {
 }
 
 type StatusData struct {
-	AppInitialized bool       `json:"app_initialized"`
-	AppEnabled     bool       `json:"app_enabled"`
-	PluginsGood    bool       `json:"plugins_good"`
-	AppGood        bool       `json:"app_good"`
-	Online         bool       `json:"online"`
-	Good           bool       `json:"good"`
-	Stat           Statistics `json:"stat"`
-}
-
-type Statistics struct {
-	PacketReceived  uint64 `json:"packet_received"`
-	PacketSent      uint64 `json:"packet_sent"`
-	PacketLost      uint32 `json:"packet_lost"`
-	MessageReceived uint64 `json:"message_received"`
-	MessageSent     uint64 `json:"message_sent"`
-	DisconnectTimes uint32 `json:"disconnect_times"`
-	LostTimes       uint32 `json:"lost_times"`
-	LastMessageTime int64  `json:"last_message_time"`
+	AppInitialized bool             `json:"app_initialized"`
+	AppEnabled     bool             `json:"app_enabled"`
+	PluginsGood    bool             `json:"plugins_good"`
+	AppGood        bool             `json:"app_good"`
+	Online         bool             `json:"online"`
+	Good           bool             `json:"good"`
+	Stat           stats.Statistics `json:"stat"`
 }
 
 func init() {
@@ -45,23 +35,15 @@ func getStatus(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.OpenAPI
 
 	var response GetStatusResponse
 
+	online := stats.IsOnline()
 	response.Data = StatusData{
 		AppInitialized: true,
 		AppEnabled:     true,
 		PluginsGood:    true,
 		AppGood:        true,
-		Online:         true, //测试数据
-		Good:           true, //测试数据
-		Stat: Statistics{
-			PacketReceived:  1000,       //测试数据
-			PacketSent:      950,        //测试数据
-			PacketLost:      50,         //测试数据
-			MessageReceived: 500,        //测试数据
-			MessageSent:     490,        //测试数据
-			DisconnectTimes: 5,          //测试数据
-			LostTimes:       2,          //测试数据
-			LastMessageTime: 1677721600, //测试数据
-		},
+		Online:         online,
+		Good:           online,
+		Stat:           stats.Snapshot(),
 	}
 	response.Message = ""
 	response.RetCode = 0
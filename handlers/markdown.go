@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hoshinonyaruko/gensokyo/message"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/dto/keyboard"
+)
+
+// 解码data参数 支持base64://前缀或直接的json
+func decodeRichSegmentData(value string) ([]byte, error) {
+	if strings.HasPrefix(value, "base64://") {
+		return base64.StdEncoding.DecodeString(strings.TrimPrefix(value, "base64://"))
+	}
+	return []byte(value), nil
+}
+
+// 解析markdown消息段 支持[CQ:markdown,data=...]和template_id params content参数
+// 返回序列化后的dto.Markdown 放入foundItems
+func markdownFromSegment(seg message.Segment) (string, error) {
+	var markdown dto.Markdown
+	if data := seg.Get("data"); data != "" {
+		b, err := decodeRichSegmentData(data)
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(b, &markdown); err != nil {
+			return "", err
+		}
+	} else {
+		markdown.Content = seg.Get("content")
+		if templateID := seg.Get("template_id"); templateID != "" {
+			id, err := strconv.Atoi(templateID)
+			if err != nil {
+				return "", fmt.Errorf("invalid template_id: %s", templateID)
+			}
+			markdown.TemplateID = id
+		}
+		if params := seg.Get("params"); params != "" {
+			if err := json.Unmarshal([]byte(params), &markdown.Params); err != nil {
+				return "", err
+			}
+		}
+	}
+	if markdown.TemplateID == 0 && markdown.Content == "" {
+		return "", fmt.Errorf("markdown needs template_id or content")
+	}
+	b, err := json.Marshal(markdown)
+	return string(b), err
+}
+
+// 解析keyboard消息段 支持模板按钮id 以及data content rows形式的自定义按钮
+// 返回序列化后的keyboard.MessageKeyboard 放入foundItems
+func keyboardFromSegment(seg message.Segment) (string, error) {
+	var kb keyboard.MessageKeyboard
+	switch {
+	case seg.Get("id") != "":
+		kb.ID = seg.Get("id")
+	case seg.Get("data") != "":
+		b, err := decodeRichSegmentData(seg.Get("data"))
+		if err != nil {
+			return "", err
+		}
+		if err := json.Unmarshal(b, &kb); err != nil {
+			return "", err
+		}
+		// 兼容直接传rows的自定义按钮
+		if kb.ID == "" && kb.Content == nil {
+			var custom keyboard.CustomKeyboard
+			if err := json.Unmarshal(b, &custom); err != nil {
+				return "", err
+			}
+			kb.Content = &custom
+		}
+	case seg.Get("content") != "":
+		var custom keyboard.CustomKeyboard
+		if err := json.Unmarshal([]byte(seg.Get("content")), &custom); err != nil {
+			return "", err
+		}
+		kb.Content = &custom
+	case seg.Get("rows") != "":
+		var custom keyboard.CustomKeyboard
+		if err := json.Unmarshal([]byte(seg.Get("rows")), &custom.Rows); err != nil {
+			return "", err
+		}
+		kb.Content = &custom
+	}
+	if kb.ID == "" && (kb.Content == nil || len(kb.Content.Rows) == 0) {
+		return "", fmt.Errorf("keyboard needs id or rows")
+	}
+	b, err := json.Marshal(kb)
+	return string(b), err
+}
+
+// 从foundItems中取出markdown和keyboard 组合成msg_type=2的信息 没有时返回nil
+// 只有keyboard时 文本会作为原生markdown和按钮一起发送
+func generateMarkdownMessage(id string, foundItems map[string][]string, messageText *string) *dto.MessageToCreate {
+	markdownItems, hasMarkdown := foundItems["markdown"]
+	keyboardItems, hasKeyboard := foundItems["keyboard"]
+	if !hasMarkdown && !hasKeyboard {
+		return nil
+	}
+	delete(foundItems, "markdown")
+	delete(foundItems, "keyboard")
+
+	reply := &dto.MessageToCreate{
+		MsgID:   id,
+		MsgType: 2, // 2代表markdown
+	}
+	if hasMarkdown {
+		var markdown dto.Markdown
+		if err := json.Unmarshal([]byte(markdownItems[0]), &markdown); err != nil {
+			mylog.Printf("Error parsing markdown: %v", err)
+			return nil
+		}
+		reply.Markdown = &markdown
+	}
+	if hasKeyboard {
+		var kb keyboard.MessageKeyboard
+		if err := json.Unmarshal([]byte(keyboardItems[0]), &kb); err != nil {
+			mylog.Printf("Error parsing keyboard: %v", err)
+		} else {
+			reply.Keyboard = &kb
+		}
+	}
+	// 按钮必须跟随markdown发送
+	if reply.Markdown == nil {
+		if reply.Keyboard == nil || *messageText == "" {
+			mylog.Printf("keyboard需要和markdown或文本一起发送")
+			return nil
+		}
+		reply.Markdown = &dto.Markdown{Content: *messageText}
+		*messageText = ""
+	}
+	return reply
+}
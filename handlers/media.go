@@ -0,0 +1,240 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/images"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/sendqueue"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+// 富媒体类型对应的file_type
+var mediaFileTypes = map[string]uint64{
+	"image":  1,
+	"video":  2,
+	"record": 3,
+	"file":   4,
+}
+
+// 各类媒体文件的大小上限 与图床一致
+var mediaSizeLimits = map[string]int64{
+	"record": 20 * 1024 * 1024,
+	"video":  100 * 1024 * 1024,
+	"file":   100 * 1024 * 1024,
+}
+
+// 视频和文件的来源 按顺序查找
+var mediaKeys = []string{
+	"local_video", "url_video", "base64_video",
+	"local_file", "url_file", "base64_file",
+}
+
+// 取出第一个视频或文件 mediaType为video或file
+func findMedia(foundItems map[string][]string) (mediaType, key, value string, ok bool) {
+	for _, key := range mediaKeys {
+		if values := foundItems[key]; len(values) > 0 {
+			_, mediaType, _ := strings.Cut(key, "_")
+			return mediaType, key, values[0], true
+		}
+	}
+	return "", "", "", false
+}
+
+// 读取媒体数据 key的前缀决定来源 支持本地文件 网络链接和base64
+func loadMedia(key, value string, limit int64) ([]byte, error) {
+	source, _, _ := strings.Cut(key, "_")
+	var data []byte
+	var err error
+	switch source {
+	case "local":
+		var info os.FileInfo
+		if info, err = os.Stat(value); err != nil {
+			return nil, err
+		}
+		if info.Size() > limit {
+			return nil, errors.New("media file too large")
+		}
+		data, err = os.ReadFile(value)
+	case "url":
+		data, err = downloadMedia(value, limit)
+	case "base64":
+		data, err = base64.StdEncoding.DecodeString(value)
+	default:
+		return nil, fmt.Errorf("unknown media source: %s", key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errors.New("media file too large")
+	}
+	return data, nil
+}
+
+// 下载网络媒体文件 超过limit时返回错误
+func downloadMedia(url string, limit int64) ([]byte, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download media: %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errors.New("media file too large")
+	}
+	return data, nil
+}
+
+// 生成视频或文件的富媒体信息 网络链接直接交给qq下载
+// 本地文件和base64经内置图床转为url
+func generateMediaMessage(id, mediaType, key, value string) interface{} {
+	mediaURL := value
+	if !strings.HasPrefix(key, "url_") {
+		data, err := loadMedia(key, value, mediaSizeLimits[mediaType])
+		if err != nil {
+			mylog.Printf("Error loading %s: %v", mediaType, err)
+			return &dto.MessageToCreate{
+				Content: "错误: 媒体文件读取失败",
+				MsgID:   id,
+				MsgType: 0, // 默认文本类型
+			}
+		}
+		// 本地文件保留原文件名 base64没有文件名 由图床生成
+		var fileName string
+		if strings.HasPrefix(key, "local_") {
+			fileName = filepath.Base(value)
+		}
+		mediaURL, err = images.UploadBase64MediaToServer(mediaType, base64.StdEncoding.EncodeToString(data), fileName)
+		if err != nil {
+			mylog.Printf("Error uploading %s: %v", mediaType, err)
+			return &dto.MessageToCreate{
+				Content: "错误: 上传媒体文件失败",
+				MsgID:   id,
+				MsgType: 0, // 默认文本类型
+			}
+		}
+	}
+	return &dto.RichMediaMessage{
+		EventID:    id,
+		MsgID:      id,
+		FileType:   mediaFileTypes[mediaType],
+		URL:        mediaURL,
+		Content:    " ",  // 官方要求：msg_type=7 时需要填空格
+		SrvSendMsg: true, // 直接发送（被动回复模式）
+	}
+}
+
+// 富媒体的发送目标 scene为group或group_private id为真实的openid
+type mediaTarget struct {
+	scene string
+	id    string
+}
+
+// 上传富媒体的接口地址
+func (t mediaTarget) filesURL() string {
+	if t.scene == "group" {
+		return fmt.Sprintf("https://api.sgroup.qq.com/v2/groups/%s/files", t.id)
+	}
+	return fmt.Sprintf("https://api.sgroup.qq.com/v2/users/%s/files", t.id)
+}
+
+// 向群或单聊发送信息
+func (t mediaTarget) post(apiv2 openapi.OpenAPI, msg *dto.MessageToCreate) (*dto.Message, error) {
+	if t.scene == "group" {
+		return sendqueue.Send(t.queueTarget(), sendqueue.IsPassive(msg), func() (*dto.Message, error) {
+			return apiv2.PostGroupMessage(context.TODO(), t.id, msg)
+		})
+	}
+	return sendqueue.Send(t.queueTarget(), sendqueue.IsPassive(msg), func() (*dto.Message, error) {
+		return apiv2.PostC2CMessage(context.TODO(), t.id, msg)
+	})
+}
+
+// 发送调度中的目标 单聊按user计数
+func (t mediaTarget) queueTarget() sendqueue.Target {
+	if t.scene == "group" {
+		return sendqueue.Target{Scope: "group", ID: t.id}
+	}
+	return sendqueue.Target{Scope: "user", ID: t.id}
+}
+
+// generateGroupMessage没有生成富媒体信息 通常是读取或上传文件失败
+var errNotRichMedia = errors.New("not a rich media message")
+
+// 两步法的第一步 上传富媒体获取file_info 仅上传不发送 避免占用主动消息频次
+// 命中缓存时跳过上传
+func uploadRichMedia(apiv2 openapi.OpenAPI, target mediaTarget, msgID, key, value string) (string, error) {
+	cacheKey, cacheable := fileInfoCacheKey(target, key, value)
+	if cacheable {
+		if fileInfo, ok := getCachedFileInfo(cacheKey); ok {
+			mylog.Printf("命中file_info缓存: %s", key)
+			return fileInfo, nil
+		}
+	}
+
+	reply := generateGroupMessage(msgID, map[string][]string{key: {value}}, "")
+	richMediaMessage, ok := reply.(*dto.RichMediaMessage)
+	if !ok {
+		if textMessage, isText := reply.(*dto.MessageToCreate); isText {
+			return "", fmt.Errorf("%w: %s", errNotRichMedia, textMessage.Content)
+		}
+		return "", errNotRichMedia
+	}
+	richMediaMessage.SrvSendMsg = false // 关键：仅上传，不发送
+	mylog.DebugPrintf("上传富媒体文件 - URL: %s", richMediaMessage.URL)
+
+	// 上传同样会触发qq的频率限制 和发送一起排队和退避重试
+	// 上传不占用主动消息额度 按被动回复的每秒次数计数
+	var uploadData []byte
+	_, err := sendqueue.Send(target.queueTarget(), true, func() (*dto.Message, error) {
+		var err error
+		uploadData, err = apiv2.Transport(context.TODO(), "POST", target.filesURL(), richMediaMessage)
+		return nil, err
+	})
+	if err != nil {
+		return "", err
+	}
+	var uploadResp dto.RichMediaResponse
+	if err := json.Unmarshal(uploadData, &uploadResp); err != nil {
+		return "", err
+	}
+	mylog.Printf("获取到 file_info: %s, TTL: %d 秒", uploadResp.FileInfo, uploadResp.TTL)
+	if cacheable {
+		putCachedFileInfo(cacheKey, uploadResp.FileInfo, uploadResp.TTL)
+	}
+	return uploadResp.FileInfo, nil
+}
+
+// 两步法发送富媒体 先上传获取file_info 再生成msg_type=7的信息
+func richMediaMessage(apiv2 openapi.OpenAPI, target mediaTarget, msgID, key, value string) (*dto.MessageToCreate, error) {
+	fileInfo, err := uploadRichMedia(apiv2, target, msgID, key, value)
+	if err != nil {
+		return nil, err
+	}
+	return &dto.MessageToCreate{
+		Content: " ", // 官方要求：msg_type=7 时需要填空格
+		MsgType: 7,   // 7 = 富媒体消息
+		Media: &dto.Media{
+			FileInfo: fileInfo,
+		},
+	}, nil
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/stats"
+)
+
+// file_info到期前预留的时间 避免发送时刚好失效
+const fileInfoExpireMargin = time.Minute
+
+// 缓存的最大条数 超出时淘汰最早写入的file_info
+const maxFileInfoEntries = 10000
+
+type fileInfoEntry struct {
+	fileInfo string
+	expireAt time.Time // 零值代表长期有效
+	storedAt time.Time
+}
+
+// 富媒体上传得到的file_info 按目标和内容哈希缓存 同一文件不再重复上传
+// 避免反复发送同一张图片时触发上传频率限制
+var fileInfoCache = struct {
+	sync.Mutex
+	entries map[string]fileInfoEntry
+}{entries: make(map[string]fileInfoEntry)}
+
+// 生成缓存key base64取内容的哈希 相同内容共用缓存 网络链接取url的哈希
+// 本地文件不读取内容 取路径 大小和修改时间的哈希 文件变化后自然失效
+// file_info只能在上传的群或用户中使用 key包含发送目标
+func fileInfoCacheKey(target mediaTarget, key, value string) (string, bool) {
+	source, mediaType, _ := strings.Cut(key, "_")
+	kind, content := "content", []byte(nil)
+	switch source {
+	case "local":
+		info, err := os.Stat(value)
+		if err != nil || !info.Mode().IsRegular() {
+			return "", false
+		}
+		path, err := filepath.Abs(value)
+		if err != nil {
+			return "", false
+		}
+		kind, content = "file", []byte(fmt.Sprintf("%s\x00%d\x00%d", path, info.Size(), info.ModTime().UnixNano()))
+	case "base64":
+		data, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return "", false
+		}
+		content = data
+	default:
+		kind, content = "url", []byte(value)
+	}
+	sum := sha256.Sum256(content)
+	return strings.Join([]string{target.scene, target.id, mediaType, kind, hex.EncodeToString(sum[:])}, ":"), true
+}
+
+// 取出未过期的file_info
+func getCachedFileInfo(cacheKey string) (string, bool) {
+	fileInfoCache.Lock()
+	defer fileInfoCache.Unlock()
+	entry, ok := fileInfoCache.entries[cacheKey]
+	if ok && !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		delete(fileInfoCache.entries, cacheKey)
+		ok = false
+	}
+	if !ok {
+		stats.AddMediaCacheMiss()
+		return "", false
+	}
+	stats.AddMediaCacheHit()
+	return entry.fileInfo, true
+}
+
+// 缓存file_info ttl为上传接口返回的剩余秒数 0代表长期有效
+func putCachedFileInfo(cacheKey, fileInfo string, ttl int) {
+	if fileInfo == "" {
+		return
+	}
+	entry := fileInfoEntry{fileInfo: fileInfo, storedAt: time.Now()}
+	if ttl > 0 {
+		lifetime := time.Duration(ttl)*time.Second - fileInfoExpireMargin
+		if lifetime <= 0 {
+			return
+		}
+		entry.expireAt = time.Now().Add(lifetime)
+	}
+
+	fileInfoCache.Lock()
+	defer fileInfoCache.Unlock()
+	// 顺带清理过期的缓存
+	now := time.Now()
+	for k, v := range fileInfoCache.entries {
+		if !v.expireAt.IsZero() && now.After(v.expireAt) {
+			delete(fileInfoCache.entries, k)
+		}
+	}
+	// 仍然已满时淘汰最早写入的一条
+	if _, exists := fileInfoCache.entries[cacheKey]; !exists && len(fileInfoCache.entries) >= maxFileInfoEntries {
+		var oldestKey string
+		var oldest time.Time
+		for k, v := range fileInfoCache.entries {
+			if oldestKey == "" || v.storedAt.Before(oldest) {
+				oldestKey, oldest = k, v.storedAt
+			}
+		}
+		delete(fileInfoCache.entries, oldestKey)
+	}
+	fileInfoCache.entries[cacheKey] = entry
+}
@@ -2,17 +2,23 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hoshinonyaruko/gensokyo/callapi"
 	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/echo"
 	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/message"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/sendqueue"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 	"github.com/hoshinonyaruko/gensokyo/url"
 	"github.com/tencent-connect/botgo/dto"
 	"github.com/tencent-connect/botgo/errs"
@@ -33,7 +39,8 @@ type ErrorResponse struct {
 // 定义响应结构体
 type ServerResponse struct {
 	Data struct {
-		MessageID int `json:"message_id"`
+		MessageID int64        `json:"message_id"`
+		Parts     []PartResult `json:"parts,omitempty"`
 	} `json:"data"`
 	Message   string      `json:"message"`
 	RetCode   int         `json:"retcode"`
@@ -42,17 +49,103 @@ type ServerResponse struct {
 	RequestID interface{} `json:"request_id,omitempty"`
 }
 
-// 发送成功回执 todo 返回可互转的messageid
+// PartResult 一条onebot消息拆分为多次发送时 每一部分的结果
+type PartResult struct {
+	Type      string `json:"type"`
+	MessageID int64  `json:"message_id"`
+	RetCode   int    `json:"retcode"`
+	Message   string `json:"message,omitempty"`
+	AuditID   string `json:"audit_id,omitempty"` // 频道主动消息进入审核时 与message_audit通知中的audit_id对应
+}
+
+// 发送回执 没有对应的消息时message_id为0
 func SendResponse(client callapi.Client, err error, message *callapi.ActionMessage) error {
+	return SendResponseWithMessageID(client, err, message, 0)
+}
+
+// 发送回执 并返回经过idmap转换的message_id
+func SendResponseWithMessageID(client callapi.Client, err error, message *callapi.ActionMessage, messageID int64) error {
 	// 设置响应值
 	response := ServerResponse{}
-	response.Data.MessageID = 0 // todo 实现messageid转换
+	response.Data.MessageID = messageID
 	// 根据配置决定返回字段名
 	if config.GetUseRequestID() {
 		response.RequestID = callapi.GetActionEchoKey(*message)
 	} else {
 		response.Echo = message.Echo
 	}
+	setResponseStatus(&response, err)
+	return deliverResponse(client, response)
+}
+
+// SendPartsResponse 汇总分段发送的结果 message_id为最后一条发出的信息
+// 部分失败时status仍为ok 由message给出最后的错误 全部失败时返回failed
+// note为被动回复回退等说明 放在message的开头
+func SendPartsResponse(client callapi.Client, message *callapi.ActionMessage, results []partResult, note string) error {
+	response := ServerResponse{}
+	if config.GetUseRequestID() {
+		response.RequestID = callapi.GetActionEchoKey(*message)
+	} else {
+		response.Echo = message.Echo
+	}
+	if len(results) == 0 {
+		response.Message = "message is empty"
+		response.RetCode = 100
+		response.Status = "failed"
+		return deliverResponse(client, response)
+	}
+
+	var lastErr error
+	succeeded := 0
+	// 配置了audit_wait时 等待审核结果后再回执
+	auditDeadline := time.Now().Add(config.GetAuditWait())
+	for _, result := range results {
+		part := PartResult{Type: result.kind, MessageID: result.messageID}
+		if result.err == nil {
+			// 每条实际发出的qq消息计数一次 审核中的在审核通过时计数
+			stats.AddMessageSent()
+		}
+		if result.audit != nil {
+			result.audit.attach(message)
+			part.AuditID = result.audit.auditID
+			part.Message = "消息审核中"
+			if auditResult, ok := result.audit.wait(auditDeadline); ok {
+				part.MessageID = auditResult.MessageID
+				part.Message = ""
+				if !auditResult.Passed {
+					result.err = errAuditRejected
+					part.Message = errAuditRejected.Error()
+				}
+			}
+		}
+		if isRealFailure(result.err) {
+			part.RetCode = -1
+			part.Message = sanitizeErrorMessage(result.err)
+			lastErr = result.err
+		} else {
+			succeeded++
+			if part.MessageID != 0 {
+				response.Data.MessageID = part.MessageID
+			}
+		}
+		response.Data.Parts = append(response.Data.Parts, part)
+	}
+	if succeeded == 0 {
+		setResponseStatus(&response, lastErr)
+	} else {
+		setResponseStatus(&response, nil)
+		response.Message = sanitizeErrorMessage(lastErr)
+	}
+	if note != "" && response.Message != "" {
+		response.Message = note + "; " + response.Message
+	} else if note != "" {
+		response.Message = note
+	}
+	return deliverResponse(client, response)
+}
+
+// 根据错误设置回执的状态
+func setResponseStatus(response *ServerResponse, err error) {
 	if err != nil {
 		// 过滤敏感信息后再返回错误消息
 		response.Message = sanitizeErrorMessage(err)
@@ -73,7 +166,10 @@ func SendResponse(client callapi.Client, err error, message *callapi.ActionMessa
 		response.RetCode = 0
 		response.Status = "ok"
 	}
+}
 
+// 发送回执
+func deliverResponse(client callapi.Client, response ServerResponse) error {
 	// 转化为map并发送
 	outputMap := structToMap(response)
 
@@ -94,6 +190,11 @@ func sanitizeErrorMessage(err error) string {
 		return ""
 	}
 
+	// 被动回复次数用完 已过期 排队超时或媒体无法发送 不包含敏感信息 直接返回
+	if errors.Is(err, echo.ErrReplyLimit) || errors.Is(err, errPassiveReplyExpired) || errors.Is(err, sendqueue.ErrQueueTimeout) || errors.Is(err, errNotRichMedia) {
+		return err.Error()
+	}
+
 	// 尝试将错误转换为 SDK 的 Err 类型
 	sdkErr := errs.Error(err)
 	errText := sdkErr.Text()
@@ -117,6 +218,11 @@ func isRealFailure(err error) bool {
 		return false
 	}
 
+	// 重试后仍然触发频率限制 信息没有发出
+	if sendqueue.IsRateLimited(err) {
+		return true
+	}
+
 	// 将错误转换为 SDK 的 Err 类型（errs.Error 永远不会返回 nil）
 	sdkErr := errs.Error(err)
 
@@ -136,13 +242,13 @@ func isRealFailure(err error) bool {
 	// 检查特定的错误码
 	// 850026 - 富媒体文件下载失败
 	// 40034001 - 相关的错误码
-	// 304023 - 消息审核不通过（这个应该视为审核失败，保持原有行为返回ok）
+	// 304023 - 消息审核中（审核结果通过message_audit通知上报，保持原有行为返回ok）
 	switch errResp.Code {
 	case 850026: // 富媒体文件下载失败
 		return true
 	case 40034001: // 相关错误
 		return true
-	case 304023: // 消息审核不通过 - 保持原有行为（返回ok）
+	case auditPendingCode: // 消息审核中 - 保持原有行为（返回ok）
 		return false
 	default:
 		// 对于未知的错误码，保守处理：
@@ -155,130 +261,93 @@ func isRealFailure(err error) bool {
 	}
 }
 
-// 信息处理函数
-func parseMessageContent(paramsMessage callapi.ParamsContent) (string, map[string][]string) {
-	messageText := ""
-
-	switch message := paramsMessage.Message.(type) {
-	case string:
-		mylog.Printf("params.message is a string\n")
-		messageText = message
-	case []interface{}:
-		//多个映射组成的切片
-		mylog.Printf("params.message is a slice (segment_type_koishi)\n")
-		for _, segment := range message {
-			segmentMap, ok := segment.(map[string]interface{})
-			if !ok {
-				continue
-			}
-
-			segmentType, ok := segmentMap["type"].(string)
-			if !ok {
-				continue
-			}
-
-			segmentContent := ""
-			switch segmentType {
-			case "text":
-				segmentContent, _ = segmentMap["data"].(map[string]interface{})["text"].(string)
-			case "image":
-				fileContent, _ := segmentMap["data"].(map[string]interface{})["file"].(string)
-				segmentContent = "[CQ:image,file=" + fileContent + "]"
-			case "voice":
-				fileContent, _ := segmentMap["data"].(map[string]interface{})["file"].(string)
-				segmentContent = "[CQ:record,file=" + fileContent + "]"
-			case "at":
-				qqNumber, _ := segmentMap["data"].(map[string]interface{})["qq"].(string)
-				segmentContent = "[CQ:at,qq=" + qqNumber + "]"
-			}
+// 本地文件的前缀 windows下为file:///C:/...
+func localFilePrefix() string {
+	if runtime.GOOS == "windows" {
+		return "file:///"
+	}
+	return "file://"
+}
 
-			messageText += segmentContent
+// 解析单个消息段 文本和at返回转换后的text
+// 其他消息段返回foundItems中的类型和值 无法处理时全部为空
+func parseSegment(seg message.Segment, localPrefix string) (text, key, value string) {
+	switch seg.Type {
+	case "text":
+		return transformText(seg.Get("text")), "", ""
+	case "at":
+		return transformAt(seg.Get("qq")), "", ""
+	case "image":
+		file := seg.Get("file")
+		if strings.HasPrefix(file, "http://") || strings.HasPrefix(file, "https://") {
+			// 发送时会补全http://
+			_, address, _ := strings.Cut(file, "://")
+			return "", "url_image", address
 		}
-	case map[string]interface{}:
-		//单个映射
-		mylog.Printf("params.message is a map (segment_type_trss)\n")
-		messageType, _ := message["type"].(string)
-		switch messageType {
-		case "text":
-			messageText, _ = message["data"].(map[string]interface{})["text"].(string)
-		case "image":
-			fileContent, _ := message["data"].(map[string]interface{})["file"].(string)
-			messageText = "[CQ:image,file=" + fileContent + "]"
-		case "voice":
-			fileContent, _ := message["data"].(map[string]interface{})["file"].(string)
-			messageText = "[CQ:record,file=" + fileContent + "]"
-		case "at":
-			qqNumber, _ := message["data"].(map[string]interface{})["qq"].(string)
-			messageText = "[CQ:at,qq=" + qqNumber + "]"
+		return mediaSource("image", file, localPrefix)
+	case "record", "voice":
+		return mediaSource("record", seg.Get("file"), localPrefix)
+	case "video":
+		return mediaSource("video", seg.Get("file"), localPrefix)
+	case "file":
+		return mediaSource("file", seg.Get("file"), localPrefix)
+	case "reply":
+		return "", "reply", seg.Get("id")
+	case "markdown":
+		markdown, err := markdownFromSegment(seg)
+		if err != nil {
+			mylog.Printf("Error parsing markdown: %v", err)
+			return "", "", ""
 		}
+		return "", "markdown", markdown
+	case "keyboard":
+		kb, err := keyboardFromSegment(seg)
+		if err != nil {
+			mylog.Printf("Error parsing keyboard: %v", err)
+			return "", "", ""
+		}
+		return "", "keyboard", kb
 	default:
-		mylog.Println("Unsupported message format: params.message field is not a string, map or slice")
+		mylog.Printf("Unsupported segment type: %s", seg.Type)
+		return "", "", ""
 	}
+}
 
-	// 正则表达式部分
-	var localImagePattern *regexp.Regexp
-
-	if runtime.GOOS == "windows" {
-		localImagePattern = regexp.MustCompile(`\[CQ:image,file=file:///([^\]]+?)\]`)
-	} else {
-		localImagePattern = regexp.MustCompile(`\[CQ:image,file=file://([^\]]+?)\]`)
+// 按来源区分媒体文件 网络链接保留完整的url
+func mediaSource(mediaType, file, localPrefix string) (text, key, value string) {
+	switch {
+	case strings.HasPrefix(file, localPrefix):
+		return "", "local_" + mediaType, strings.TrimPrefix(file, localPrefix)
+	case strings.HasPrefix(file, "http://"), strings.HasPrefix(file, "https://"):
+		return "", "url_" + mediaType, file
+	case strings.HasPrefix(file, "base64://"):
+		return "", "base64_" + mediaType, strings.TrimPrefix(file, "base64://")
 	}
+	return "", "", ""
+}
 
-	urlImagePattern := regexp.MustCompile(`\[CQ:image,file=https?://(.+)\]`)
-	base64ImagePattern := regexp.MustCompile(`\[CQ:image,file=base64://(.+)\]`)
-	base64RecordPattern := regexp.MustCompile(`\[CQ:record,file=base64://(.+)\]`)
-
-	patterns := []struct {
-		key     string
-		pattern *regexp.Regexp
-	}{
-		{"local_image", localImagePattern},
-		{"url_image", urlImagePattern},
-		{"base64_image", base64ImagePattern},
-		{"base64_record", base64RecordPattern},
-	}
+// 将params.message转换为cq码字符串 支持字符串 消息段数组和单个消息段
+func messageToCQ(paramsMessage callapi.ParamsContent) string {
+	return message.Parse(paramsMessage.Message).String()
+}
 
-	foundItems := make(map[string][]string)
-	for _, pattern := range patterns {
-		matches := pattern.pattern.FindAllStringSubmatch(messageText, -1)
-		for _, match := range matches {
-			if len(match) > 1 {
-				foundItems[pattern.key] = append(foundItems[pattern.key], match[1])
-				messageText = pattern.pattern.ReplaceAllString(messageText, "")
-			}
-		}
+// at转换为开放平台的<@!id>格式
+func transformAt(qq string) string {
+	// at机器人自身时使用BotID
+	if qq == AppID {
+		return "<@!" + BotID + ">"
 	}
-	//处理at
-	messageText = transformMessageText(messageText)
-
-	return messageText, foundItems
+	realUserID, err := idmap.RetrieveRowByIDv2(qq)
+	if err != nil {
+		// 如果出错，也替换成相应的格式，但使用原始QQ号
+		mylog.Printf("Error retrieving user ID: %v", err)
+		return "<@!" + qq + ">"
+	}
+	return "<@!" + realUserID + ">"
 }
 
-// at处理和链接处理
-func transformMessageText(messageText string) string {
-	// 首先，将AppID替换为BotID
-	messageText = strings.ReplaceAll(messageText, AppID, BotID)
-
-	// 去除所有[CQ:reply,id=数字] todo 更好的处理办法
-	replyRE := regexp.MustCompile(`\[CQ:reply,id=\d+\]`)
-	messageText = replyRE.ReplaceAllString(messageText, "")
-
-	// 使用正则表达式来查找所有[CQ:at,qq=数字]的模式
-	re := regexp.MustCompile(`\[CQ:at,qq=(\d+)\]`)
-	messageText = re.ReplaceAllStringFunc(messageText, func(m string) string {
-		submatches := re.FindStringSubmatch(m)
-		if len(submatches) > 1 {
-			realUserID, err := idmap.RetrieveRowByIDv2(submatches[1])
-			if err != nil {
-				// 如果出错，也替换成相应的格式，但使用原始QQ号
-				mylog.Printf("Error retrieving user ID: %v", err)
-				return "<@!" + submatches[1] + ">"
-			}
-			return "<@!" + realUserID + ">"
-		}
-		return m
-	})
-
+// 链接处理
+func transformText(messageText string) string {
 	// 使用xurls来查找和替换所有的URL
 	if config.GetLotusValue() {
 		// 连接到另一个gensokyo
@@ -297,8 +366,11 @@ func transformMessageText(messageText string) string {
 	return messageText
 }
 
-// 处理at和其他定形文到onebotv11格式(cq码)
-func RevertTransformedText(data interface{}) string {
+// 开放平台消息中的at格式
+var mentionRE = regexp.MustCompile(`<@!(\d+)>`)
+
+// 将收到的消息转换为onebot消息 at和文本保持原有顺序 图片附件排在最后
+func ConvertToMessage(data interface{}) message.Message {
 	var msg *dto.Message
 	switch v := data.(type) {
 	case *dto.WSGroupATMessageData:
@@ -312,143 +384,74 @@ func RevertTransformedText(data interface{}) string {
 	case *dto.WSC2CMessageData:
 		msg = (*dto.Message)(v)
 	default:
-		return ""
+		return nil
 	}
 	//处理前 先去前后空
-	messageText := strings.TrimSpace(msg.Content)
-
-	// 将messageText里的BotID替换成AppID
-	messageText = strings.ReplaceAll(messageText, BotID, AppID)
-
-	// 使用正则表达式来查找所有<@!数字>的模式
-	re := regexp.MustCompile(`<@!(\d+)>`)
-	// 使用正则表达式来替换找到的模式为[CQ:at,qq=用户ID]
-	messageText = re.ReplaceAllStringFunc(messageText, func(m string) string {
-		submatches := re.FindStringSubmatch(m)
-		if len(submatches) > 1 {
-			userID := submatches[1]
-			// 检查是否是 BotID，如果是则直接返回，不进行映射,或根据用户需求移除
-			if userID == AppID {
-				if config.GetRemoveAt() {
-					return ""
-				} else {
-					return "[CQ:at,qq=" + AppID + "]"
-				}
-			}
-
-			// 不是 BotID，进行正常映射
-			userID64, err := idmap.StoreIDv2(userID)
-			if err != nil {
-				//如果储存失败(数据库损坏)返回原始值
-				mylog.Printf("Error storing ID: %v", err)
-				return "[CQ:at,qq=" + userID + "]"
-			}
-			// 类型转换
-			userIDStr := strconv.FormatInt(userID64, 10)
-			// 经过转换的cq码
-			return "[CQ:at,qq=" + userIDStr + "]"
-		}
-		return m
-	})
+	content := strings.TrimSpace(msg.Content)
 
 	// 检查是否需要移除前缀
 	if config.GetRemovePrefixValue() {
 		// 移除消息内容中第一次出现的 "/"
-		if idx := strings.Index(messageText, "/"); idx != -1 {
-			messageText = messageText[:idx] + messageText[idx+1:]
+		if idx := strings.Index(content, "/"); idx != -1 {
+			content = content[:idx] + content[idx+1:]
 		}
 	}
 
-	// 处理图片附件
-	for _, attachment := range msg.Attachments {
-		if strings.HasPrefix(attachment.ContentType, "image/") {
-			// 获取文件的后缀名
-			ext := filepath.Ext(attachment.FileName)
-			md5name := strings.TrimSuffix(attachment.FileName, ext)
-			imageCQ := "[CQ:image,file=" + md5name + ".image,subType=0,url=" + "http://" + attachment.URL + "]"
-			messageText += imageCQ
+	var result message.Message
+	appendText := func(text string) {
+		if text != "" {
+			result = append(result, message.Text(text))
 		}
 	}
-
-	//如果移除了前部at,信息就会以空格开头,因为只移去了最前面的at,但at后紧跟随一个空格
-	if config.GetRemoveAt() {
-		//再次去前后空
-		messageText = strings.TrimSpace(messageText)
-	}
-
-	return messageText
-}
-
-// 将收到的data.content转换为message segment todo,群场景不支持受图片,频道场景的图片可以拼一下
-func ConvertToSegmentedMessage(data interface{}) []map[string]interface{} {
-	// 强制类型转换，获取Message结构
-	var msg *dto.Message
-	switch v := data.(type) {
-	case *dto.WSGroupATMessageData:
-		msg = (*dto.Message)(v)
-	case *dto.WSATMessageData:
-		msg = (*dto.Message)(v)
-	case *dto.WSMessageData:
-		msg = (*dto.Message)(v)
-	case *dto.WSDirectMessageData:
-		msg = (*dto.Message)(v)
-	case *dto.WSC2CMessageData:
-		msg = (*dto.Message)(v)
-	default:
-		return nil
-	}
-
-	var messageSegments []map[string]interface{}
-
-	// 处理Attachments字段来构建图片消息
-	for _, attachment := range msg.Attachments {
-		imageFileMD5 := attachment.FileName
-		for _, ext := range []string{"{", "}", ".png", ".jpg", ".gif", "-"} {
-			imageFileMD5 = strings.ReplaceAll(imageFileMD5, ext, "")
+	last := 0
+	for _, loc := range mentionRE.FindAllStringSubmatchIndex(content, -1) {
+		appendText(content[last:loc[0]])
+		last = loc[1]
+		userID := content[loc[2]:loc[3]]
+		// 检查是否是 BotID，如果是则直接返回，不进行映射,或根据用户需求移除
+		if userID == BotID || userID == AppID {
+			if !config.GetRemoveAt() {
+				result = append(result, message.At(AppID))
+			}
+			continue
 		}
-		imageSegment := map[string]interface{}{
-			"type": "image",
-			"data": map[string]interface{}{
-				"file":    imageFileMD5 + ".image",
-				"subType": "0",
-				"url":     attachment.URL,
-			},
+		// 不是 BotID，进行正常映射
+		userID64, err := idmap.StoreIDv2(userID)
+		if err != nil {
+			//如果储存失败(数据库损坏)返回原始值
+			mylog.Printf("Error storing ID: %v", err)
+			result = append(result, message.At(userID))
+			continue
 		}
-		messageSegments = append(messageSegments, imageSegment)
-
-		// 在msg.Content中替换旧的图片链接
-		newImagePattern := "[CQ:image,file=" + attachment.URL + "]"
-		msg.Content = msg.Content + newImagePattern
+		result = append(result, message.At(strconv.FormatInt(userID64, 10)))
 	}
+	appendText(content[last:])
 
-	// 使用正则表达式查找所有的[@数字]格式
-	r := regexp.MustCompile(`<@!(\d+)>`)
-	atMatches := r.FindAllStringSubmatch(msg.Content, -1)
-
-	for _, match := range atMatches {
-		// 构建at部分的映射并加入到messageSegments
-		atSegment := map[string]interface{}{
-			"type": "at",
-			"data": map[string]interface{}{
-				"qq": match[1],
-			},
+	//如果移除了前部at,信息就会以空格开头,因为只移去了最前面的at,但at后紧跟随一个空格
+	if config.GetRemoveAt() && len(result) > 0 && result[0].Type == "text" {
+		result[0] = message.Text(strings.TrimLeft(result[0].Get("text"), " "))
+		if result[0].Get("text") == "" {
+			result = result[1:]
 		}
-		messageSegments = append(messageSegments, atSegment)
-
-		// 从原始内容中移除at部分
-		msg.Content = strings.Replace(msg.Content, match[0], "", 1)
 	}
 
-	// 如果还有其他内容，那么这些内容被视为文本部分
-	if msg.Content != "" {
-		textSegment := map[string]interface{}{
-			"type": "text",
-			"data": map[string]interface{}{
-				"text": msg.Content,
-			},
+	// 处理图片附件
+	for _, attachment := range msg.Attachments {
+		if strings.HasPrefix(attachment.ContentType, "image/") {
+			// 获取文件的后缀名
+			ext := filepath.Ext(attachment.FileName)
+			md5name := strings.TrimSuffix(attachment.FileName, ext)
+			image := message.Image(md5name + ".image")
+			image.Set("subType", "0")
+			image.Set("url", "http://"+attachment.URL)
+			result = append(result, image)
 		}
-		messageSegments = append(messageSegments, textSegment)
 	}
 
-	return messageSegments
+	// 引用了其他消息时 在开头加上回复
+	if quotedID, ok := quotedMessageID(msg); ok {
+		result = append(message.Message{message.Reply(strconv.FormatInt(quotedID, 10))}, result...)
+	}
+
+	return result
 }
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"regexp"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+)
+
+// 记录中不保存base64内容 避免消息记录过大
+var base64ContentRE = regexp.MustCompile(`base64://[^,\]]+`)
+
+// 生成发出消息的记录内容 cq码格式
+func recordContent(params callapi.ParamsContent) string {
+	return base64ContentRE.ReplaceAllString(messageToCQ(params), "base64://")
+}
+
+// 记录bot发出的消息 返回经过idmap转换的message_id 发送失败或没有返回消息时返回0
+func recordSentMessage(resp *dto.Message, record idmap.MessageRecord) int64 {
+	if resp == nil || resp.ID == "" {
+		return 0
+	}
+	messageID64, err := idmap.StoreIDv2(resp.ID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return 0
+	}
+	record.MessageID = messageID64
+	record.RealID = resp.ID
+	record.UserID = int64(config.GetAppID())
+	record.Time = time.Now().Unix()
+	record.Self = true
+	if err := idmap.StoreMessage(record); err != nil {
+		mylog.Printf("保存消息记录失败: %v", err)
+	}
+	return messageID64
+}
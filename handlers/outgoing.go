@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/echo"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/message"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/sendqueue"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+// outgoingPart 规划好的一次发送 对应一条qq消息
+type outgoingPart struct {
+	text  string // 文本 频道中可以和图片一起发送
+	key   string // 媒体在foundItems中的类型 如url_image 为markdown时代表markdown和按钮
+	value string
+}
+
+// 这一部分的类型 用于回执
+func (p outgoingPart) kind() string {
+	if p.key == "" {
+		return "text"
+	}
+	if _, mediaType, ok := strings.Cut(p.key, "_"); ok {
+		return mediaType
+	}
+	return p.key
+}
+
+// 图片 语音 视频 文件 每条消息最多一个
+func isMediaKey(key string) bool {
+	return strings.HasPrefix(key, "local_") || strings.HasPrefix(key, "url_") || strings.HasPrefix(key, "base64_")
+}
+
+// planOutgoing 按消息段的顺序规划发送 相邻的文本合并为一条 每条消息最多一个媒体
+// combineImage为true时(频道和频道私信) 图片和它前面的文本合并为一条消息
+// reply markdown keyboard放入返回的foundItems markdown在其第一次出现的位置发送
+func planOutgoing(paramsMessage callapi.ParamsContent, combineImage bool) ([]outgoingPart, map[string][]string) {
+	localPrefix := localFilePrefix()
+	foundItems := make(map[string][]string)
+	var parts []outgoingPart
+	markdownIndex := -1
+	text := ""
+	flush := func() {
+		if strings.TrimSpace(text) != "" {
+			parts = append(parts, outgoingPart{text: text})
+		}
+		text = ""
+	}
+
+	for _, seg := range message.Parse(paramsMessage.Message) {
+		segText, key, value := parseSegment(seg, localPrefix)
+		switch {
+		case key == "":
+			text += segText
+		case isMediaKey(key):
+			if combineImage && strings.HasSuffix(key, "_image") {
+				parts = append(parts, outgoingPart{text: text, key: key, value: value})
+				text = ""
+				continue
+			}
+			flush()
+			parts = append(parts, outgoingPart{key: key, value: value})
+		case key == "markdown" || key == "keyboard":
+			if markdownIndex < 0 {
+				flush()
+				markdownIndex = len(parts)
+			}
+			foundItems[key] = append(foundItems[key], value)
+		default:
+			foundItems[key] = append(foundItems[key], value)
+		}
+	}
+	flush()
+
+	if markdownIndex >= 0 {
+		parts = insertMarkdownPart(parts, markdownIndex, foundItems)
+	}
+	return parts, foundItems
+}
+
+// 在index处插入markdown 只有按钮时 纯文本会作为markdown的内容和按钮一起发送
+func insertMarkdownPart(parts []outgoingPart, index int, foundItems map[string][]string) []outgoingPart {
+	markdownPart := outgoingPart{key: "markdown"}
+	if _, hasMarkdown := foundItems["markdown"]; !hasMarkdown {
+		kept := make([]outgoingPart, 0, len(parts))
+		removed := 0
+		for i, part := range parts {
+			if part.key != "" {
+				kept = append(kept, part)
+				continue
+			}
+			markdownPart.text += part.text
+			if i < index {
+				removed++
+			}
+		}
+		parts = kept
+		index -= removed
+	}
+	parts = append(parts, outgoingPart{})
+	copy(parts[index+1:], parts[index:])
+	parts[index] = markdownPart
+	return parts
+}
+
+// 生成markdown部分的信息 markdown无效时退回为文本
+func markdownPartMessage(msgID string, part outgoingPart, foundItems map[string][]string) (*dto.MessageToCreate, error) {
+	text := part.text
+	if markdownMessage := generateMarkdownMessage(msgID, foundItems, &text); markdownMessage != nil {
+		return markdownMessage, nil
+	}
+	if text != "" {
+		return &dto.MessageToCreate{
+			Content: text,
+			MsgID:   msgID,
+			MsgType: 0, // 默认文本类型
+		}, nil
+	}
+	return nil, errors.New("invalid markdown or keyboard")
+}
+
+// partResult 一次发送的结果
+type partResult struct {
+	kind      string
+	messageID int64
+	err       error
+	audit     *pendingAudit // 频道主动消息进入审核时不为nil
+}
+
+// sendGroupParts 按规划依次发送群或单聊信息 同一msg_id或event_id下msg_seq依次递增 跨调用累计
+// 每条信息最多一个媒体 媒体以两步法发送
+func sendGroupParts(apiv2 openapi.OpenAPI, target mediaTarget, reply passiveReply, parts []outgoingPart, foundItems map[string][]string, record idmap.MessageRecord) []partResult {
+	results := make([]partResult, 0, len(parts))
+	for _, part := range parts {
+		var resp *dto.Message
+		msg, err := groupPartMessage(apiv2, target, reply.msgID, part, foundItems)
+		if err == nil {
+			// 同一msg_id的每次回复使用递增的msg_seq 超过回复次数时不再发送
+			// 在发送前才取msg_seq 生成失败的部分不占用被动回复次数
+			var seq int
+			if seq, err = echo.NextMsgSeq(reply.seqKey()); err == nil {
+				reply.apply(msg, seq)
+				resp, err = target.post(apiv2, msg)
+			}
+		}
+
+		// 失败原因只在回执中返回 不向群或用户发送错误提示
+		if err != nil {
+			mylog.Printf("发送 %s 信息失败: %v", part.kind(), err)
+		}
+		results = append(results, partResult{kind: part.kind(), messageID: recordSentMessage(resp, record), err: err})
+	}
+	return results
+}
+
+// 生成群或单聊的一部分信息 媒体在这里上传
+func groupPartMessage(apiv2 openapi.OpenAPI, target mediaTarget, msgID string, part outgoingPart, foundItems map[string][]string) (*dto.MessageToCreate, error) {
+	switch part.key {
+	case "":
+		return &dto.MessageToCreate{
+			Content:   part.text,
+			MsgType:   0, // 默认文本类型
+			Timestamp: time.Now().Unix(),
+		}, nil
+	case "markdown":
+		return markdownPartMessage(msgID, part, foundItems)
+	default:
+		return richMediaMessage(apiv2, target, msgID, part.key, part.value)
+	}
+}
+
+// 拒绝发送时 每一部分都以err失败
+func refuseParts(parts []outgoingPart, err error) []partResult {
+	results := make([]partResult, 0, len(parts))
+	for _, part := range parts {
+		results = append(results, partResult{kind: part.kind(), err: err})
+	}
+	return results
+}
+
+// guildPoster 频道或频道私信的发送方式 target用于发送调度的计数
+type guildPoster struct {
+	target    sendqueue.Target
+	post      func(msg *dto.MessageToCreate) (*dto.Message, error)
+	multipart func(msg *dto.MessageToCreate, image []byte) (*dto.Message, error)
+}
+
+// sendGuildParts 按规划依次发送频道信息 图片和前面的文本一起发送 第一条信息引用reference
+func sendGuildParts(poster guildPoster, msgID string, parts []outgoingPart, foundItems map[string][]string, reference *dto.MessageReference, record idmap.MessageRecord) []partResult {
+	results := make([]partResult, 0, len(parts))
+	for i, part := range parts {
+		var msg *dto.MessageToCreate
+		var imageData []byte
+		var err error
+		switch {
+		case part.key == "":
+			msg, _ = generateReplyMessage(msgID, nil, part.text)
+		case part.key == "markdown":
+			msg, err = markdownPartMessage(msgID, part, foundItems)
+		case strings.HasSuffix(part.key, "_image"):
+			var isBase64Image bool
+			msg, isBase64Image = generateReplyMessage(msgID, map[string][]string{part.key: {part.value}}, "")
+			if isBase64Image {
+				// 将base64内容从reply的Content转换回字节 使用multipart发送
+				imageData, err = base64.StdEncoding.DecodeString(msg.Content)
+				msg.Content = part.text
+			} else if msg.Image != "" {
+				msg.Content = part.text
+			}
+		default:
+			err = fmt.Errorf("频道不支持发送%s", part.kind())
+		}
+
+		var resp *dto.Message
+		if err == nil {
+			msg.MsgSeq, err = echo.NextMsgSeq(msgID)
+		}
+		if err == nil {
+			if i == 0 {
+				msg.MessageReference = reference
+			}
+			resp, err = sendqueue.Send(poster.target, sendqueue.IsPassive(msg), func() (*dto.Message, error) {
+				if imageData != nil {
+					return poster.multipart(msg, imageData)
+				}
+				return poster.post(msg)
+			})
+		}
+		var audit *pendingAudit
+		if auditID := auditIDFromError(err); auditID != "" {
+			audit = registerAudit(auditID, record)
+		} else if err != nil {
+			mylog.Printf("发送 %s 信息失败: %v message_id %v", part.kind(), err, msgID)
+		}
+		results = append(results, partResult{kind: part.kind(), messageID: recordSentMessage(resp, record), err: err, audit: audit})
+	}
+	return results
+}
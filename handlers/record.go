@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"github.com/hoshinonyaruko/gensokyo/images"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/silk"
+	"github.com/tencent-connect/botgo/dto"
+)
+
+// 语音来源对应的foundItems类型
+var recordKeys = []string{"local_record", "url_record", "base64_record"}
+
+// 取出第一个语音
+func findRecord(foundItems map[string][]string) (string, string, bool) {
+	for _, key := range recordKeys {
+		if values := foundItems[key]; len(values) > 0 {
+			return key, values[0], true
+		}
+	}
+	return "", "", false
+}
+
+// silk语音上传图床 生成file_type=3的富媒体信息 其他格式暂不支持转码 返回错误提示
+func generateRecordMessage(id, key, value string) interface{} {
+	data, err := loadMedia(key, value, mediaSizeLimits["record"])
+	if err != nil {
+		mylog.Printf("Error loading record: %v", err)
+		return &dto.MessageToCreate{
+			Content: "错误: 语音文件读取失败",
+			MsgID:   id,
+			MsgType: 0, // 默认文本类型
+		}
+	}
+	silkData, err := silk.Encode(data)
+	if err != nil {
+		mylog.Printf("Error encoding record to silk: %v", err)
+		content := "错误: 语音格式无法识别"
+		if errors.Is(err, silk.ErrNotSilk) {
+			content = "错误: 语音需要为silk格式 暂不支持转码"
+		}
+		return &dto.MessageToCreate{
+			Content: content,
+			MsgID:   id,
+			MsgType: 0, // 默认文本类型
+		}
+	}
+	recordURL, err := images.UploadBase64MediaToServer("record", base64.StdEncoding.EncodeToString(silkData), "")
+	if err != nil {
+		mylog.Printf("Error uploading record: %v", err)
+		return &dto.MessageToCreate{
+			Content: "错误: 上传语音失败",
+			MsgID:   id,
+			MsgType: 0, // 默认文本类型
+		}
+	}
+	return &dto.RichMediaMessage{
+		EventID:    id,
+		MsgID:      id,
+		FileType:   mediaFileTypes["record"],
+		URL:        recordURL,
+		Content:    " ",  // 官方要求：msg_type=7 时需要填空格
+		SrvSendMsg: true, // 直接发送（被动回复模式）
+	}
+}
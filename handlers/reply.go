@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/echo"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+)
+
+// errPassiveReplyExpired msg_id超过被动回复有效期 且配置不允许回退
+var errPassiveReplyExpired = errors.New("msg_id已超过被动回复有效期 按配置拒绝发送")
+
+// passiveReply 发送时使用的msg_id或event_id 都为空时作为主动消息发送
+type passiveReply struct {
+	msgID   string
+	eventID string
+	note    string // 回退的说明 放入回执的message
+}
+
+// msg_seq按msg_id或event_id分别计数
+func (r passiveReply) seqKey() string {
+	if r.msgID != "" {
+		return r.msgID
+	}
+	return r.eventID
+}
+
+// 填入msg_id event_id和msg_seq
+func (r passiveReply) apply(msg *dto.MessageToCreate, seq int) {
+	msg.MsgID = r.msgID
+	msg.EventID = r.eventID
+	msg.MsgSeq = seq
+}
+
+// resolvePassiveReply msg_id仍在有效期内时直接使用 收到时间未知时也照常使用
+// 过期后按配置依次尝试 event:该群或用户最近事件的event_id active:主动消息 都不可用时拒绝发送
+func resolvePassiveReply(scene, targetID, msgID string) (passiveReply, error) {
+	window := config.GetPassiveReplyWindow(scene)
+	received, ok := echo.GetMsgTime(msgID)
+	if msgID == "" || !ok || time.Since(time.Unix(received, 0)) <= window {
+		return passiveReply{msgID: msgID}, nil
+	}
+	mylog.Printf("msg_id[%s]已超过被动回复有效期%v", msgID, window)
+	for _, fallback := range config.GetPassiveReplyFallback(scene) {
+		switch fallback {
+		case "event":
+			eventID, eventTime, ok := echo.GetTargetEvent(scene, targetID)
+			if ok && time.Since(time.Unix(eventTime, 0)) <= window {
+				return passiveReply{eventID: eventID, note: "msg_id已超过被动回复有效期 已使用event_id回复"}, nil
+			}
+		case "active":
+			return passiveReply{note: "msg_id已超过被动回复有效期 已作为主动消息发送"}, nil
+		default:
+			mylog.Printf("未知的被动回复回退方式: %s", fallback)
+		}
+	}
+	return passiveReply{}, errPassiveReplyExpired
+}
+
+// 从foundItems中取出[CQ:reply]引用的消息记录 没有引用或记录不存在时返回false
+func takeReply(foundItems map[string][]string) (idmap.MessageRecord, bool) {
+	ids, ok := foundItems["reply"]
+	if !ok {
+		return idmap.MessageRecord{}, false
+	}
+	delete(foundItems, "reply")
+	record, err := getMessageRecord(ids[0])
+	if err != nil {
+		mylog.Printf("引用的消息不存在: %v", err)
+		return record, false
+	}
+	return record, true
+}
+
+// 群和单聊没有引用字段 引用的消息仍在被动回复有效期内时 使用它的id作为msg_id
+func resolveReplyMsgID(foundItems map[string][]string, scene string, messageID string) string {
+	record, ok := takeReply(foundItems)
+	if !ok {
+		return messageID
+	}
+	// bot自己发出的消息和其他场景的消息不能作为被动回复的msg_id
+	if record.Self || record.Scene != scene {
+		mylog.Printf("引用的消息%d无法用于被动回复", record.MessageID)
+		return messageID
+	}
+	if time.Since(time.Unix(record.Time, 0)) > config.GetPassiveReplyWindow(scene) {
+		mylog.Printf("引用的消息%d已超过被动回复有效期", record.MessageID)
+		return messageID
+	}
+	return record.RealID
+}
+
+// 频道和频道私信通过message_reference引用消息
+func takeMessageReference(foundItems map[string][]string) *dto.MessageReference {
+	record, ok := takeReply(foundItems)
+	if !ok {
+		return nil
+	}
+	return &dto.MessageReference{
+		MessageID:             record.RealID,
+		IgnoreGetMessageError: true,
+	}
+}
+
+// 收到的消息引用了其他消息时 返回被引用消息经过idmap转换的id
+func quotedMessageID(msg *dto.Message) (int64, bool) {
+	if msg.MessageReference == nil || msg.MessageReference.MessageID == "" {
+		return 0, false
+	}
+	messageID64, err := idmap.StoreIDv2(msg.MessageReference.MessageID)
+	if err != nil {
+		mylog.Printf("Error storing ID: %v", err)
+		return 0, false
+	}
+	return messageID64, true
+}
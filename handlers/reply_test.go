@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/echo"
+	"github.com/hoshinonyaruko/gensokyo/template"
+)
+
+// 使用默认配置模板 群被动回复过期后拒绝发送 单聊有效期为30分钟
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gensokyo-handlers-test")
+	if err != nil {
+		panic(err)
+	}
+	conf := strings.Replace(template.ConfigTemplate, `group_reply_fallback : ["event", "active"]`, `group_reply_fallback : []`, 1)
+	conf = strings.Replace(conf, "c2c_reply_window : 3600 ", "c2c_reply_window : 1800 ", 1)
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		panic(err)
+	}
+	if _, err := config.LoadConfig(path); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	// url包在init中于当前目录创建的短链接数据库
+	os.Remove("gensokyo.db")
+	os.Exit(code)
+}
+
+// 内存中的echo持久化后端 用于恢复指定时间的数据
+type memStore map[string]map[string][]byte
+
+func (s memStore) Apply(ops []echo.StoreOp) error {
+	for _, op := range ops {
+		if op.Value == nil {
+			delete(s[op.Bucket], op.Key)
+			continue
+		}
+		if s[op.Bucket] == nil {
+			s[op.Bucket] = make(map[string][]byte)
+		}
+		s[op.Bucket][op.Key] = op.Value
+	}
+	return nil
+}
+
+func (s memStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	for k, v := range s[bucket] {
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s memStore) put(bucket, key, value string, age time.Duration) {
+	data, _ := json.Marshal(map[string]interface{}{"v": value, "t": time.Now().Add(-age).Unix()})
+	s.Apply([]echo.StoreOp{{Bucket: bucket, Key: key, Value: data}})
+}
+
+// echo对应的msg_id超过10分钟后仍能查到 过期时按配置回退 而不是当作主动消息
+func TestResolvePassiveReplyAfterEchoCleanup(t *testing.T) {
+	cases := []struct {
+		name    string
+		scene   string
+		target  string
+		age     time.Duration // 收到消息到发送的时间
+		eventID string        // 不为空时该目标1分钟前收到过事件
+		want    passiveReply
+		err     error
+	}{
+		{name: "group refuses", scene: "group", target: "g1", age: 12 * time.Minute, err: errPassiveReplyExpired},
+		{name: "c2c within window", scene: "group_private", target: "u1", age: 12 * time.Minute, want: passiveReply{msgID: "msg-c2c within window"}},
+		{name: "c2c event fallback", scene: "group_private", target: "u2", age: 40 * time.Minute, eventID: "event-u2",
+			want: passiveReply{eventID: "event-u2", note: "msg_id已超过被动回复有效期 已使用event_id回复"}},
+		{name: "c2c active fallback", scene: "group_private", target: "u3", age: 40 * time.Minute,
+			want: passiveReply{note: "msg_id已超过被动回复有效期 已作为主动消息发送"}},
+	}
+	store := memStore{}
+	for _, tc := range cases {
+		store.put("msg_id", "echo-"+tc.name, "msg-"+tc.name, tc.age)
+		store.put("msg_time", "msg-"+tc.name, "", tc.age)
+		if tc.eventID != "" {
+			store.put("target_event", tc.scene+":"+tc.target, tc.eventID, time.Minute)
+		}
+	}
+	if err := echo.SetStore(store); err != nil {
+		t.Fatal(err)
+	}
+	defer echo.CloseStore()
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			msgID := echo.GetMsgIDByKey("echo-" + tc.name)
+			if msgID != "msg-"+tc.name {
+				t.Fatalf("GetMsgIDByKey = %q, want %q", msgID, "msg-"+tc.name)
+			}
+			got, err := resolvePassiveReply(tc.scene, tc.target, msgID)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("err = %v, want %v", err, tc.err)
+			}
+			if got != tc.want {
+				t.Errorf("reply = %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
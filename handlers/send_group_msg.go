@@ -1,13 +1,9 @@
 package handlers
 
 import (
-	"context"
 	"encoding/base64"
-	"encoding/json"
-	"fmt"
 	"os"
 	"strconv"
-	"time"
 
 	"github.com/hoshinonyaruko/gensokyo/callapi"
 	"github.com/hoshinonyaruko/gensokyo/config"
@@ -44,8 +40,8 @@ func handleSendGroupMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openap
 
 	switch msgType {
 	case "group":
-		// 解析消息内容
-		messageText, foundItems := parseMessageContent(message.Params)
+		// 按消息段顺序规划发送
+		parts, foundItems := planOutgoing(message.Params, false)
 
 		// 使用 echo 获取消息ID
 		var messageID string
@@ -59,8 +55,17 @@ func handleSendGroupMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openap
 			mylog.Printf("Error retrieving original GroupID: %v", err)
 			return
 		}
+		// 发出消息的记录 用于get_msg
+		groupID64, _ := strconv.ParseInt(message.Params.GroupID.(string), 10, 64)
+		record := idmap.MessageRecord{
+			MessageType: "group",
+			Scene:       "group",
+			GroupID:     groupID64,
+			Target:      originalGroupID,
+			Content:     recordContent(message.Params),
+		}
 		message.Params.GroupID = originalGroupID
-		mylog.Println("群组发信息messageText:", messageText)
+		mylog.Printf("群组发信息共%d条", len(parts))
 
 		// 第一步：尝试从echo获取MessageID，然后反查UserID
 		var realUserID int64
@@ -137,121 +142,18 @@ func handleSendGroupMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openap
 		messageID = realMsgID
 		mylog.Printf("最终使用的message_id: [%s]", messageID)
 
-		// 优先发送文本信息
-		if messageText != "" {
-			groupReply := generateGroupMessage(messageID, nil, messageText)
-
-			// 进行类型断言
-			groupMessage, ok := groupReply.(*dto.MessageToCreate)
-			if !ok {
-				mylog.Println("Error: Expected MessageToCreate type.")
-				return // 或其他错误处理
-			}
-
-			groupMessage.Timestamp = time.Now().Unix() // 设置时间戳
-			//重新为err赋值
-			_, err = apiv2.PostGroupMessage(context.TODO(), message.Params.GroupID.(string), groupMessage)
-			if err != nil {
-				mylog.Printf("发送文本群组信息失败: %v", err)
-				// 如果是真实错误，尝试发送错误提示（可能也会失败，但至少尝试一次）
-				if isRealFailure(err) {
-					errorMsg := &dto.MessageToCreate{
-						Content: fmt.Sprintf("消息发送失败: %v", err),
-						MsgID:   messageID,
-						MsgType: 0,
-					}
-					apiv2.PostGroupMessage(context.TODO(), message.Params.GroupID.(string), errorMsg)
-				}
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
+		// 引用的消息仍可被动回复时作为msg_id
+		messageID = resolveReplyMsgID(foundItems, "group", messageID)
+		// 超过被动回复有效期时 按配置改用event_id 主动消息或拒绝发送
+		reply, err := resolvePassiveReply("group", message.Params.GroupID.(string), messageID)
+		if err != nil {
+			SendPartsResponse(client, &message, refuseParts(parts, err), "")
+			return
 		}
 
-		// 遍历foundItems并发送每种信息（两步法发送图片）
-		var lastErr error
-		for key, urls := range foundItems {
-			mylog.Printf("准备发送 %s，URL: %v", key, urls)
-
-			var singleItem = make(map[string][]string)
-			singleItem[key] = urls
-			groupReply := generateGroupMessage(messageID, singleItem, "")
-
-			// 进行类型断言
-			richMediaMessage, ok := groupReply.(*dto.RichMediaMessage)
-			if !ok {
-				mylog.Printf("Error: Expected RichMediaMessage type for key %s.", key)
-				continue
-			}
-
-			// 步骤1: 上传图片获取 file_info（不直接发送，避免占用主动消息频次）
-			richMediaMessage.SrvSendMsg = false // 关键：仅上传，不发送
-			mylog.DebugPrintf("步骤1: 上传富媒体文件 - URL: %s", richMediaMessage.URL)
-
-			// 使用 Transport 方法直接调用上传接口
-			uploadData, err := apiv2.Transport(context.TODO(), "POST",
-				fmt.Sprintf("https://api.sgroup.qq.com/v2/groups/%s/files", message.Params.GroupID.(string)),
-				richMediaMessage)
-			if err != nil {
-				mylog.Printf("上传富媒体失败: %v", err)
-				lastErr = err
-
-				// 向QQ群发送错误提示消息
-				errorMsg := &dto.MessageToCreate{
-					Content: fmt.Sprintf("图片发送失败: %v", err),
-					MsgID:   messageID,
-					MsgType: 0, // 文本消息
-				}
-				apiv2.PostGroupMessage(context.TODO(), message.Params.GroupID.(string), errorMsg)
-
-				// 向WebSocket客户端返回错误响应
-				SendResponse(client, err, &message)
-				return
-			}
-
-			mylog.Printf("上传成功，响应: %s", string(uploadData))
-
-			// 解析上传响应获取 file_info
-			var uploadResp dto.RichMediaResponse
-			if err := json.Unmarshal(uploadData, &uploadResp); err != nil {
-				mylog.Printf("解析上传响应失败: %v", err)
-				lastErr = err
-
-				// 向QQ群发送错误提示消息
-				errorMsg := &dto.MessageToCreate{
-					Content: fmt.Sprintf("图片发送失败: %v", err),
-					MsgID:   messageID,
-					MsgType: 0, // 文本消息
-				}
-				apiv2.PostGroupMessage(context.TODO(), message.Params.GroupID.(string), errorMsg)
-
-				// 向WebSocket客户端返回错误响应
-				SendResponse(client, err, &message)
-				return
-			}
-
-			mylog.Printf("获取到 file_info: %s, TTL: %d 秒", uploadResp.FileInfo, uploadResp.TTL)
-
-			// 步骤2: 使用标准消息接口发送（被动回复模式）
-			mediaMessage := &dto.MessageToCreate{
-				Content: " ",       // 官方要求：msg_type=7 时需要填空格
-				MsgType: 7,         // 7 = 富媒体消息
-				MsgID:   messageID, // 被动回复的关键
-				Media: &dto.Media{
-					FileInfo: uploadResp.FileInfo,
-				},
-			}
-
-			mylog.DebugPrintf("步骤2: 发送富媒体消息 - GroupID: %s, MsgID: %s", message.Params.GroupID.(string), messageID)
-			_, err = apiv2.PostGroupMessage(context.TODO(), message.Params.GroupID.(string), mediaMessage)
-			if err != nil {
-				mylog.Printf("发送 %s 信息失败: %v", key, err)
-				lastErr = err
-			} else {
-				mylog.Printf("发送 %s 信息成功（图片已发送）", key)
-			}
-		}
-		// 所有媒体项处理完毕后，发送最终响应（如果有错误，返回最后一个错误）
-		SendResponse(client, lastErr, &message)
+		// 依次发送 文本和每个媒体各占一条 最后汇总回执
+		results := sendGroupParts(apiv2, mediaTarget{scene: "group", id: message.Params.GroupID.(string)}, reply, parts, foundItems, record)
+		SendPartsResponse(client, &message, results, reply.note)
 	case "guild":
 		//用GroupID给ChannelID赋值,因为我们是把频道虚拟成了群
 		message.Params.ChannelID = message.Params.GroupID.(string)
@@ -350,8 +252,12 @@ func generateGroupMessage(id string, foundItems map[string][]string, messageText
 			Content:    " ",                      // 官方要求：msg_type=7 时需要填空格
 			SrvSendMsg: true,                     // 直接发送消息
 		}
-	} else if voiceURLs, ok := foundItems["base64_record"]; ok && len(voiceURLs) > 0 {
-		// 目前不支持发语音 todo 适配base64 slik
+	} else if key, value, ok := findRecord(foundItems); ok {
+		// 语音需要转码为silk
+		return generateRecordMessage(id, key, value)
+	} else if mediaType, key, value, ok := findMedia(foundItems); ok {
+		// 视频和文件
+		return generateMediaMessage(id, mediaType, key, value)
 	} else if base64_image, ok := foundItems["base64_image"]; ok && len(base64_image) > 0 {
 		// todo 适配base64图片
 		//因为QQ群没有 form方式上传,所以在gensokyo内置了图床,需公网,或以lotus方式连接位于公网的gensokyo
@@ -10,6 +10,7 @@ import (
 	"github.com/hoshinonyaruko/gensokyo/idmap"
 	"github.com/hoshinonyaruko/gensokyo/images"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/sendqueue"
 
 	"github.com/hoshinonyaruko/gensokyo/echo"
 
@@ -49,7 +50,8 @@ func handleSendGuildChannelMsg(client callapi.Client, api openapi.OpenAPI, apiv2
 	//原生guild信息
 	case "guild":
 		params := message.Params
-		messageText, foundItems := parseMessageContent(params)
+		// 按消息段顺序规划发送 图片和前面的文本一起发送
+		parts, foundItems := planOutgoing(params, true)
 
 		channelID := params.ChannelID
 		//mylog.Printf("发送文本信息失败: %v,%v", channelID, channelID)
@@ -64,52 +66,31 @@ func handleSendGuildChannelMsg(client callapi.Client, api openapi.OpenAPI, apiv2
 			messageID = GetMessageIDByUseridOrGroupid(config.GetAppIDStr(), channelID)
 			mylog.Println("通过GetMessageIDByUseridOrGroupid函数获取的message_id:", messageID)
 		}
-		mylog.Println("频道发信息messageText:", messageText)
+		mylog.Printf("频道发信息共%d条", len(parts))
 		//mylog.Println("foundItems:", foundItems)
-		// 优先发送文本信息
-		var err error
-		if messageText != "" {
-			textMsg, _ := generateReplyMessage(messageID, nil, messageText)
-			if _, err = api.PostMessage(context.TODO(), channelID, textMsg); err != nil {
-				mylog.Printf("发送文本信息失败: %v", err)
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
+		// 发出消息的记录 用于get_msg
+		record := idmap.MessageRecord{
+			MessageType: "guild",
+			Scene:       "guild",
+			Target:      channelID,
+			GuildID:     params.GuildID,
+			Content:     recordContent(params),
 		}
-
-		// 遍历foundItems并发送每种信息
-		for key, urls := range foundItems {
-			var singleItem = make(map[string][]string)
-			singleItem[key] = urls
-
-			reply, isBase64Image := generateReplyMessage(messageID, singleItem, "")
-
-			if isBase64Image {
-				// 将base64内容从reply的Content转换回字节
-				fileImageData, err := base64.StdEncoding.DecodeString(reply.Content)
-				if err != nil {
-					mylog.Printf("Base64 解码失败: %v", err)
-					return // 或其他的错误处理方式
-				}
-
-				// 清除reply的Content
-				reply.Content = ""
-
-				// 使用Multipart方法发送
-				if _, err = api.PostMessageMultipart(context.TODO(), channelID, reply, fileImageData); err != nil {
-					mylog.Printf("使用multipart发送 %s 信息失败: %v message_id %v", key, err, messageID)
-				}
-				//发送成功回执
-				SendResponse(client, err, &message)
-			} else {
-				if _, err = api.PostMessage(context.TODO(), channelID, reply); err != nil {
-					mylog.Printf("发送 %s 信息失败: %v", key, err)
-				}
-				//发送成功回执
-				SendResponse(client, err, &message)
-			}
-
+		// 通过message_reference引用消息
+		reference := takeMessageReference(foundItems)
+
+		// 依次发送 最后汇总回执
+		poster := guildPoster{
+			target: sendqueue.Target{Scope: "channel", ID: channelID},
+			post: func(msg *dto.MessageToCreate) (*dto.Message, error) {
+				return api.PostMessage(context.TODO(), channelID, msg)
+			},
+			multipart: func(msg *dto.MessageToCreate, image []byte) (*dto.Message, error) {
+				return api.PostMessageMultipart(context.TODO(), channelID, msg, image)
+			},
 		}
+		results := sendGuildParts(poster, messageID, parts, foundItems, reference, record)
+		SendPartsResponse(client, &message, results, "")
 	//频道私信 此时直接取出
 	case "guild_private":
 		params := message.Params
@@ -173,7 +154,9 @@ func generateReplyMessage(id string, foundItems map[string][]string, messageText
 			MsgID:   id,
 			MsgType: 0, // Assuming type 0 for images
 		}
-	} else if voiceURLs, ok := foundItems["base64_record"]; ok && len(voiceURLs) > 0 {
+	} else if _, _, _, isMedia := findMedia(foundItems); isMedia {
+		//还不支持发视频和文件
+	} else if _, _, ok := findRecord(foundItems); ok {
 		//还不支持发语音
 		// Sending a voice message
 		// reply = dto.MessageToCreate{
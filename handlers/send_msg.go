@@ -1,17 +1,14 @@
 package handlers
 
 import (
-	"context"
 	"fmt"
 	"strconv"
-	"time"
 
 	"github.com/hoshinonyaruko/gensokyo/callapi"
 	"github.com/hoshinonyaruko/gensokyo/config"
 	"github.com/hoshinonyaruko/gensokyo/echo"
 	"github.com/hoshinonyaruko/gensokyo/idmap"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
-	"github.com/tencent-connect/botgo/dto"
 	"github.com/tencent-connect/botgo/openapi"
 )
 
@@ -46,8 +43,8 @@ func handleSendMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.Ope
 
 	switch msgType {
 	case "group":
-		// 解析消息内容
-		messageText, foundItems := parseMessageContent(message.Params)
+		// 按消息段顺序规划发送
+		parts, foundItems := planOutgoing(message.Params, false)
 
 		// 使用 echo 获取消息ID
 		var messageID string
@@ -55,79 +52,40 @@ func handleSendMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.Ope
 			messageID = echo.GetMsgIDByKey(echoStr)
 			mylog.Println("echo取群组发信息对应的message_id:", messageID)
 		}
-		mylog.Println("群组发信息messageText:", messageText)
+		mylog.Printf("群组发信息共%d条", len(parts))
 		//通过bolt数据库还原真实的GroupID
 		originalGroupID, err := idmap.RetrieveRowByIDv2(message.Params.GroupID.(string))
 		if err != nil {
 			mylog.Printf("Error retrieving original GroupID: %v", err)
 			return
 		}
+		// 发出消息的记录 用于get_msg
+		groupID64, _ := strconv.ParseInt(message.Params.GroupID.(string), 10, 64)
+		record := idmap.MessageRecord{
+			MessageType: "group",
+			Scene:       "group",
+			GroupID:     groupID64,
+			Target:      originalGroupID,
+			Content:     recordContent(message.Params),
+		}
 		message.Params.GroupID = originalGroupID
 		// 如果messageID为空，通过函数获取
 		if messageID == "" {
 			messageID = GetMessageIDByUseridOrGroupid(config.GetAppIDStr(), message.Params.GroupID)
 			mylog.Println("通过GetMessageIDByUserid函数获取的message_id:", messageID)
 		}
-		// 优先发送文本信息
-		if messageText != "" {
-			groupReply := generateGroupMessage(messageID, nil, messageText)
-
-			// 进行类型断言
-			groupMessage, ok := groupReply.(*dto.MessageToCreate)
-			if !ok {
-				mylog.Println("Error: Expected MessageToCreate type.")
-				return // 或其他错误处理
-			}
-
-			groupMessage.Timestamp = time.Now().Unix() // 设置时间戳
-			_, err = apiv2.PostGroupMessage(context.TODO(), message.Params.GroupID.(string), groupMessage)
-			if err != nil {
-				mylog.Printf("发送文本群组信息失败: %v", err)
-				// 如果是真实错误，尝试发送错误提示
-				if isRealFailure(err) {
-					errorMsg := &dto.MessageToCreate{
-						Content: fmt.Sprintf("消息发送失败: %v", err),
-						MsgID:   messageID,
-						MsgType: 0,
-					}
-					apiv2.PostGroupMessage(context.TODO(), message.Params.GroupID.(string), errorMsg)
-				}
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
+		// 引用的消息仍可被动回复时作为msg_id
+		messageID = resolveReplyMsgID(foundItems, "group", messageID)
+		// 超过被动回复有效期时 按配置改用event_id 主动消息或拒绝发送
+		reply, err := resolvePassiveReply("group", message.Params.GroupID.(string), messageID)
+		if err != nil {
+			SendPartsResponse(client, &message, refuseParts(parts, err), "")
+			return
 		}
 
-		// 遍历foundItems并发送每种信息
-		for key, urls := range foundItems {
-			var singleItem = make(map[string][]string)
-			singleItem[key] = urls
-
-			groupReply := generateGroupMessage(messageID, singleItem, "")
-
-			// 进行类型断言
-			richMediaMessage, ok := groupReply.(*dto.RichMediaMessage)
-			if !ok {
-				mylog.Printf("Error: Expected RichMediaMessage type for key %s.", key)
-				continue // 跳过这个项，继续下一个
-			}
-
-			mylog.Printf("richMediaMessage: %+v\n", richMediaMessage)
-			_, err = apiv2.PostGroupMessage(context.TODO(), message.Params.GroupID.(string), richMediaMessage)
-			if err != nil {
-				mylog.Printf("发送 %s 信息失败_send_msg: %v", key, err)
-				// 如果是真实错误，尝试发送错误提示
-				if isRealFailure(err) {
-					errorMsg := &dto.MessageToCreate{
-						Content: fmt.Sprintf("图片发送失败: %v", err),
-						MsgID:   messageID,
-						MsgType: 0,
-					}
-					apiv2.PostGroupMessage(context.TODO(), message.Params.GroupID.(string), errorMsg)
-				}
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
-		}
+		// 依次发送 文本和每个媒体各占一条 最后汇总回执
+		results := sendGroupParts(apiv2, mediaTarget{scene: "group", id: message.Params.GroupID.(string)}, reply, parts, foundItems, record)
+		SendPartsResponse(client, &message, results, reply.note)
 	case "guild":
 		//用GroupID给ChannelID赋值,因为我们是把频道虚拟成了群
 		message.Params.ChannelID = message.Params.GroupID.(string)
@@ -168,7 +126,6 @@ func handleSendMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.Ope
 		//私聊信息
 		// 优先从 request_id/echo 中解析UserID（如果对方返回了request_id）
 		var UserID int64
-		var err error
 		if echoStr, ok := resolveEchoToString(echoVal); ok {
 			// 通过 echo->messageID->userID 反向映射查找真实的UserID
 			if msgID := echo.GetMsgIDByKey(echoStr); msgID != "" {
@@ -192,8 +149,15 @@ func handleSendMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.Ope
 			}
 		}
 
-		// 解析消息内容
-		messageText, foundItems := parseMessageContent(message.Params)
+		// 按消息段顺序规划发送
+		parts, foundItems := planOutgoing(message.Params, false)
+		// 发出消息的记录 用于get_msg
+		record := idmap.MessageRecord{
+			MessageType: "private",
+			Scene:       "group_private",
+			Target:      fmt.Sprint(UserID),
+			Content:     recordContent(message.Params),
+		}
 
 		// 使用 echo 获取消息ID
 		var messageID string
@@ -206,67 +170,21 @@ func handleSendMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.Ope
 			messageID = GetMessageIDByUseridOrGroupid(config.GetAppIDStr(), UserID)
 			mylog.Println("通过GetMessageIDByUserid函数获取的message_id:", messageID)
 		}
-		mylog.Println("私聊发信息messageText:", messageText)
+		mylog.Printf("私聊发信息共%d条", len(parts))
 		//mylog.Println("foundItems:", foundItems)
 
-		// 优先发送文本信息
-		if messageText != "" {
-			groupReply := generateGroupMessage(messageID, nil, messageText)
-
-			// 进行类型断言
-			groupMessage, ok := groupReply.(*dto.MessageToCreate)
-			if !ok {
-				mylog.Println("Error: Expected MessageToCreate type.")
-				return
-			}
-
-			groupMessage.Timestamp = time.Now().Unix() // 设置时间戳
-			_, err = apiv2.PostC2CMessage(context.TODO(), fmt.Sprint(UserID), groupMessage)
-			if err != nil {
-				mylog.Printf("发送文本私聊信息失败: %v", err)
-				// 如果是真实错误，尝试发送错误提示
-				if isRealFailure(err) {
-					errorMsg := &dto.MessageToCreate{
-						Content: fmt.Sprintf("消息发送失败: %v", err),
-						MsgID:   messageID,
-						MsgType: 0,
-					}
-					apiv2.PostC2CMessage(context.TODO(), fmt.Sprint(UserID), errorMsg)
-				}
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
+		// 引用的消息仍可被动回复时作为msg_id
+		messageID = resolveReplyMsgID(foundItems, "group_private", messageID)
+		// 超过被动回复有效期时 按配置改用event_id 主动消息或拒绝发送
+		reply, err := resolvePassiveReply("group_private", fmt.Sprint(UserID), messageID)
+		if err != nil {
+			SendPartsResponse(client, &message, refuseParts(parts, err), "")
+			return
 		}
 
-		// 遍历 foundItems 并发送每种信息
-		for key, urls := range foundItems {
-			var singleItem = make(map[string][]string)
-			singleItem[key] = urls
-
-			groupReply := generateGroupMessage(messageID, singleItem, "")
-
-			// 进行类型断言
-			richMediaMessage, ok := groupReply.(*dto.RichMediaMessage)
-			if !ok {
-				mylog.Printf("Error: Expected RichMediaMessage type for key %s.", key)
-				continue
-			}
-			_, err = apiv2.PostC2CMessage(context.TODO(), fmt.Sprint(UserID), richMediaMessage)
-			if err != nil {
-				mylog.Printf("发送 %s 私聊信息失败: %v", key, err)
-				// 如果是真实错误，尝试发送错误提示
-				if isRealFailure(err) {
-					errorMsg := &dto.MessageToCreate{
-						Content: fmt.Sprintf("图片发送失败: %v", err),
-						MsgID:   messageID,
-						MsgType: 0,
-					}
-					apiv2.PostC2CMessage(context.TODO(), fmt.Sprint(UserID), errorMsg)
-				}
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
-		}
+		// 依次发送 文本和每个媒体各占一条 最后汇总回执
+		results := sendGroupParts(apiv2, mediaTarget{scene: "group_private", id: fmt.Sprint(UserID)}, reply, parts, foundItems, record)
+		SendPartsResponse(client, &message, results, reply.note)
 	default:
 		mylog.Printf("1Unknown message type: %s", msgType)
 	}
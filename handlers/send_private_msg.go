@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"context"
-	"encoding/base64"
 	"fmt"
 	"strconv"
 	"time"
@@ -12,6 +11,7 @@ import (
 	"github.com/hoshinonyaruko/gensokyo/echo"
 	"github.com/hoshinonyaruko/gensokyo/idmap"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/sendqueue"
 	"github.com/tencent-connect/botgo/dto"
 	"github.com/tencent-connect/botgo/openapi"
 )
@@ -70,8 +70,15 @@ func handleSendPrivateMsg(client callapi.Client, api openapi.OpenAPI, apiv2 open
 				return
 			}
 		}
-		// 解析消息内容
-		messageText, foundItems := parseMessageContent(message.Params)
+		// 按消息段顺序规划发送
+		parts, foundItems := planOutgoing(message.Params, false)
+		// 发出消息的记录 用于get_msg
+		record := idmap.MessageRecord{
+			MessageType: "private",
+			Scene:       "group_private",
+			Target:      fmt.Sprint(UserID),
+			Content:     recordContent(message.Params),
+		}
 		// 使用 echo 获取消息ID
 		var messageID string
 		if echoStr, ok := resolveEchoToString(echoVal); ok {
@@ -83,68 +90,21 @@ func handleSendPrivateMsg(client callapi.Client, api openapi.OpenAPI, apiv2 open
 			messageID = GetMessageIDByUseridOrGroupid(config.GetAppIDStr(), UserID)
 			mylog.Println("通过GetMessageIDByUserid函数获取的message_id:", messageID)
 		}
-		mylog.Println("私聊发信息messageText:", messageText)
+		mylog.Printf("私聊发信息共%d条", len(parts))
 		//mylog.Println("foundItems:", foundItems)
 
-		// 优先发送文本信息
-		if messageText != "" {
-			groupReply := generateGroupMessage(messageID, nil, messageText)
-
-			// 进行类型断言
-			groupMessage, ok := groupReply.(*dto.MessageToCreate)
-			if !ok {
-				mylog.Println("Error: Expected MessageToCreate type.")
-				return
-			}
-
-			groupMessage.Timestamp = time.Now().Unix() // 设置时间戳
-			_, err := apiv2.PostC2CMessage(context.TODO(), fmt.Sprint(UserID), groupMessage)
-			if err != nil {
-				mylog.Printf("发送文本私聊信息失败: %v", err)
-				// 如果是真实错误，尝试发送错误提示
-				if isRealFailure(err) {
-					errorMsg := &dto.MessageToCreate{
-						Content: fmt.Sprintf("消息发送失败: %v", err),
-						MsgID:   messageID,
-						MsgType: 0,
-					}
-					apiv2.PostC2CMessage(context.TODO(), fmt.Sprint(UserID), errorMsg)
-				}
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
+		// 引用的消息仍可被动回复时作为msg_id
+		messageID = resolveReplyMsgID(foundItems, "group_private", messageID)
+		// 超过被动回复有效期时 按配置改用event_id 主动消息或拒绝发送
+		reply, err := resolvePassiveReply("group_private", fmt.Sprint(UserID), messageID)
+		if err != nil {
+			SendPartsResponse(client, &message, refuseParts(parts, err), "")
+			return
 		}
 
-		// 遍历 foundItems 并发送每种信息
-		for key, urls := range foundItems {
-			var singleItem = make(map[string][]string)
-			singleItem[key] = urls
-
-			//先试试用群里一样的处理逻辑,看看能跑不
-			groupReply := generateGroupMessage(messageID, singleItem, "")
-
-			// 进行类型断言
-			richMediaMessage, ok := groupReply.(*dto.RichMediaMessage)
-			if !ok {
-				mylog.Printf("Error: Expected RichMediaMessage type for key %s.", key)
-				continue
-			}
-			_, err := apiv2.PostC2CMessage(context.TODO(), fmt.Sprint(UserID), richMediaMessage)
-			if err != nil {
-				mylog.Printf("发送 %s 私聊信息失败: %v", key, err)
-				// 如果是真实错误，尝试发送错误提示
-				if isRealFailure(err) {
-					errorMsg := &dto.MessageToCreate{
-						Content: fmt.Sprintf("图片发送失败: %v", err),
-						MsgID:   messageID,
-						MsgType: 0,
-					}
-					apiv2.PostC2CMessage(context.TODO(), fmt.Sprint(UserID), errorMsg)
-				}
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
-		}
+		// 依次发送 文本和每个媒体各占一条 最后汇总回执
+		results := sendGroupParts(apiv2, mediaTarget{scene: "group_private", id: fmt.Sprint(UserID)}, reply, parts, foundItems, record)
+		SendPartsResponse(client, &message, results, reply.note)
 	case "guild_private":
 		//当收到发私信调用 并且来源是频道
 		handleSendGuildChannelPrivateMsg(client, api, apiv2, message, nil, nil)
@@ -153,43 +113,11 @@ func handleSendPrivateMsg(client callapi.Client, api openapi.OpenAPI, apiv2 open
 	}
 }
 
-// 这里是只有群私聊会用到
-func generatePrivateMessage(id string, foundItems map[string][]string, messageText string) interface{} {
-	if imageURLs, ok := foundItems["local_image"]; ok && len(imageURLs) > 0 {
-		// 本地发图逻辑 todo 适配base64图片
-		return &dto.RichMediaMessage{
-			EventID:    id,
-			FileType:   1, // 1代表图片
-			URL:        imageURLs[0],
-			Content:    "", // 这个字段文档没有了
-			SrvSendMsg: true,
-		}
-	} else if imageURLs, ok := foundItems["url_image"]; ok && len(imageURLs) > 0 {
-		// 发链接图片
-		return &dto.RichMediaMessage{
-			EventID:    id,
-			FileType:   1, // 1代表图片
-			URL:        "http://" + imageURLs[0],
-			Content:    "", // 这个字段文档没有了
-			SrvSendMsg: true,
-		}
-	} else if voiceURLs, ok := foundItems["base64_record"]; ok && len(voiceURLs) > 0 {
-		// 目前不支持发语音 todo 适配base64 slik
-	} else {
-		// 返回文本信息
-		return &dto.MessageToCreate{
-			Content: messageText,
-			MsgID:   id,
-			MsgType: 0, // 默认文本类型
-		}
-	}
-	return nil
-}
-
 // 处理频道私信 最后2个指针参数可空 代表使用userid倒推
 func handleSendGuildChannelPrivateMsg(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.OpenAPI, message callapi.ActionMessage, optionalGuildID *string, optionalChannelID *string) {
 	params := message.Params
-	messageText, foundItems := parseMessageContent(params)
+	// 按消息段顺序规划发送 图片和前面的文本一起发送
+	parts, foundItems := planOutgoing(params, true)
 
 	var guildID, channelID string
 	var err error
@@ -213,7 +141,7 @@ func handleSendGuildChannelPrivateMsg(client callapi.Client, api openapi.OpenAPI
 		messageID = echo.GetMsgIDByKey(echoStr)
 		mylog.Println("echo取私聊发信息对应的message_id:", messageID)
 	}
-	mylog.Println("私聊信息messageText:", messageText)
+	mylog.Printf("私信共%d条", len(parts))
 	//mylog.Println("foundItems:", foundItems)
 	// 如果messageID为空，通过函数获取
 	if messageID == "" {
@@ -224,6 +152,15 @@ func handleSendGuildChannelPrivateMsg(client callapi.Client, api openapi.OpenAPI
 	timestamp := time.Now().Unix()
 	timestampStr := fmt.Sprintf("%d", timestamp)
 
+	// 发出消息的记录 用于get_msg
+	record := idmap.MessageRecord{
+		MessageType: "private",
+		Scene:       "guild_private",
+		Target:      channelID,
+		GuildID:     guildID,
+		Content:     recordContent(message.Params),
+	}
+
 	// 构造 dm (dms 私信事件)
 	dm := &dto.DirectMessage{
 		GuildID:    guildID,
@@ -231,49 +168,21 @@ func handleSendGuildChannelPrivateMsg(client callapi.Client, api openapi.OpenAPI
 		CreateTime: timestampStr,
 	}
 
-	// 优先发送文本信息
-	if messageText != "" {
-		textMsg, _ := generateReplyMessage(messageID, nil, messageText)
-		if _, err = apiv2.PostDirectMessage(context.TODO(), dm, textMsg); err != nil {
-			mylog.Printf("发送文本信息失败: %v", err)
-		}
-		//发送成功回执
-		SendResponse(client, err, &message)
-	}
-
-	// 遍历foundItems并发送每种信息
-	for key, urls := range foundItems {
-		var singleItem = make(map[string][]string)
-		singleItem[key] = urls
-
-		reply, isBase64Image := generateReplyMessage(messageID, singleItem, "")
-
-		if isBase64Image {
-			// 将base64内容从reply的Content转换回字节
-			fileImageData, err := base64.StdEncoding.DecodeString(reply.Content)
-			if err != nil {
-				mylog.Printf("Base64 解码失败: %v", err)
-				return // 或其他的错误处理方式
-			}
-
-			// 清除reply的Content
-			reply.Content = ""
-
-			// 使用Multipart方法发送
-			if _, err = api.PostDirectMessageMultipart(context.TODO(), dm, reply, fileImageData); err != nil {
-				mylog.Printf("使用multipart发送 %s 信息失败: %v message_id %v", key, err, messageID)
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
-		} else {
-			if _, err = api.PostDirectMessage(context.TODO(), dm, reply); err != nil {
-				mylog.Printf("发送 %s 信息失败: %v", key, err)
-			}
-			//发送成功回执
-			SendResponse(client, err, &message)
-		}
-
+	// 通过message_reference引用消息
+	reference := takeMessageReference(foundItems)
+
+	// 依次发送 最后汇总回执
+	poster := guildPoster{
+		target: sendqueue.Target{Scope: "user", ID: guildID},
+		post: func(msg *dto.MessageToCreate) (*dto.Message, error) {
+			return apiv2.PostDirectMessage(context.TODO(), dm, msg)
+		},
+		multipart: func(msg *dto.MessageToCreate, image []byte) (*dto.Message, error) {
+			return api.PostDirectMessageMultipart(context.TODO(), dm, msg, image)
+		},
 	}
+	results := sendGuildParts(poster, messageID, parts, foundItems, reference, record)
+	SendPartsResponse(client, &message, results, "")
 }
 
 // 这个函数可以通过int类型的虚拟userid反推真实的guild_id和channel_id
@@ -12,6 +12,8 @@ import (
 )
 
 func init() {
+	callapi.RegisterHandler("set_group_ban", setGroupBan)
+	// 兼容之前注册的错误名称
 	callapi.RegisterHandler("get_group_ban", setGroupBan)
 }
 
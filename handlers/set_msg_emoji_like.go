@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+type EmojiLikeResponse struct {
+	Data      interface{} `json:"data"`
+	Message   string      `json:"message"`
+	RetCode   int         `json:"retcode"`
+	Status    string      `json:"status"`
+	Echo      interface{} `json:"echo,omitempty"`
+	RequestID interface{} `json:"request_id,omitempty"`
+}
+
+var errReactionScene = errors.New("only guild channel messages support emoji reactions")
+
+func init() {
+	callapi.RegisterHandler("set_msg_emoji_like", handleSetMsgEmojiLike)
+}
+
+// set为false时等同于delete_msg_emoji_like
+func handleSetMsgEmojiLike(client callapi.Client, api openapi.OpenAPI, apiv2 openapi.OpenAPI, message callapi.ActionMessage) {
+	set := message.Params.Set == nil || *message.Params.Set
+	handleMsgEmojiLike(client, api, message, func(channelID, messageID string, emoji dto.Emoji) error {
+		if !set {
+			return api.DeleteOwnMessageReaction(context.TODO(), channelID, messageID, emoji)
+		}
+		return api.CreateMessageReaction(context.TODO(), channelID, messageID, emoji)
+	})
+}
+
+// 表态和取消表态的公共流程 解析参数后调用react并返回回执
+func handleMsgEmojiLike(client callapi.Client, api openapi.OpenAPI, message callapi.ActionMessage,
+	react func(channelID, messageID string, emoji dto.Emoji) error) {
+	var response EmojiLikeResponse
+	if config.GetUseRequestID() {
+		response.RequestID = callapi.GetActionEchoKey(message)
+	} else {
+		response.Echo = message.Echo
+	}
+
+	channelID, messageID, err := reactionTarget(message.Params)
+	var emoji dto.Emoji
+	if err == nil {
+		emoji, err = reactionEmoji(message.Params)
+	}
+	if err != nil {
+		mylog.Printf("%s: %v", message.Action, err)
+		response.Message = err.Error()
+		response.RetCode = 100
+		response.Status = "failed"
+	} else if err = react(channelID, messageID, emoji); err != nil {
+		mylog.Printf("%s失败: %v", message.Action, err)
+		response.Message = sanitizeErrorMessage(err)
+		response.RetCode = -1
+		response.Status = "failed"
+	} else {
+		response.Status = "ok"
+	}
+
+	outputMap := structToMap(response)
+	if err := client.SendMessage(outputMap); err != nil {
+		mylog.Printf("Error sending message via client: %v", err)
+	} else {
+		mylog.Printf("响应%s: %+v", message.Action, outputMap)
+	}
+}
+
+// 表情表态只支持频道消息 找不到消息记录时使用channel_id和原始的message_id
+func reactionTarget(params callapi.ParamsContent) (string, string, error) {
+	record, err := getMessageRecord(params.MessageID)
+	if err == nil {
+		if record.Scene != "guild" {
+			return "", "", errReactionScene
+		}
+		return record.Target, record.RealID, nil
+	}
+	if rawID, ok := params.MessageID.(string); ok && params.ChannelID != "" {
+		return params.ChannelID, rawID, nil
+	}
+	return "", "", err
+}
+
+// 解析emoji_id和emoji_type 未指定类型时 数字不超过999为系统表情 其余为emoji
+// emoji可以直接传字符 会转换为码点
+func reactionEmoji(params callapi.ParamsContent) (dto.Emoji, error) {
+	var id string
+	switch v := params.EmojiID.(type) {
+	case float64:
+		id = strconv.FormatFloat(v, 'f', 0, 64)
+	case string:
+		id = strings.TrimSpace(v)
+	}
+	if id == "" {
+		return dto.Emoji{}, errors.New("emoji_id is required")
+	}
+	number, err := strconv.Atoi(id)
+	if err != nil {
+		r, size := utf8.DecodeRuneInString(id)
+		if r == utf8.RuneError || size != len(id) {
+			return dto.Emoji{}, fmt.Errorf("invalid emoji_id: %s", id)
+		}
+		number, id = int(r), strconv.Itoa(int(r))
+	}
+	emojiType := params.EmojiType
+	if emojiType == 0 {
+		emojiType = 1
+		if number > 999 {
+			emojiType = 2
+		}
+	}
+	return dto.Emoji{ID: id, Type: emojiType}, nil
+}
@@ -0,0 +1,118 @@
+// 以onebot v11 http post(反向http)的方式上报事件
+package httppost
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+)
+
+// HttpPostClient 对应一个http上报地址
+type HttpPostClient struct {
+	url        string
+	secret     string
+	botID      uint64
+	httpClient *http.Client
+}
+
+var (
+	clients     []*HttpPostClient
+	clientsOnce sync.Once
+)
+
+// NewHttpPostClient 创建一个http上报客户端 secret为空时不签名
+func NewHttpPostClient(url string, secret string, botID uint64, timeout time.Duration) *HttpPostClient {
+	return &HttpPostClient{
+		url:        url,
+		secret:     secret,
+		botID:      botID,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+// GetClients 根据配置文件中的http_post_address创建上报客户端,只会创建一次
+func GetClients() []*HttpPostClient {
+	clientsOnce.Do(func() {
+		addresses := config.GetHttpPostAddress()
+		secrets := config.GetHttpPostSecret()
+		timeout := time.Duration(config.GetHttpPostTimeout()) * time.Second
+		for index, address := range addresses {
+			if address == "" {
+				continue
+			}
+			var secret string
+			if index < len(secrets) {
+				secret = secrets[index]
+			}
+			clients = append(clients, NewHttpPostClient(address, secret, config.GetAppID(), timeout))
+			mylog.Printf("http上报地址[%s]已添加\n", address)
+		}
+	})
+	return clients
+}
+
+// URL 返回上报地址
+func (c *HttpPostClient) URL() string {
+	return c.url
+}
+
+// Sign 计算body的HMAC-SHA1签名,即X-Signature头中sha1=之后的部分
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// PostEvent 上报事件 返回上报地址响应的快速操作 响应为空(如204)时返回nil
+func (c *HttpPostClient) PostEvent(message map[string]interface{}) (map[string]interface{}, error) {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CQHttp/4.15.0")
+	req.Header.Set("X-Self-ID", fmt.Sprintf("%d", c.botID))
+	if c.secret != "" {
+		req.Header.Set("X-Signature", "sha1="+Sign(c.secret, body))
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to post event to %s: %w", c.url, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", c.url, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("http post to %s returned status %d", c.url, resp.StatusCode)
+	}
+	if resp.StatusCode == http.StatusNoContent || len(bytes.TrimSpace(respBody)) == 0 {
+		return nil, nil
+	}
+
+	var quickOperation map[string]interface{}
+	if err := json.Unmarshal(respBody, &quickOperation); err != nil {
+		// 上报地址返回的不是json时只记录 不影响上报
+		mylog.Printf("http上报地址[%s]返回了无法解析的快速操作: %s\n", c.url, string(respBody))
+		return nil, nil
+	}
+	return quickOperation, nil
+}
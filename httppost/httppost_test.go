@@ -0,0 +1,77 @@
+package httppost
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	cases := []struct {
+		secret string
+		body   string
+		want   string
+	}{
+		{"key", "The quick brown fox jumps over the lazy dog", "de7c9b85b8b78aa6bc8a7a36f70a90701c9db4d9"},
+		{"", "", "fbdb1d1b18aa6c08324b7d64b71fb76370690e1d"},
+	}
+	for _, tc := range cases {
+		if got := Sign(tc.secret, []byte(tc.body)); got != tc.want {
+			t.Errorf("Sign(%q, %q) = %s, want %s", tc.secret, tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestPostEvent(t *testing.T) {
+	cases := []struct {
+		name      string
+		secret    string
+		status    int
+		response  string
+		wantOp    map[string]interface{}
+		wantError bool
+	}{
+		{name: "signed with quick operation", secret: "s3cret", status: http.StatusOK, response: `{"reply":"hi","at_sender":false}`,
+			wantOp: map[string]interface{}{"reply": "hi", "at_sender": false}},
+		{name: "unsigned no content", status: http.StatusNoContent},
+		{name: "empty body", secret: "s3cret", status: http.StatusOK, response: "  "},
+		{name: "non json response ignored", status: http.StatusOK, response: "ok"},
+		{name: "server error", status: http.StatusInternalServerError, wantError: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var gotSignature, gotSelfID string
+			var gotBody []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotSignature = r.Header.Get("X-Signature")
+				gotSelfID = r.Header.Get("X-Self-ID")
+				gotBody, _ = io.ReadAll(r.Body)
+				w.WriteHeader(tc.status)
+				io.WriteString(w, tc.response)
+			}))
+			defer srv.Close()
+
+			client := NewHttpPostClient(srv.URL, tc.secret, 10001, time.Second)
+			op, err := client.PostEvent(map[string]interface{}{"post_type": "message", "message": "hello"})
+			if (err != nil) != tc.wantError {
+				t.Fatalf("PostEvent error = %v, want error %v", err, tc.wantError)
+			}
+			if !reflect.DeepEqual(op, tc.wantOp) {
+				t.Errorf("quick operation = %#v, want %#v", op, tc.wantOp)
+			}
+			if gotSelfID != "10001" {
+				t.Errorf("X-Self-ID = %q", gotSelfID)
+			}
+			wantSignature := ""
+			if tc.secret != "" {
+				wantSignature = "sha1=" + Sign(tc.secret, gotBody)
+			}
+			if gotSignature != wantSignature {
+				t.Errorf("X-Signature = %q, want %q", gotSignature, wantSignature)
+			}
+		})
+	}
+}
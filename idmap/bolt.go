@@ -0,0 +1,145 @@
+package idmap
+
+import (
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+)
+
+// boltStore 默认的存储后端 数据保存在idmap.db
+// bolt会独占文件锁 运行期间其他进程无法打开
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(BucketName))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) StoreID(id string) (int64, error) {
+	var newRow int64
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		newRow, err = storeIDInBucket(tx.Bucket([]byte(BucketName)), id)
+		return err
+	})
+	return newRow, err
+}
+
+func (s *boltStore) RetrieveRowByID(rowid string) (string, error) {
+	var id string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(BucketName))
+
+		// 根据行号检索ID
+		idBytes := b.Get([]byte("row-" + rowid))
+		if idBytes == nil {
+			return ErrKeyNotFound
+		}
+		id = string(idBytes)
+
+		return nil
+	})
+	return id, err
+}
+
+func (s *boltStore) WriteConfig(sectionName, keyName, value string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(ConfigBucket))
+		if err != nil {
+			mylog.Printf("Error creating or accessing bucket: %v", err)
+			return fmt.Errorf("failed to access or create bucket %s: %w", ConfigBucket, err)
+		}
+
+		key := joinSectionAndKey(sectionName, keyName)
+		err = b.Put(key, []byte(value))
+		if err != nil {
+			mylog.Printf("Error putting data into bucket with key %s: %v", key, err)
+			return fmt.Errorf("failed to put data into bucket with key %s: %w", key, err)
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) ReadConfig(sectionName, keyName string) (string, error) {
+	var result string
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(ConfigBucket))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+
+		key := joinSectionAndKey(sectionName, keyName)
+		v := b.Get(key)
+		if v == nil {
+			return fmt.Errorf("key '%s' in section '%s' does not exist", keyName, sectionName)
+		}
+
+		result = string(v)
+		return nil
+	})
+	return result, err
+}
+
+func (s *boltStore) Get(bucket string, key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		// bolt的值只在事务内有效 需要复制
+		if v := b.Get(key); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s *boltStore) ForEach(bucket string, fn func(key, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(fn)
+	})
+}
+
+func (s *boltStore) Apply(entries []Entry) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		for _, e := range entries {
+			b, err := tx.CreateBucketIfNotExists([]byte(e.Bucket))
+			if err != nil {
+				return err
+			}
+			if e.Value == nil {
+				err = b.Delete(e.Key)
+			} else {
+				err = b.Put(e.Key, e.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
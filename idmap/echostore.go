@@ -0,0 +1,40 @@
+package idmap
+
+import (
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/echo"
+	"github.com/hoshinonyaruko/gensokyo/metrics"
+)
+
+// echo映射使用的bucket前缀,与ids和config共用idmap的存储后端
+const echoBucketPrefix = "echo_"
+
+// EchoStore 基于idmap存储后端的echo持久化实现
+type EchoStore struct{}
+
+// 确保EchoStore实现了echo.Store接口
+var _ echo.Store = EchoStore{}
+
+// NewEchoStore 需要在InitializeDB之后调用
+func NewEchoStore() EchoStore {
+	return EchoStore{}
+}
+
+// Apply 在一个事务中执行一组写入和删除
+func (EchoStore) Apply(ops []echo.StoreOp) error {
+	defer metrics.ObserveIdmapTx("echo_apply", time.Now())
+	entries := make([]Entry, 0, len(ops))
+	for _, op := range ops {
+		entries = append(entries, Entry{Bucket: echoBucketPrefix + op.Bucket, Key: []byte(op.Key), Value: op.Value})
+	}
+	return store.Apply(entries)
+}
+
+// ForEach 遍历某个分类下的所有数据 分类不存在时直接返回
+func (EchoStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	defer metrics.ObserveIdmapTx("echo_load", time.Now())
+	return store.ForEach(echoBucketPrefix+bucket, func(k, v []byte) error {
+		return fn(string(k), v)
+	})
+}
@@ -0,0 +1,130 @@
+package idmap
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/hoshinonyaruko/gensokyo/lotus"
+)
+
+// lotus模式下缓存id与行号的对应关系 映射分配后不会再改变,命中时不需要请求服务端
+// 超过上限时整体清空,避免长期运行占用过多内存
+const lotusCacheLimit = 100000
+
+var lotusCache = struct {
+	sync.RWMutex
+	idToRow map[string]int64
+	rowToID map[string]string
+}{
+	idToRow: make(map[string]int64),
+	rowToID: make(map[string]string),
+}
+
+func lotusCacheGetRow(id string) (int64, bool) {
+	lotusCache.RLock()
+	defer lotusCache.RUnlock()
+	row, ok := lotusCache.idToRow[id]
+	return row, ok
+}
+
+func lotusCacheGetID(rowid string) (string, bool) {
+	lotusCache.RLock()
+	defer lotusCache.RUnlock()
+	id, ok := lotusCache.rowToID[rowid]
+	return id, ok
+}
+
+func lotusCachePut(id string, row int64) {
+	lotusCache.Lock()
+	defer lotusCache.Unlock()
+	if len(lotusCache.idToRow) >= lotusCacheLimit {
+		lotusCache.idToRow = make(map[string]int64)
+		lotusCache.rowToID = make(map[string]string)
+	}
+	lotusCache.idToRow[id] = row
+	lotusCache.rowToID[strconv.FormatInt(row, 10)] = id
+}
+
+// 将服务端返回的单个错误转换为error 不存在时返回ErrKeyNotFound
+func lotusResultError(r lotus.IdmapResult) error {
+	if r.Error == "" {
+		return nil
+	}
+	if r.Code == lotus.CodeNotFound {
+		return ErrKeyNotFound
+	}
+	return errors.New(r.Error)
+}
+
+// 执行单个lotus操作
+func callLotus(op lotus.IdmapOp) (lotus.IdmapResult, error) {
+	results, err := lotus.Idmap([]lotus.IdmapOp{op})
+	if err != nil {
+		return lotus.IdmapResult{}, err
+	}
+	return results[0], lotusResultError(results[0])
+}
+
+// 批量转换 只请求缓存中没有的id
+func storeIDsLotus(ids []string) ([]int64, error) {
+	rows := make([]int64, len(ids))
+	var ops []lotus.IdmapOp
+	pending := make(map[string][]int) // id -> 在ids中的位置
+	for i, id := range ids {
+		if row, ok := lotusCacheGetRow(id); ok {
+			rows[i] = row
+			continue
+		}
+		if _, ok := pending[id]; !ok {
+			ops = append(ops, lotus.IdmapOp{Op: lotus.OpStoreID, ID: id})
+		}
+		pending[id] = append(pending[id], i)
+	}
+	if len(ops) == 0 {
+		return rows, nil
+	}
+
+	results, err := lotus.Idmap(ops)
+	if err != nil {
+		return nil, err
+	}
+	for i, r := range results {
+		if err := lotusResultError(r); err != nil {
+			return nil, err
+		}
+		id := ops[i].ID
+		lotusCachePut(id, r.Row)
+		for _, index := range pending[id] {
+			rows[index] = r.Row
+		}
+	}
+	return rows, nil
+}
+
+func retrieveRowByIDLotus(rowid string) (string, error) {
+	if id, ok := lotusCacheGetID(rowid); ok {
+		return id, nil
+	}
+	result, err := callLotus(lotus.IdmapOp{Op: lotus.OpRetrieveID, Row: rowid})
+	if err != nil {
+		return "", err
+	}
+	row, convErr := strconv.ParseInt(rowid, 10, 64)
+	if convErr == nil {
+		lotusCachePut(result.ID, row)
+	}
+	return result.ID, nil
+}
+
+func lookupIDLotus(id string) (int64, error) {
+	if row, ok := lotusCacheGetRow(id); ok {
+		return row, nil
+	}
+	result, err := callLotus(lotus.IdmapOp{Op: lotus.OpLookupID, ID: id})
+	if err != nil {
+		return 0, err
+	}
+	lotusCachePut(id, result.Row)
+	return result.Row, nil
+}
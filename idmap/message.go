@@ -0,0 +1,96 @@
+package idmap
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/metrics"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+)
+
+// 消息记录使用的bucket 以int的message_id为key
+const MessageBucket = "messages"
+
+// 消息记录保留时间 超过后在启动时清理
+const messageRecordExpire = 7 * 24 * time.Hour
+
+// MessageRecord 收到或发出的一条消息 用于get_msg 撤回和引用
+type MessageRecord struct {
+	MessageID   int64  `json:"message_id"`         // 经过idmap转换的message_id
+	RealID      string `json:"real_id"`            // 开放平台的消息id
+	MessageType string `json:"message_type"`       // 上报给应用端的message_type group private guild
+	Scene       string `json:"scene"`              // 消息所在的场景 group group_private guild guild_private 与echo中的类型一致
+	GroupID     int64  `json:"group_id,omitempty"` // 经过idmap转换的群号或子频道号 私聊为0
+	UserID      int64  `json:"user_id"`            // 经过idmap转换的发送者 bot发出的消息为self_id
+	Nickname    string `json:"nickname,omitempty"`
+	Target      string `json:"target"`             // 消息所在的真实群openid 用户openid或子频道id
+	GuildID     string `json:"guild_id,omitempty"` // 频道和频道私信所在的真实guild_id
+	Content     string `json:"content"`            // cq码格式的消息内容
+	Time        int64  `json:"time"`
+	Self        bool   `json:"self,omitempty"`       // 是否为bot发出的消息
+	Deleted     bool   `json:"deleted,omitempty"`    // 消息已被撤回
+	DeletedBy   int64  `json:"deleted_by,omitempty"` // 经过idmap转换的撤回操作者
+}
+
+// StoreMessage 保存消息记录 相同message_id会被覆盖
+func StoreMessage(record MessageRecord) error {
+	defer metrics.ObserveIdmapTx("store_message", time.Now())
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	key := []byte(strconv.FormatInt(record.MessageID, 10))
+	return store.Apply([]Entry{{Bucket: MessageBucket, Key: key, Value: data}})
+}
+
+// GetMessage 根据message_id取出消息记录 不存在时返回ErrKeyNotFound
+func GetMessage(messageID int64) (MessageRecord, error) {
+	defer metrics.ObserveIdmapTx("get_message", time.Now())
+	var record MessageRecord
+	data, err := store.Get(MessageBucket, []byte(strconv.FormatInt(messageID, 10)))
+	if err != nil {
+		return record, err
+	}
+	if data == nil {
+		return record, ErrKeyNotFound
+	}
+	err = json.Unmarshal(data, &record)
+	return record, err
+}
+
+// MarkMessageDeleted 将消息记录标记为已撤回 返回更新后的记录 不存在时返回ErrKeyNotFound
+func MarkMessageDeleted(messageID int64, operatorID int64) (MessageRecord, error) {
+	record, err := GetMessage(messageID)
+	if err != nil {
+		return record, err
+	}
+	record.Deleted = true
+	record.DeletedBy = operatorID
+	return record, StoreMessage(record)
+}
+
+// PruneMessages 清理过期的消息记录
+func PruneMessages() {
+	deadline := time.Now().Add(-messageRecordExpire).Unix()
+	var expired []Entry
+	err := store.ForEach(MessageBucket, func(key, value []byte) error {
+		var record MessageRecord
+		if json.Unmarshal(value, &record) != nil || record.Time < deadline {
+			expired = append(expired, Entry{Bucket: MessageBucket, Key: append([]byte(nil), key...)})
+		}
+		return nil
+	})
+	if err != nil {
+		mylog.Printf("清理消息记录失败: %v", err)
+		return
+	}
+	if len(expired) == 0 {
+		return
+	}
+	if err := store.Apply(expired); err != nil {
+		mylog.Printf("清理消息记录失败: %v", err)
+		return
+	}
+	mylog.Printf("清理了%d条过期的消息记录", len(expired))
+}
@@ -0,0 +1,85 @@
+package idmap
+
+import (
+	"fmt"
+
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+)
+
+// 每个事务写入的条数
+const migrateBatchSize = 1000
+
+// MigrateBackend 把ids config和消息记录三个bucket从from后端复制到to后端 用于切换idmap_backend
+// 需要在gensokyo未运行时执行 目标后端中已有数据时拒绝迁移,避免两边的行号计数器混在一起
+func MigrateBackend(from, to string) error {
+	if from == to {
+		return fmt.Errorf("source and target backend are the same: %s", from)
+	}
+	src, err := openStore(from)
+	if err != nil {
+		return fmt.Errorf("failed to open source backend %s: %w", from, err)
+	}
+	defer src.Close()
+	dst, err := openStore(to)
+	if err != nil {
+		return fmt.Errorf("failed to open target backend %s: %w", to, err)
+	}
+	defer dst.Close()
+
+	return Migrate(src, dst)
+}
+
+// Migrate 把src中的ids config和消息记录复制到dst 键值原样复制,行号和计数器保持不变
+func Migrate(src, dst Store) error {
+	for _, bucket := range []string{BucketName, ConfigBucket, MessageBucket} {
+		if err := ensureEmpty(dst, bucket); err != nil {
+			return err
+		}
+	}
+	for _, bucket := range []string{BucketName, ConfigBucket, MessageBucket} {
+		count, err := copyBucket(src, dst, bucket)
+		if err != nil {
+			return fmt.Errorf("failed to migrate bucket %s: %w", bucket, err)
+		}
+		mylog.Printf("idmap迁移: %s 复制了%d条数据", bucket, count)
+	}
+	return nil
+}
+
+func ensureEmpty(s Store, bucket string) error {
+	return s.ForEach(bucket, func(key, value []byte) error {
+		return fmt.Errorf("target bucket %s is not empty", bucket)
+	})
+}
+
+func copyBucket(src, dst Store, bucket string) (int, error) {
+	var count int
+	batch := make([]Entry, 0, migrateBatchSize)
+	err := src.ForEach(bucket, func(key, value []byte) error {
+		// bolt的键值只在事务内有效 需要复制
+		batch = append(batch, Entry{
+			Bucket: bucket,
+			Key:    append([]byte(nil), key...),
+			Value:  append([]byte{}, value...),
+		})
+		if len(batch) < migrateBatchSize {
+			return nil
+		}
+		if err := dst.Apply(batch); err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if len(batch) > 0 {
+		if err := dst.Apply(batch); err != nil {
+			return count, err
+		}
+		count += len(batch)
+	}
+	return count, nil
+}
@@ -0,0 +1,107 @@
+package idmap
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// 在临时目录中打开bolt和sqlite后端
+func openTestStores(t *testing.T) (*boltStore, *sqliteStore) {
+	t.Helper()
+	dir := t.TempDir()
+	src, err := openBoltStore(filepath.Join(dir, "idmap.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { src.Close() })
+	dst, err := openSqliteStore(filepath.Join(dir, "idmap.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dst.Close() })
+	return src, dst
+}
+
+func TestMigrateBoltToSqlite(t *testing.T) {
+	src, dst := openTestStores(t)
+
+	ids := []string{"C1A2B3", "E5F6G7", "1234567890"}
+	rows := make(map[string]int64)
+	for _, id := range ids {
+		row, err := src.StoreID(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows[id] = row
+	}
+	configs := []struct{ section, key, value string }{
+		{"1", "guild_id", "100"},
+		{"2", "type", "guild"},
+		{"C1A2B3", "channel_name", "频道"},
+	}
+	for _, c := range configs {
+		if err := src.WriteConfig(c.section, c.key, c.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.Apply([]Entry{{Bucket: MessageBucket, Key: []byte("42"), Value: []byte(`{"message_id":"abc"}`)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Migrate(src, dst); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	for id, row := range rows {
+		got, err := dst.RetrieveRowByID(fmt.Sprint(row))
+		if err != nil || got != id {
+			t.Errorf("RetrieveRowByID(%d) = %q, %v, want %q", row, got, err, id)
+		}
+		// 已有的id保持原来的行号
+		if again, err := dst.StoreID(id); err != nil || again != row {
+			t.Errorf("StoreID(%q) = %d, %v, want %d", id, again, err, row)
+		}
+	}
+	for _, c := range configs {
+		if got, err := dst.ReadConfig(c.section, c.key); err != nil || got != c.value {
+			t.Errorf("ReadConfig(%s, %s) = %q, %v, want %q", c.section, c.key, got, err, c.value)
+		}
+	}
+	if got, err := dst.Get(MessageBucket, []byte("42")); err != nil || !bytes.Equal(got, []byte(`{"message_id":"abc"}`)) {
+		t.Errorf("message record = %s, %v", got, err)
+	}
+
+	// 计数器一并迁移 新的id继续递增
+	next, err := dst.StoreID("new-id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(ids) + 1); next != want {
+		t.Errorf("next row = %d, want %d", next, want)
+	}
+}
+
+func TestMigrateRefusesNonEmptyTarget(t *testing.T) {
+	src, dst := openTestStores(t)
+	if _, err := src.StoreID("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.StoreID("b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(src, dst); err == nil {
+		t.Fatal("expected error when target is not empty")
+	}
+	// 目标中原有的数据不变
+	if got, err := dst.RetrieveRowByID("1"); err != nil || got != "b" {
+		t.Errorf("target row 1 = %q, %v, want b", got, err)
+	}
+}
+
+func TestMigrateBackendSameBackend(t *testing.T) {
+	if err := MigrateBackend("bolt", "bolt"); err == nil {
+		t.Fatal("expected error for same backend")
+	}
+}
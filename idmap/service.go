@@ -4,18 +4,16 @@ import (
 	"crypto/md5"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
-	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/lotus"
+	"github.com/hoshinonyaruko/gensokyo/metrics"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
 )
 
@@ -26,26 +24,23 @@ const (
 	CounterKey   = "currentRow"
 )
 
-var db *bolt.DB
-
 var ErrKeyNotFound = errors.New("key not found")
 
+// InitializeDB 根据配置打开idmap的存储后端
 func InitializeDB() {
 	var err error
-	db, err = bolt.Open(DBName, 0600, nil)
+	backend := config.GetIdmapBackend()
+	store, err = openStore(backend)
 	if err != nil {
 		log.Fatalf("Error opening DB: %v", err)
 	}
-
-	db.Update(func(tx *bolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists([]byte(BucketName))
-		return err
-	})
+	mylog.Printf("idmap使用%s存储后端", backend)
 }
 
 func CloseDB() {
-	db.Close()
+	store.Close()
 }
+
 func generateRowID(id string, length int) (int64, error) {
 	// 计算MD5哈希值
 	hasher := md5.New()
@@ -81,165 +76,74 @@ func generateRowID(id string, length int) (int64, error) {
 
 // 根据a储存b
 func StoreID(id string) (int64, error) {
-	var newRow int64
-
-	err := db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BucketName))
-
-		// 检查ID是否已经存在
-		existingRowBytes := b.Get([]byte(id))
-		if existingRowBytes != nil {
-			newRow = int64(binary.BigEndian.Uint64(existingRowBytes))
-			return nil
-		}
-		//写入虚拟值
-		if !config.GetHashIDValue() {
-			// 如果ID不存在，则为它分配一个新的行号 数字递增
-			currentRowBytes := b.Get([]byte(CounterKey))
-			if currentRowBytes == nil {
-				newRow = 1
-			} else {
-				currentRow := binary.BigEndian.Uint64(currentRowBytes)
-				newRow = int64(currentRow) + 1
-			}
-		} else {
-			// 生成新的行号
-			var err error
-			newRow, err = generateRowID(id, 9)
-			if err != nil {
-				return err
-			}
-			// 检查新生成的行号是否重复
-			rowKey := fmt.Sprintf("row-%d", newRow)
-			if b.Get([]byte(rowKey)) != nil {
-				// 如果行号重复，使用10位数字生成行号
-				newRow, err = generateRowID(id, 10)
-				if err != nil {
-					return err
-				}
-				rowKey = fmt.Sprintf("row-%d", newRow)
-				// 再次检查重复性，如果还是重复，则返回错误
-				if b.Get([]byte(rowKey)) != nil {
-					return fmt.Errorf("unable to find a unique row ID")
-				}
-			}
-		}
-
-		rowBytes := make([]byte, 8)
-		binary.BigEndian.PutUint64(rowBytes, uint64(newRow))
-		//写入递增值
-		if !config.GetHashIDValue() {
-			b.Put([]byte(CounterKey), rowBytes)
-		}
-		//真实对应虚拟 用来直接判断是否存在,并快速返回
-		b.Put([]byte(id), rowBytes)
-
-		reverseKey := fmt.Sprintf("row-%d", newRow)
-		b.Put([]byte(reverseKey), []byte(id))
-
-		return nil
-	})
-
-	return newRow, err
+	defer metrics.ObserveIdmapTx("store_id", time.Now())
+	return store.StoreID(id)
 }
 
 // StoreIDv2 根据a储存b
 func StoreIDv2(id string) (int64, error) {
 	if config.GetLotusValue() {
-		// 使用网络请求方式
-		serverDir := config.GetServer_dir()
-		portValue := config.GetPortValue()
-
-		// 根据portValue确定协议
-		protocol := "http"
-		if portValue == "443" {
-			protocol = "https"
-		}
-
-		// 构建请求URL
-		url := fmt.Sprintf("%s://%s:%s/getid?type=1&id=%s", protocol, serverDir, portValue, id)
-		resp, err := http.Get(url)
+		// 使用lotus服务端的idmaps
+		rows, err := storeIDsLotus([]string{id})
 		if err != nil {
-			return 0, fmt.Errorf("failed to send request: %v", err)
+			return 0, err
 		}
-		defer resp.Body.Close()
-
-		// 解析响应
-		var response map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return 0, fmt.Errorf("failed to decode response: %v", err)
-		}
-		if resp.StatusCode != http.StatusOK {
-			return 0, fmt.Errorf("error response from server: %s", response["error"])
-		}
-
-		rowValue, ok := response["row"].(float64)
-		if !ok {
-			return 0, fmt.Errorf("invalid response format")
-		}
-
-		return int64(rowValue), nil
+		return rows[0], nil
 	}
 
 	// 如果lotus为假,就保持原来的store的方法
 	return StoreID(id)
 }
 
-// 根据b得到a
-func RetrieveRowByID(rowid string) (string, error) {
-	var id string
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(BucketName))
+// StoreIDsv2 批量根据a储存b 返回的行号与ids按顺序对应
+// lotus模式下只需要一次请求 用于一条消息同时转换群 用户 消息id
+func StoreIDsv2(ids ...string) ([]int64, error) {
+	if config.GetLotusValue() {
+		return storeIDsLotus(ids)
+	}
 
-		// 根据行号检索ID
-		idBytes := b.Get([]byte("row-" + rowid))
-		if idBytes == nil {
-			return ErrKeyNotFound
+	rows := make([]int64, len(ids))
+	for i, id := range ids {
+		row, err := StoreID(id)
+		if err != nil {
+			return nil, err
 		}
-		id = string(idBytes)
-
-		return nil
-	})
-
-	return id, err
+		rows[i] = row
+	}
+	return rows, nil
 }
 
-// RetrieveRowByIDv2 根据b得到a
-func RetrieveRowByIDv2(rowid string) (string, error) {
-	// 根据portValue确定协议
-	protocol := "http"
-	portValue := config.GetPortValue()
-	if portValue == "443" {
-		protocol = "https"
+// LookupID 根据a取得已分配的行号 不会分配新的行号 不存在时返回ErrKeyNotFound
+func LookupID(id string) (int64, error) {
+	defer metrics.ObserveIdmapTx("lookup_id", time.Now())
+	value, err := store.Get(BucketName, []byte(id))
+	if err != nil {
+		return 0, err
+	}
+	if len(value) != 8 {
+		return 0, ErrKeyNotFound
 	}
+	return int64(binary.BigEndian.Uint64(value)), nil
+}
 
+// LookupIDv2 根据a取得已分配的行号 用于查询用户传入的id 避免为不存在的id写入新的映射
+func LookupIDv2(id string) (int64, error) {
 	if config.GetLotusValue() {
-		// 使用网络请求方式
-		serverDir := config.GetServer_dir()
-
-		// 构建请求URL
-		url := fmt.Sprintf("%s://%s:%s/getid?type=2&id=%s", protocol, serverDir, portValue, rowid)
-		resp, err := http.Get(url)
-		if err != nil {
-			return "", fmt.Errorf("failed to send request: %v", err)
-		}
-		defer resp.Body.Close()
-
-		// 解析响应
-		var response map[string]interface{}
-		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-			return "", fmt.Errorf("failed to decode response: %v", err)
-		}
-		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("error response from server: %s", response["error"])
-		}
+		return lookupIDLotus(id)
+	}
+	return LookupID(id)
+}
 
-		idValue, ok := response["id"].(string)
-		if !ok {
-			return "", fmt.Errorf("invalid response format")
-		}
+// 根据b得到a
+func RetrieveRowByID(rowid string) (string, error) {
+	defer metrics.ObserveIdmapTx("retrieve_id", time.Now())
+	return store.RetrieveRowByID(rowid)
+}
 
-		return idValue, nil
+// RetrieveRowByIDv2 根据b得到a
+func RetrieveRowByIDv2(rowid string) (string, error) {
+	if config.GetLotusValue() {
+		return retrieveRowByIDLotus(rowid)
 	}
 
 	// 如果lotus为假,就保持原来的RetrieveRowByIDv2的方法
@@ -248,58 +152,15 @@ func RetrieveRowByIDv2(rowid string) (string, error) {
 
 // 根据a 以b为类别 储存c
 func WriteConfig(sectionName, keyName, value string) error {
-	return db.Update(func(tx *bolt.Tx) error {
-		b, err := tx.CreateBucketIfNotExists([]byte(ConfigBucket))
-		if err != nil {
-			mylog.Printf("Error creating or accessing bucket: %v", err)
-			return fmt.Errorf("failed to access or create bucket %s: %w", ConfigBucket, err)
-		}
-
-		key := joinSectionAndKey(sectionName, keyName)
-		err = b.Put(key, []byte(value))
-		if err != nil {
-			mylog.Printf("Error putting data into bucket with key %s: %v", key, err)
-			return fmt.Errorf("failed to put data into bucket with key %s: %w", key, err)
-		}
-		//mylog.Printf("Data saved successfully with key %s,value %s", key, value)
-		return nil
-	})
+	defer metrics.ObserveIdmapTx("write_config", time.Now())
+	return store.WriteConfig(sectionName, keyName, value)
 }
 
 // WriteConfigv2 根据a以b为类别储存c
 func WriteConfigv2(sectionName, keyName, value string) error {
 	if config.GetLotusValue() {
-		// 使用网络请求方式
-		serverDir := config.GetServer_dir()
-		portValue := config.GetPortValue()
-
-		// 根据portValue确定协议
-		protocol := "http"
-		if portValue == "443" {
-			protocol = "https"
-		}
-
-		// 构建请求URL和参数
-		baseURL := fmt.Sprintf("%s://%s:%s/getid", protocol, serverDir, portValue)
-
-		params := url.Values{}
-		params.Add("type", "3")
-		params.Add("id", sectionName)
-		params.Add("subtype", keyName)
-		params.Add("value", value)
-		url := baseURL + "?" + params.Encode()
-
-		resp, err := http.Get(url)
-		if err != nil {
-			return fmt.Errorf("failed to send request: %v", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return fmt.Errorf("error response from server: %s", resp.Status)
-		}
-
-		return nil
+		_, err := callLotus(lotus.IdmapOp{Op: lotus.OpWriteConfig, Section: sectionName, Key: keyName, Value: value})
+		return err
 	}
 
 	// 如果lotus为假,则使用原始方法在本地写入配置
@@ -308,72 +169,18 @@ func WriteConfigv2(sectionName, keyName, value string) error {
 
 // 根据a和b取出c
 func ReadConfig(sectionName, keyName string) (string, error) {
-	var result string
-	err := db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(ConfigBucket))
-		if b == nil {
-			return fmt.Errorf("bucket not found")
-		}
-
-		key := joinSectionAndKey(sectionName, keyName)
-		v := b.Get(key)
-		if v == nil {
-			return fmt.Errorf("key '%s' in section '%s' does not exist", keyName, sectionName)
-		}
-
-		result = string(v)
-		return nil
-	})
-
-	return result, err
+	defer metrics.ObserveIdmapTx("read_config", time.Now())
+	return store.ReadConfig(sectionName, keyName)
 }
 
 // ReadConfigv2 根据a和b取出c
 func ReadConfigv2(sectionName, keyName string) (string, error) {
-	// 根据portValue确定协议
-	protocol := "http"
-	portValue := config.GetPortValue()
-	if portValue == "443" {
-		protocol = "https"
-	}
-
 	if config.GetLotusValue() {
-		// 使用网络请求方式
-		serverDir := config.GetServer_dir()
-
-		// 构建请求URL和参数
-		baseURL := fmt.Sprintf("%s://%s:%s/getid", protocol, serverDir, portValue)
-		params := url.Values{}
-		params.Add("type", "4")
-		params.Add("id", sectionName)
-		params.Add("subtype", keyName)
-		url := baseURL + "?" + params.Encode()
-
-		resp, err := http.Get(url)
+		result, err := callLotus(lotus.IdmapOp{Op: lotus.OpReadConfig, Section: sectionName, Key: keyName})
 		if err != nil {
-			return "", fmt.Errorf("failed to send request: %v", err)
+			return "", err
 		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			return "", fmt.Errorf("error response from server: %s", resp.Status)
-		}
-
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return "", fmt.Errorf("failed to read response body: %v", err)
-		}
-
-		var responseMap map[string]interface{}
-		if err := json.Unmarshal(body, &responseMap); err != nil {
-			return "", fmt.Errorf("failed to unmarshal response: %v", err)
-		}
-
-		if value, ok := responseMap["value"]; ok {
-			return fmt.Sprintf("%v", value), nil
-		}
-
-		return "", fmt.Errorf("value not found in response")
+		return result.Value, nil
 	}
 
 	// 如果lotus为假,则使用原始方法在本地读取配置
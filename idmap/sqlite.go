@@ -0,0 +1,193 @@
+package idmap
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	_ "modernc.org/sqlite" // 纯go实现 不需要cgo
+)
+
+// sqliteStore 把bolt的bucket映射为kv表中的一列 键值格式与bolt完全一致
+// 使用WAL模式 运行期间其他进程也可以读写同一个数据库
+type sqliteStore struct {
+	db *sql.DB
+}
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS kv (
+	bucket TEXT NOT NULL,
+	key    TEXT NOT NULL,
+	value  BLOB NOT NULL,
+	PRIMARY KEY (bucket, key)
+) WITHOUT ROWID`
+
+func openSqliteStore(path string) (*sqliteStore, error) {
+	// 写事务使用BEGIN IMMEDIATE 避免与其他进程并发写入时读锁升级失败
+	dsn := "file:" + path + "?_txlock=immediate&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create sqlite schema: %w", err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// sqliteBucket 在事务中模拟bolt的bucket 记录第一个出现的错误
+type sqliteBucket struct {
+	tx     *sql.Tx
+	bucket string
+	err    error
+}
+
+func (b *sqliteBucket) Get(key []byte) []byte {
+	if b.err != nil {
+		return nil
+	}
+	var value []byte
+	err := b.tx.QueryRow("SELECT value FROM kv WHERE bucket = ? AND key = ?", b.bucket, string(key)).Scan(&value)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			b.err = err
+		}
+		return nil
+	}
+	return nonNil(value)
+}
+
+func (b *sqliteBucket) Put(key, value []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	_, err := b.tx.Exec("INSERT OR REPLACE INTO kv (bucket, key, value) VALUES (?, ?, ?)", b.bucket, string(key), value)
+	return err
+}
+
+func (b *sqliteBucket) Delete(key []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	_, err := b.tx.Exec("DELETE FROM kv WHERE bucket = ? AND key = ?", b.bucket, string(key))
+	return err
+}
+
+// 在一个事务中执行fn 出错时回滚
+func (s *sqliteStore) update(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) Get(bucket string, key []byte) ([]byte, error) {
+	var value []byte
+	err := s.db.QueryRow("SELECT value FROM kv WHERE bucket = ? AND key = ?", bucket, string(key)).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nonNil(value), nil
+}
+
+// 空值扫描出来是nil 需要与不存在区分开
+func nonNil(value []byte) []byte {
+	if value == nil {
+		return []byte{}
+	}
+	return value
+}
+
+func (s *sqliteStore) StoreID(id string) (int64, error) {
+	var newRow int64
+	err := s.update(func(tx *sql.Tx) error {
+		b := &sqliteBucket{tx: tx, bucket: BucketName}
+		var err error
+		newRow, err = storeIDInBucket(b, id)
+		if b.err != nil {
+			return b.err
+		}
+		return err
+	})
+	return newRow, err
+}
+
+func (s *sqliteStore) RetrieveRowByID(rowid string) (string, error) {
+	idBytes, err := s.Get(BucketName, []byte("row-"+rowid))
+	if err != nil {
+		return "", err
+	}
+	if idBytes == nil {
+		return "", ErrKeyNotFound
+	}
+	return string(idBytes), nil
+}
+
+func (s *sqliteStore) WriteConfig(sectionName, keyName, value string) error {
+	key := joinSectionAndKey(sectionName, keyName)
+	_, err := s.db.Exec("INSERT OR REPLACE INTO kv (bucket, key, value) VALUES (?, ?, ?)", ConfigBucket, string(key), []byte(value))
+	if err != nil {
+		return fmt.Errorf("failed to put data into bucket with key %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *sqliteStore) ReadConfig(sectionName, keyName string) (string, error) {
+	v, err := s.Get(ConfigBucket, joinSectionAndKey(sectionName, keyName))
+	if err != nil {
+		return "", err
+	}
+	if v == nil {
+		return "", fmt.Errorf("key '%s' in section '%s' does not exist", keyName, sectionName)
+	}
+	return string(v), nil
+}
+
+func (s *sqliteStore) ForEach(bucket string, fn func(key, value []byte) error) error {
+	rows, err := s.db.Query("SELECT key, value FROM kv WHERE bucket = ? ORDER BY key", bucket)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		if err := fn([]byte(key), nonNil(value)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) Apply(entries []Entry) error {
+	return s.update(func(tx *sql.Tx) error {
+		for _, e := range entries {
+			b := &sqliteBucket{tx: tx, bucket: e.Bucket}
+			var err error
+			if e.Value == nil {
+				err = b.Delete(e.Key)
+			} else {
+				err = b.Put(e.Key, e.Value)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
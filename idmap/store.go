@@ -0,0 +1,119 @@
+package idmap
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/hoshinonyaruko/gensokyo/config"
+)
+
+// Entry 一条原始键值 Value为nil时表示删除
+type Entry struct {
+	Bucket string
+	Key    []byte
+	Value  []byte
+}
+
+// Store idmap的存储后端 默认为bolt 可在配置中切换为sqlite
+type Store interface {
+	// 根据a储存b 返回a对应的行号
+	StoreID(id string) (int64, error)
+	// 根据行号得到a 不存在时返回ErrKeyNotFound
+	RetrieveRowByID(rowid string) (string, error)
+	// 根据a 以b为类别 储存c
+	WriteConfig(sectionName, keyName, value string) error
+	// 根据a和b取出c
+	ReadConfig(sectionName, keyName string) (string, error)
+	// Get 读取原始值 不存在时返回nil
+	Get(bucket string, key []byte) ([]byte, error)
+	// ForEach 按key顺序遍历bucket中的原始键值 bucket不存在时直接返回
+	// key和value只在fn中有效 需要保留时自行复制
+	ForEach(bucket string, fn func(key, value []byte) error) error
+	// Apply 在一个事务中写入或删除一组原始键值 bucket不存在时创建
+	Apply(entries []Entry) error
+	Close() error
+}
+
+// 当前使用的存储后端
+var store Store
+
+// 根据名称打开存储后端
+func openStore(backend string) (Store, error) {
+	switch backend {
+	case "", "bolt":
+		return openBoltStore(DBName)
+	case "sqlite":
+		return openSqliteStore(config.GetIdmapSqlitePath())
+	default:
+		return nil, fmt.Errorf("unknown idmap backend: %s", backend)
+	}
+}
+
+// kvBucket bolt的Bucket和sqlite的事务都满足 用来共用StoreID的分配逻辑
+type kvBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+}
+
+// 在一个写事务中为id分配行号 已存在时直接返回
+func storeIDInBucket(b kvBucket, id string) (int64, error) {
+	var newRow int64
+
+	// 检查ID是否已经存在
+	existingRowBytes := b.Get([]byte(id))
+	if existingRowBytes != nil {
+		return int64(binary.BigEndian.Uint64(existingRowBytes)), nil
+	}
+	//写入虚拟值
+	if !config.GetHashIDValue() {
+		// 如果ID不存在，则为它分配一个新的行号 数字递增
+		currentRowBytes := b.Get([]byte(CounterKey))
+		if currentRowBytes == nil {
+			newRow = 1
+		} else {
+			currentRow := binary.BigEndian.Uint64(currentRowBytes)
+			newRow = int64(currentRow) + 1
+		}
+	} else {
+		// 生成新的行号
+		var err error
+		newRow, err = generateRowID(id, 9)
+		if err != nil {
+			return 0, err
+		}
+		// 检查新生成的行号是否重复
+		rowKey := fmt.Sprintf("row-%d", newRow)
+		if b.Get([]byte(rowKey)) != nil {
+			// 如果行号重复，使用10位数字生成行号
+			newRow, err = generateRowID(id, 10)
+			if err != nil {
+				return 0, err
+			}
+			rowKey = fmt.Sprintf("row-%d", newRow)
+			// 再次检查重复性，如果还是重复，则返回错误
+			if b.Get([]byte(rowKey)) != nil {
+				return 0, fmt.Errorf("unable to find a unique row ID")
+			}
+		}
+	}
+
+	rowBytes := make([]byte, 8)
+	binary.BigEndian.PutUint64(rowBytes, uint64(newRow))
+	//写入递增值
+	if !config.GetHashIDValue() {
+		if err := b.Put([]byte(CounterKey), rowBytes); err != nil {
+			return 0, err
+		}
+	}
+	//真实对应虚拟 用来直接判断是否存在,并快速返回
+	if err := b.Put([]byte(id), rowBytes); err != nil {
+		return 0, err
+	}
+
+	reverseKey := fmt.Sprintf("row-%d", newRow)
+	if err := b.Put([]byte(reverseKey), []byte(id)); err != nil {
+		return 0, err
+	}
+
+	return newRow, nil
+}
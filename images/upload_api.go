@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/lotus"
 )
 
 // 将base64图片通过lotus转换成url
@@ -23,14 +24,7 @@ func UploadBase64ImageToServer(base64Image string) (string, error) {
 	}
 
 	if config.GetLotusValue() {
-		serverDir := config.GetServer_dir()
-		url := fmt.Sprintf("%s://%s:%s/uploadpic", protocol, serverDir, serverPort)
-
-		resp, err := postImageToServer(base64Image, url)
-		if err != nil {
-			return "", err
-		}
-		return resp, nil
+		return lotus.UploadImage(base64Image)
 	}
 
 	serverDir := config.GetServer_dir()
@@ -52,11 +46,41 @@ func UploadBase64ImageToServer(base64Image string) (string, error) {
 	return "", errors.New("local server uses a private address; image upload failed")
 }
 
+// 将base64语音等媒体文件上传到图床 mediaType决定保存的文件类型
+// fileName为文件的原文件名 file类型会保留文件名和后缀 其他类型传空即可
+func UploadBase64MediaToServer(mediaType, base64Media, fileName string) (string, error) {
+	if config.GetLotusValue() {
+		return lotus.UploadMedia(mediaType, base64Media, fileName)
+	}
+
+	// 与图片相同 本地始终使用HTTP 443端口时使用444端口
+	serverPort := config.GetPortValue()
+	if serverPort == "443" {
+		serverPort = "444"
+	}
+
+	if isPublicAddress(config.GetServer_dir()) {
+		targetURL := fmt.Sprintf("http://127.0.0.1:%s/uploadmedia", serverPort)
+		data := url.Values{}
+		data.Set("mediaType", mediaType)
+		data.Set("base64Media", base64Media)
+		if fileName != "" {
+			data.Set("fileName", fileName)
+		}
+		return postFormToServer(data, targetURL)
+	}
+	return "", errors.New("local server uses a private address; media upload failed")
+}
+
 // 请求图床api(图床就是lolus为false的gensokyo)
 func postImageToServer(base64Image, targetURL string) (string, error) {
 	data := url.Values{}
 	data.Set("base64Image", base64Image) // 修改字段名以与服务器匹配
+	return postFormToServer(data, targetURL)
+}
 
+// 提交表单到图床 返回响应中的url
+func postFormToServer(data url.Values, targetURL string) (string, error) {
 	resp, err := http.PostForm(targetURL, data)
 	if err != nil {
 		return "", fmt.Errorf("failed to send request: %v", err)
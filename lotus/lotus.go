@@ -0,0 +1,184 @@
+// lotus协议 lotus为true的gensokyo通过它使用另一个gensokyo的idmaps和图床
+// 请求和响应均为json 使用Authorization: Bearer <lotus_secret>鉴权
+package lotus
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/config"
+)
+
+const (
+	// IdmapPath 批量idmap操作
+	IdmapPath = "/lotus/idmap"
+	// MediaPath 上传base64图片或其他媒体 返回图床url
+	MediaPath = "/lotus/media"
+	// MaxBatchSize 单次请求最多包含的idmap操作数
+	MaxBatchSize = 100
+)
+
+// idmap操作类型
+const (
+	OpStoreID     = "store_id"
+	OpLookupID    = "lookup_id" // 只查询已有的行号 不分配
+	OpRetrieveID  = "retrieve_id"
+	OpWriteConfig = "write_config"
+	OpReadConfig  = "read_config"
+)
+
+// 错误码 用于区分不存在和其他错误
+const (
+	CodeNotFound = "not_found"
+	CodeInvalid  = "invalid"
+	CodeInternal = "internal"
+)
+
+// IdmapOp 一个idmap操作 store_id使用ID retrieve_id使用Row config使用Section Key Value
+type IdmapOp struct {
+	Op      string `json:"op"`
+	ID      string `json:"id,omitempty"`
+	Row     string `json:"row,omitempty"`
+	Section string `json:"section,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Value   string `json:"value,omitempty"`
+}
+
+// IdmapResult 与IdmapOp按顺序一一对应 出错时Error和Code不为空
+type IdmapResult struct {
+	Row   int64  `json:"row,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Value string `json:"value,omitempty"`
+	Error string `json:"error,omitempty"`
+	Code  string `json:"code,omitempty"`
+}
+
+type IdmapRequest struct {
+	Ops []IdmapOp `json:"ops"`
+}
+
+type IdmapResponse struct {
+	Results []IdmapResult `json:"results"`
+}
+
+type MediaRequest struct {
+	Base64Image string `json:"base64_image,omitempty"`
+	MediaType   string `json:"media_type,omitempty"`   // 非图片时的媒体类型 如record
+	Base64Media string `json:"base64_media,omitempty"` // media_type对应的base64数据
+	FileName    string `json:"file_name,omitempty"`    // 文件的原文件名 保存时保留文件名和后缀
+}
+
+type MediaResponse struct {
+	URL string `json:"url"`
+}
+
+// ErrorResponse 整个请求失败时返回
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+var (
+	clientOnce sync.Once
+	httpClient *http.Client
+)
+
+func getHTTPClient() *http.Client {
+	clientOnce.Do(func() {
+		httpClient = &http.Client{Timeout: time.Duration(config.GetLotusTimeout()) * time.Second}
+	})
+	return httpClient
+}
+
+// BaseURL lotus服务端地址 443端口时使用https
+func BaseURL() string {
+	protocol := "http"
+	portValue := config.GetPortValue()
+	if portValue == "443" {
+		protocol = "https"
+	}
+	return fmt.Sprintf("%s://%s:%s", protocol, config.GetServer_dir(), portValue)
+}
+
+// Post 向lotus服务端发送json请求 并将响应解析到resp
+func Post(path string, req interface{}, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, BaseURL()+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if secret := config.GetLotusSecret(); secret != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+secret)
+	}
+
+	httpResp, err := getHTTPClient().Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer httpResp.Body.Close()
+
+	data, err := io.ReadAll(httpResp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+	if httpResp.StatusCode != http.StatusOK {
+		var e ErrorResponse
+		if json.Unmarshal(data, &e) == nil && e.Error != "" {
+			return fmt.Errorf("error response from server: %s", e.Error)
+		}
+		return fmt.Errorf("error response from server: %s", httpResp.Status)
+	}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// Idmap 批量执行idmap操作 超过MaxBatchSize时自动拆分
+func Idmap(ops []IdmapOp) ([]IdmapResult, error) {
+	results := make([]IdmapResult, 0, len(ops))
+	for start := 0; start < len(ops); start += MaxBatchSize {
+		end := start + MaxBatchSize
+		if end > len(ops) {
+			end = len(ops)
+		}
+		var resp IdmapResponse
+		if err := Post(IdmapPath, IdmapRequest{Ops: ops[start:end]}, &resp); err != nil {
+			return nil, err
+		}
+		if len(resp.Results) != end-start {
+			return nil, fmt.Errorf("invalid response format: expected %d results, got %d", end-start, len(resp.Results))
+		}
+		results = append(results, resp.Results...)
+	}
+	return results, nil
+}
+
+// UploadImage 通过lotus服务端的图床上传base64图片
+func UploadImage(base64Image string) (string, error) {
+	return uploadMedia(MediaRequest{Base64Image: base64Image})
+}
+
+// UploadMedia 通过lotus服务端的图床上传语音等其他媒体 fileName可为空
+func UploadMedia(mediaType, base64Media, fileName string) (string, error) {
+	return uploadMedia(MediaRequest{MediaType: mediaType, Base64Media: base64Media, FileName: fileName})
+}
+
+func uploadMedia(req MediaRequest) (string, error) {
+	var resp MediaResponse
+	if err := Post(MediaPath, req, &resp); err != nil {
+		return "", err
+	}
+	if resp.URL == "" {
+		return "", fmt.Errorf("URL not found in response")
+	}
+	return resp.URL, nil
+}
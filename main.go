@@ -16,11 +16,16 @@ import (
 
 	"github.com/fatih/color"
 	"github.com/hoshinonyaruko/gensokyo/Processor"
+	"github.com/hoshinonyaruko/gensokyo/callapi"
 	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/echo"
 	"github.com/hoshinonyaruko/gensokyo/handlers"
 	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/lotus"
+	"github.com/hoshinonyaruko/gensokyo/metrics"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
 	"github.com/hoshinonyaruko/gensokyo/server"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 	"github.com/hoshinonyaruko/gensokyo/sys"
 	"github.com/hoshinonyaruko/gensokyo/template"
 	"github.com/hoshinonyaruko/gensokyo/url"
@@ -41,6 +46,8 @@ var p *Processor.Processors
 func main() {
 	// 定义faststart命令行标志。默认为false。
 	fastStart := flag.Bool("faststart", false, "start without initialization if set")
+	// 一次性迁移idmap数据 格式为 源后端:目标后端 如bolt:sqlite 迁移完成后退出
+	migrateIdmap := flag.String("migrate-idmap", "", "copy idmap ids and config from one backend to another, e.g. bolt:sqlite, then exit")
 
 	// 解析命令行参数到定义的标志。
 	flag.Parse()
@@ -86,6 +93,31 @@ func main() {
 	mylog.SetLogLevelByName(config.GetLogLevel())
 	log.Printf("当前日志级别: %s", config.GetLogLevel())
 
+	if *migrateIdmap != "" {
+		backends := strings.SplitN(*migrateIdmap, ":", 2)
+		if len(backends) != 2 {
+			log.Fatalf("-migrate-idmap的格式应为 源后端:目标后端 如bolt:sqlite")
+		}
+		if err := idmap.MigrateBackend(backends[0], backends[1]); err != nil {
+			log.Fatalf("idmap迁移失败: %v", err)
+		}
+		log.Printf("idmap已从%s迁移到%s,请将idmap_backend设置为%s后重新启动", backends[0], backends[1], backends[1])
+		return
+	}
+
+	//创建idmap服务器 数据库
+	idmap.InitializeDB()
+	defer idmap.CloseDB()
+
+	//清理过期的消息记录
+	go idmap.PruneMessages()
+
+	//从idmap.db恢复echo映射,重启后仍能被动回复重启前收到的消息
+	//需要在连接网关之前恢复 避免覆盖启动后收到的事件
+	if err := echo.SetStore(idmap.NewEchoStore()); err != nil {
+		log.Printf("恢复echo映射失败: %v\n", err)
+	}
+
 	sys.SetTitle(conf.Settings.Title)
 
 	var api openapi.OpenAPI
@@ -175,11 +207,6 @@ func main() {
 			// 测试群时候用api2 并且要注释掉api.me
 			//似乎正式场景都可以用apiv2(群)的方式获取ws连接,包括频道的机器人
 			//疑问: 为什么无法用apiv2的方式调用频道的getme接口,会报错
-			wsInfo, err := apiV2.WS(ctx, nil, "")
-			if err != nil {
-				log.Fatalln(err)
-			}
-
 			// 定义和初始化intent变量
 			var intent dto.Intent = 0
 
@@ -195,16 +222,29 @@ func main() {
 				intent |= websocket.RegisterHandlers(handler)
 			}
 
+			// 在线状态依赖连接的生命周期事件 无论text_intent中是否填写都注册
+			websocket.RegisterHandlers(ReadyHandler(), ResumedHandler(), ErrorNotifyHandler())
+
 			log.Printf("注册 intents: %v\n", intent)
 
-			// 启动session manager以管理websocket连接
-			// 指定需要启动的分片数为 2 的话可以手动修改 wsInfo
-			go func() {
-				wsInfo.Shards = 1
-				if err = botgo.NewSessionManager().Start(wsInfo, token, &intent); err != nil {
+			if conf.Settings.EnableWebhook {
+				// webhook模式下事件由http回调推送,上面注册的handler由回调驱动,不再连接网关
+				stats.SetWebhookMode()
+				log.Println("webhook模式已启用,不连接websocket网关,请在q.qq.com将回调地址配置为https://" + conf.Settings.Server_dir + config.GetWebhookPath())
+			} else {
+				wsInfo, err := apiV2.WS(ctx, nil, "")
+				if err != nil {
 					log.Fatalln(err)
 				}
-			}()
+				// 启动session manager以管理websocket连接
+				// 指定需要启动的分片数为 2 的话可以手动修改 wsInfo
+				go func() {
+					wsInfo.Shards = 1
+					if err = botgo.NewSessionManager().Start(wsInfo, token, &intent); err != nil {
+						log.Fatalln(err)
+					}
+				}()
+			}
 
 			// 启动多个WebSocket客户端的逻辑
 			if !allEmpty(conf.Settings.WsAddress) {
@@ -259,10 +299,6 @@ func main() {
 
 	}
 
-	//创建idmap服务器 数据库
-	idmap.InitializeDB()
-	defer idmap.CloseDB()
-
 	//图片上传 调用次数限制
 	rateLimiter := server.NewRateLimiter()
 	// 根据 lotus 的值选择端口
@@ -279,8 +315,14 @@ func main() {
 		r = gin.New()
 		r.Use(gin.Recovery()) // 添加恢复中间件，但不添加日志中间件
 	}
-	r.GET("/getid", server.GetIDHandler)
+	if !conf.Settings.Lotus && config.GetLotusSecret() == "" {
+		log.Println("提示: 未设置lotus_secret,idmaps和图床接口只允许本机访问")
+	}
+	r.GET("/getid", server.LotusAuth(), server.GetIDHandler)
 	r.POST("/uploadpic", server.UploadBase64ImageHandler(rateLimiter))
+	r.POST("/uploadmedia", server.LoopbackOnly(), server.UploadBase64MediaHandler(rateLimiter)) // 只由本机的发送流程调用
+	r.POST(lotus.IdmapPath, server.LotusAuth(), server.LotusIdmapHandler)
+	r.POST(lotus.MediaPath, server.LotusAuth(), server.LotusMediaHandler(rateLimiter))
 	r.Static("/channel_temp", "./channel_temp")
 	//正向ws
 	if conf.Settings.AppID != 12345 {
@@ -288,6 +330,25 @@ func main() {
 			r.GET("/ws", server.WsHandlerWithDependencies(api, apiV2, p))
 			log.Println("正向ws启动成功,监听0.0.0.0:" + serverPort + " 请注意设置ws_server_token,并对外放通端口...")
 		}
+		//qq开放平台的http回调(webhook)
+		if conf.Settings.EnableWebhook {
+			r.POST(config.GetWebhookPath(), server.WebhookHandler)
+			log.Println("webhook回调启动成功,监听0.0.0.0:" + serverPort + config.GetWebhookPath())
+		}
+		//http api
+		if conf.Settings.EnableHttpApi {
+			httpAPIHandler := server.HTTPAPIHandlerWithDependencies(api, apiV2)
+			for _, action := range callapi.GetActions() {
+				r.GET("/"+action, httpAPIHandler)
+				r.POST("/"+action, httpAPIHandler)
+			}
+			log.Println("http api启动成功,监听0.0.0.0:" + serverPort + "/动作名 请注意设置ws_server_token...")
+		}
+	}
+	//prometheus监控
+	if config.GetEnableMetrics() {
+		r.GET("/metrics", gin.WrapH(metrics.Handler()))
+		log.Println("prometheus监控启动成功,监听0.0.0.0:" + serverPort + "/metrics")
 	}
 	r.POST("/url", url.CreateShortURLHandler)
 	r.GET("/url/:shortURL", url.RedirectFromShortURLHandler)
@@ -364,7 +425,8 @@ func main() {
 		}
 	}
 
-	// 关闭BoltDB数据库
+	// 关闭BoltDB数据库 先写入尚未持久化的echo映射
+	echo.CloseStore()
 	url.CloseDB()
 	idmap.CloseDB()
 
@@ -387,6 +449,15 @@ func main() {
 func ReadyHandler() event.ReadyHandler {
 	return func(event *dto.WSPayload, data *dto.WSReadyData) {
 		log.Println("连接成功,ready event receive: ", data)
+		stats.SetOnline(true)
+	}
+}
+
+// ResumedHandler 感知重连后恢复session的事件
+func ResumedHandler() event.ResumedHandler {
+	return func(event *dto.WSPayload) {
+		log.Println("重连成功,resumed event receive")
+		stats.SetOnline(true)
 	}
 }
 
@@ -394,6 +465,7 @@ func ReadyHandler() event.ReadyHandler {
 func ErrorNotifyHandler() event.ErrorNotifyHandler {
 	return func(err error) {
 		log.Println("error notify receive: ", err)
+		stats.SetOnline(false)
 	}
 }
 
@@ -411,24 +483,33 @@ func ATMessageEventHandler() event.ATMessageEventHandler {
 // GuildEventHandler 处理频道事件
 func GuildEventHandler() event.GuildEventHandler {
 	return func(event *dto.WSPayload, data *dto.WSGuildData) error {
-		log.Println(data)
-		return nil
+		if p == nil {
+			mylog.Println("Processors not initialized yet; skipping GuildEvent")
+			return nil
+		}
+		return p.ProcessGuildEvent(event.Type, data)
 	}
 }
 
 // ChannelEventHandler 处理子频道事件
 func ChannelEventHandler() event.ChannelEventHandler {
 	return func(event *dto.WSPayload, data *dto.WSChannelData) error {
-		log.Println(data)
-		return nil
+		if p == nil {
+			mylog.Println("Processors not initialized yet; skipping ChannelEvent")
+			return nil
+		}
+		return p.ProcessChannelEvent(event.Type, data)
 	}
 }
 
 // MemberEventHandler 处理成员变更事件
 func MemberEventHandler() event.GuildMemberEventHandler {
 	return func(event *dto.WSPayload, data *dto.WSGuildMemberData) error {
-		log.Println(data)
-		return nil
+		if p == nil {
+			mylog.Println("Processors not initialized yet; skipping GuildMemberEvent")
+			return nil
+		}
+		return p.ProcessGuildMemberEvent(event.Type, data)
 	}
 }
 
@@ -467,6 +548,61 @@ func InteractionHandler() event.InteractionEventHandler {
 	}
 }
 
+// MessageDeleteHandler 处理私域频道消息的撤回事件
+func MessageDeleteHandler() event.MessageDeleteEventHandler {
+	return func(event *dto.WSPayload, data *dto.WSMessageDeleteData) error {
+		if p == nil {
+			mylog.Println("Processors not initialized yet; skipping MessageDeleteEvent")
+			return nil
+		}
+		return p.ProcessMessageDelete(event.Type, (*dto.MessageDelete)(data))
+	}
+}
+
+// PublicMessageDeleteHandler 处理公域频道at消息的撤回事件
+func PublicMessageDeleteHandler() event.PublicMessageDeleteEventHandler {
+	return func(event *dto.WSPayload, data *dto.WSPublicMessageDeleteData) error {
+		if p == nil {
+			mylog.Println("Processors not initialized yet; skipping PublicMessageDeleteEvent")
+			return nil
+		}
+		return p.ProcessMessageDelete(event.Type, (*dto.MessageDelete)(data))
+	}
+}
+
+// DirectMessageDeleteHandler 处理频道私信的撤回事件
+func DirectMessageDeleteHandler() event.DirectMessageDeleteEventHandler {
+	return func(event *dto.WSPayload, data *dto.WSDirectMessageDeleteData) error {
+		if p == nil {
+			mylog.Println("Processors not initialized yet; skipping DirectMessageDeleteEvent")
+			return nil
+		}
+		return p.ProcessMessageDelete(event.Type, (*dto.MessageDelete)(data))
+	}
+}
+
+// MessageReactionHandler 处理频道消息的表情表态事件
+func MessageReactionHandler() event.MessageReactionEventHandler {
+	return func(event *dto.WSPayload, data *dto.WSMessageReactionData) error {
+		if p == nil {
+			mylog.Println("Processors not initialized yet; skipping MessageReactionEvent")
+			return nil
+		}
+		return p.ProcessMessageReaction(event.Type, data)
+	}
+}
+
+// MessageAuditHandler 处理频道主动消息的审核结果事件
+func MessageAuditHandler() event.MessageAuditEventHandler {
+	return func(event *dto.WSPayload, data *dto.WSMessageAuditData) error {
+		if p == nil {
+			mylog.Println("Processors not initialized yet; skipping MessageAuditEvent")
+			return nil
+		}
+		return p.ProcessMessageAudit(event.Type, data)
+	}
+}
+
 // GroupATMessageEventHandler 实现处理 群at 消息的回调
 func GroupATMessageEventHandler() event.GroupATMessageEventHandler {
 	return func(event *dto.WSPayload, data *dto.WSGroupATMessageData) error {
@@ -474,6 +610,8 @@ func GroupATMessageEventHandler() event.GroupATMessageEventHandler {
 			mylog.Println("Processors not initialized yet; skipping GroupATMessageEvent")
 			return nil
 		}
+		// 记录最近的事件id msg_id过期后可以用它被动回复
+		echo.AddTargetEvent("group", data.GroupID, event.ID)
 		return p.ProcessGroupMessage(data)
 	}
 }
@@ -485,6 +623,7 @@ func C2CMessageEventHandler() event.C2CMessageEventHandler {
 			mylog.Println("Processors not initialized yet; skipping C2CMessageEvent")
 			return nil
 		}
+		echo.AddTargetEvent("group_private", data.Author.ID, event.ID)
 		return p.ProcessC2CMessage(data)
 	}
 }
@@ -497,11 +636,11 @@ func getHandlerByName(handlerName string) (interface{}, bool) {
 		return ErrorNotifyHandler(), true
 	case "ATMessageEventHandler": //频道at信息
 		return ATMessageEventHandler(), true
-	case "GuildEventHandler": //频道事件
+	case "GuildEventHandler": //机器人加入退出频道
 		return GuildEventHandler(), true
-	case "MemberEventHandler": //频道成员新增
+	case "MemberEventHandler": //频道成员变更
 		return MemberEventHandler(), true
-	case "ChannelEventHandler": //频道事件
+	case "ChannelEventHandler": //子频道变更
 		return ChannelEventHandler(), true
 	case "DirectMessageHandler": //私域频道私信(dms)
 		return DirectMessageHandler(), true
@@ -509,6 +648,16 @@ func getHandlerByName(handlerName string) (interface{}, bool) {
 		return CreateMessageHandler(), true
 	case "InteractionHandler": //添加频道互动回应
 		return InteractionHandler(), true
+	case "MessageDeleteHandler": //私域频道消息撤回
+		return MessageDeleteHandler(), true
+	case "PublicMessageDeleteHandler": //公域频道at消息撤回
+		return PublicMessageDeleteHandler(), true
+	case "DirectMessageDeleteHandler": //频道私信撤回
+		return DirectMessageDeleteHandler(), true
+	case "MessageReactionHandler": //频道消息表情表态
+		return MessageReactionHandler(), true
+	case "MessageAuditHandler": //频道主动消息审核结果
+		return MessageAuditHandler(), true
 	case "ThreadEventHandler": //发帖事件 暂不支持 暂不支持
 		return nil, false
 		//return ThreadEventHandler(), true
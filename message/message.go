@@ -0,0 +1,277 @@
+// onebot v11消息的编解码 cq码字符串和消息段数组互相转换
+package message
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Segment 一个消息段
+type Segment struct {
+	Type string
+	Data map[string]string
+	keys []string // 参数的原始顺序 保证cq码往返不变
+}
+
+// Message 有序的消息段列表
+type Message []Segment
+
+// New 创建消息段 kv为依次排列的参数名和参数值
+func New(typ string, kv ...string) Segment {
+	seg := Segment{Type: typ, Data: make(map[string]string, len(kv)/2)}
+	for i := 0; i+1 < len(kv); i += 2 {
+		seg.Set(kv[i], kv[i+1])
+	}
+	return seg
+}
+
+// Text 纯文本消息段
+func Text(text string) Segment {
+	return New("text", "text", text)
+}
+
+// At at消息段
+func At(qq string) Segment {
+	return New("at", "qq", qq)
+}
+
+// Reply 回复消息段
+func Reply(id string) Segment {
+	return New("reply", "id", id)
+}
+
+// Image 图片消息段
+func Image(file string) Segment {
+	return New("image", "file", file)
+}
+
+// Get 取出参数 不存在时返回空字符串
+func (s Segment) Get(key string) string {
+	return s.Data[key]
+}
+
+// Set 设置参数 新参数排在已有参数之后
+func (s *Segment) Set(key, value string) {
+	if s.Data == nil {
+		s.Data = make(map[string]string)
+	}
+	if _, ok := s.Data[key]; !ok {
+		s.keys = append(s.keys, key)
+	}
+	s.Data[key] = value
+}
+
+// 按原始顺序排列的参数名 直接构造Data的参数按字典序排在后面
+func (s Segment) orderedKeys() []string {
+	keys := make([]string, 0, len(s.Data))
+	seen := make(map[string]bool, len(s.keys))
+	for _, k := range s.keys {
+		if _, ok := s.Data[k]; ok && !seen[k] {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+	var rest []string
+	for k := range s.Data {
+		if !seen[k] {
+			rest = append(rest, k)
+		}
+	}
+	sort.Strings(rest)
+	return append(keys, rest...)
+}
+
+// String 将消息段转换为cq码 文本段只做转义
+func (s Segment) String() string {
+	if s.Type == "text" {
+		return EscapeText(s.Data["text"])
+	}
+	var b strings.Builder
+	b.WriteString("[CQ:")
+	b.WriteString(s.Type)
+	for _, k := range s.orderedKeys() {
+		b.WriteByte(',')
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(EscapeParam(s.Data[k]))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+// String 将消息转换为cq码字符串
+func (m Message) String() string {
+	var b strings.Builder
+	for _, seg := range m {
+		b.WriteString(seg.String())
+	}
+	return b.String()
+}
+
+// Array 将消息转换为onebot的消息段数组
+func (m Message) Array() []map[string]interface{} {
+	segments := make([]map[string]interface{}, 0, len(m))
+	for _, seg := range m {
+		data := make(map[string]interface{}, len(seg.Data))
+		for k, v := range seg.Data {
+			data[k] = v
+		}
+		segments = append(segments, map[string]interface{}{
+			"type": seg.Type,
+			"data": data,
+		})
+	}
+	return segments
+}
+
+// EscapeText 转义文本中的& [ ]
+func EscapeText(s string) string {
+	s = strings.ReplaceAll(s, "&", "&amp;")
+	s = strings.ReplaceAll(s, "[", "&#91;")
+	return strings.ReplaceAll(s, "]", "&#93;")
+}
+
+// EscapeParam 转义cq码参数 在文本转义的基础上额外转义逗号
+func EscapeParam(s string) string {
+	return strings.ReplaceAll(EscapeText(s), ",", "&#44;")
+}
+
+// Unescape 还原转义 &amp;最后处理 避免二次还原
+func Unescape(s string) string {
+	s = strings.ReplaceAll(s, "&#44;", ",")
+	s = strings.ReplaceAll(s, "&#91;", "[")
+	s = strings.ReplaceAll(s, "&#93;", "]")
+	return strings.ReplaceAll(s, "&amp;", "&")
+}
+
+// ParseCQ 解析cq码字符串 相邻的文本合并为一个文本段
+func ParseCQ(s string) Message {
+	var m Message
+	var text strings.Builder
+	flushText := func() {
+		if text.Len() > 0 {
+			m = append(m, Text(Unescape(text.String())))
+			text.Reset()
+		}
+	}
+	for {
+		start := strings.Index(s, "[CQ:")
+		if start < 0 {
+			break
+		}
+		// 参数中的]已被转义 第一个]就是cq码的结尾
+		end := strings.IndexByte(s[start:], ']')
+		if end < 0 {
+			break
+		}
+		end += start
+		seg, ok := parseCQCode(s[start+len("[CQ:") : end])
+		if !ok {
+			// 不是合法的cq码 按文本处理
+			text.WriteString(s[:end+1])
+			s = s[end+1:]
+			continue
+		}
+		text.WriteString(s[:start])
+		flushText()
+		m = append(m, seg)
+		s = s[end+1:]
+	}
+	text.WriteString(s)
+	flushText()
+	return m
+}
+
+// 解析去掉[CQ:和]之后的cq码内容
+func parseCQCode(code string) (Segment, bool) {
+	parts := strings.Split(code, ",")
+	if parts[0] == "" || strings.ContainsAny(parts[0], "=[") {
+		return Segment{}, false
+	}
+	seg := New(parts[0])
+	for _, part := range parts[1:] {
+		k, v, _ := strings.Cut(part, "=")
+		seg.Set(k, Unescape(v))
+	}
+	return seg, true
+}
+
+// Parse 解析onebot的message字段 支持cq码字符串 单个消息段和消息段数组
+func Parse(v interface{}) Message {
+	switch msg := v.(type) {
+	case string:
+		return ParseCQ(msg)
+	case map[string]interface{}:
+		if seg, ok := parseSegment(msg); ok {
+			return Message{seg}
+		}
+	case []interface{}:
+		var m Message
+		for _, item := range msg {
+			if segMap, ok := item.(map[string]interface{}); ok {
+				if seg, ok := parseSegment(segMap); ok {
+					m = append(m, seg)
+				}
+			}
+		}
+		return m
+	case []map[string]interface{}:
+		var m Message
+		for _, segMap := range msg {
+			if seg, ok := parseSegment(segMap); ok {
+				m = append(m, seg)
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+// 解析单个消息段 参数按字典序排列 非字符串的参数转换为字符串
+func parseSegment(segMap map[string]interface{}) (Segment, bool) {
+	typ, ok := segMap["type"].(string)
+	if !ok || typ == "" {
+		return Segment{}, false
+	}
+	seg := New(typ)
+	data, _ := segMap["data"].(map[string]interface{})
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if value, ok := formatValue(data[k]); ok {
+			seg.Set(k, value)
+		}
+	}
+	return seg, true
+}
+
+// 将参数值转换为字符串 数字不使用科学计数法 对象和数组保留为json
+func formatValue(v interface{}) (string, bool) {
+	switch value := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return value, true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(value), true
+	case int64:
+		return strconv.FormatInt(value, 10), true
+	case bool:
+		return strconv.FormatBool(value), true
+	case json.Number:
+		return value.String(), true
+	default:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	}
+}
@@ -0,0 +1,84 @@
+package message
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "更新testdata中的golden文件")
+
+// 每个用例的输入是onebot的message字段 输出对比testdata/<name>.golden
+var goldenCases = []struct {
+	name  string
+	input string // json格式的message字段
+}{
+	{"text", `"hello world"`},
+	{"escaped_text", `"&#91;not a code&#93; &amp; [CQ:at,qq=123] done"`},
+	{"escaped_param", `"[CQ:image,file=a&#44;b.png,url=https://x.com/?a=1&amp;b=&#91;2&#93;]"`},
+	{"multiple_images", `"[CQ:image,file=https://a.com/1.png]middle[CQ:image,file=https://a.com/2.png][CQ:image,file=file:///tmp/3.png]"`},
+	{"reply_order", `"[CQ:reply,id=42][CQ:at,qq=10001] 你好"`},
+	{"invalid_code", `"[CQ:] [CQ:at,qq=1"`},
+	{"single_segment", `{"type":"at","data":{"qq":"10001"}}`},
+	{"segment_array", `[{"type":"text","data":{"text":"a,b[c]"}},{"type":"image","data":{"url":"https://a.com/1.png","file":"1.png"}},{"type":"reply","data":{"id":123456789012}},{"type":"face"}]`},
+	{"segment_object_value", `[{"type":"markdown","data":{"template_id":12,"params":[{"key":"a","values":["b,c"]}]}}]`},
+}
+
+type goldenOutput struct {
+	CQ    string                   `json:"cq"`
+	Array []map[string]interface{} `json:"array"`
+}
+
+func TestGolden(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var input interface{}
+			if err := json.Unmarshal([]byte(tc.input), &input); err != nil {
+				t.Fatalf("invalid input: %v", err)
+			}
+			m := Parse(input)
+			// 关闭html转义 golden文件中保留原始的&和<>
+			var buf bytes.Buffer
+			enc := json.NewEncoder(&buf)
+			enc.SetEscapeHTML(false)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(goldenOutput{CQ: m.String(), Array: m.Array()}); err != nil {
+				t.Fatal(err)
+			}
+			got := buf.Bytes()
+
+			path := filepath.Join("testdata", tc.name+".golden")
+			if *update {
+				if err := os.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden file: %v (run go test -update)", err)
+			}
+			if string(got) != string(want) {
+				t.Errorf("output mismatch\ngot:\n%s\nwant:\n%s", got, want)
+			}
+
+			// 序列化后的cq码再次解析 应当得到相同的结果
+			if again := ParseCQ(m.String()).String(); again != m.String() {
+				t.Errorf("round trip mismatch\ngot:  %s\nwant: %s", again, m.String())
+			}
+		})
+	}
+}
+
+func TestEscapeRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "plain", "[CQ:at,qq=1]", "&#91;", "&amp;#44;", "a,b&c[d]"} {
+		if got := Unescape(EscapeParam(s)); got != s {
+			t.Errorf("param %q: got %q", s, got)
+		}
+		if got := Unescape(EscapeText(s)); got != s {
+			t.Errorf("text %q: got %q", s, got)
+		}
+	}
+}
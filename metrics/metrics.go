@@ -0,0 +1,185 @@
+// prometheus监控指标 通过/metrics暴露
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/event"
+	"github.com/tencent-connect/botgo/openapi"
+	"github.com/tencent-connect/botgo/token"
+)
+
+const namespace = "gensokyo"
+
+// 使用独立的registry 避免引入的库向默认registry注册无关指标
+var registry = prometheus.NewRegistry()
+
+var (
+	eventsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "按类型统计的收到的qq事件数量",
+	}, []string{"type"})
+
+	actionsHandled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actions_total",
+		Help:      "按action和结果统计的onebot action调用次数 结果根据回执的retcode判断",
+	}, []string{"action", "result"})
+
+	actionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "action_duration_seconds",
+		Help:      "onebot action的处理耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"action"})
+
+	openapiRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "openapi_requests_total",
+		Help:      "qq开放平台openapi请求次数 code为开放平台返回的错误码,成功为0",
+	}, []string{"version", "method", "status", "code"})
+
+	openapiDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "openapi_request_duration_seconds",
+		Help:      "qq开放平台openapi请求耗时",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"version", "method"})
+
+	wsClients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "ws_clients",
+		Help:      "已连接的onebot ws客户端数量 forward为连接到正向ws的客户端,reverse为反向ws连接",
+	}, []string{"direction"})
+
+	idmapTxDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "idmap_tx_duration_seconds",
+		Help:      "idmap存储后端的事务耗时",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation"})
+
+	tokenRefresh = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "token_refresh_total",
+		Help:      "access token刷新结果",
+	}, []string{"result"})
+
+	messagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "send_total",
+		Help:      "经过发送调度的消息 mode为active或passive result为ok failed rate_limited或timeout",
+	}, []string{"mode", "result"})
+
+	sendQueueWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "send_queue_wait_seconds",
+		Help:      "消息在发送调度中排队等待的时间",
+		Buckets:   []float64{0, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"mode"})
+
+	echoPersistDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "echo_persist_dropped_total",
+		Help:      "持久化队列已满时丢弃的echo映射变更",
+	}, []string{"bucket"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		eventsReceived,
+		actionsHandled,
+		actionDuration,
+		openapiRequests,
+		openapiDuration,
+		wsClients,
+		idmapTxDuration,
+		tokenRefresh,
+		messagesSent,
+		sendQueueWait,
+		echoPersistDropped,
+	)
+
+	// 挂载到botgo提供的观察者上
+	event.RegisterEventObserver(func(eventType dto.EventType) {
+		eventsReceived.WithLabelValues(string(eventType)).Inc()
+	})
+	openapi.RegisterMetricObserver(observeOpenAPI)
+	token.RegisterRefreshObserver(func(err error) {
+		if err != nil {
+			tokenRefresh.WithLabelValues("failed").Inc()
+			return
+		}
+		tokenRefresh.WithLabelValues("ok").Inc()
+	})
+}
+
+// Handler 返回/metrics的http handler
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveAction 记录一次action调用 result为ok failed或no_response
+func ObserveAction(action string, result string, duration time.Duration) {
+	actionsHandled.WithLabelValues(action, result).Inc()
+	actionDuration.WithLabelValues(action).Observe(duration.Seconds())
+}
+
+// ObserveIdmapTx 记录一次idmap事务的耗时
+func ObserveIdmapTx(operation string, start time.Time) {
+	idmapTxDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// IncWsClients 有ws客户端连接 direction为forward或reverse
+func IncWsClients(direction string) {
+	wsClients.WithLabelValues(direction).Inc()
+}
+
+// DecWsClients ws客户端断开
+func DecWsClients(direction string) {
+	wsClients.WithLabelValues(direction).Dec()
+}
+
+// ObserveSend 记录一次经过发送调度的消息 mode为active或passive
+func ObserveSend(mode string, result string) {
+	messagesSent.WithLabelValues(mode, result).Inc()
+}
+
+// ObserveSendWait 记录消息排队等待的时间
+func ObserveSendWait(mode string, wait time.Duration) {
+	sendQueueWait.WithLabelValues(mode).Observe(wait.Seconds())
+}
+
+// ObserveEchoPersistDropped 记录一次被丢弃的echo持久化变更
+func ObserveEchoPersistDropped(bucket string) {
+	echoPersistDropped.WithLabelValues(bucket).Inc()
+}
+
+// 开放平台出错时返回的body
+type openapiError struct {
+	Code int `json:"code"`
+}
+
+func observeOpenAPI(m openapi.RequestMetric) {
+	code := "0"
+	if !openapi.IsSuccessStatus(m.StatusCode) {
+		var e openapiError
+		if err := json.Unmarshal(m.Body, &e); err == nil && e.Code != 0 {
+			code = strconv.Itoa(e.Code)
+		} else {
+			code = "unknown"
+		}
+	}
+	openapiRequests.WithLabelValues(m.Version, m.Method, strconv.Itoa(m.StatusCode), code).Inc()
+	openapiDuration.WithLabelValues(m.Version, m.Method).Observe(m.Latency.Seconds())
+}
@@ -0,0 +1,62 @@
+package sendqueue
+
+import (
+	"time"
+)
+
+// bucket 令牌桶 令牌可以为负数 代表已被排队中的发送预留
+type bucket struct {
+	capacity float64
+	interval time.Duration // 生成一个令牌的时间
+	tokens   float64
+	last     time.Time
+}
+
+// 每period生成capacity个令牌 初始是满的
+func newBucket(capacity int, period time.Duration, now time.Time) *bucket {
+	b := &bucket{tokens: float64(capacity), last: now}
+	b.setLimit(capacity, period)
+	return b
+}
+
+// 配置变化时更新容量和速率 多出的令牌丢弃
+func (b *bucket) setLimit(capacity int, period time.Duration) {
+	b.capacity = float64(capacity)
+	b.interval = period / time.Duration(capacity)
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+func (b *bucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last)
+	if elapsed <= 0 {
+		return
+	}
+	b.tokens += float64(elapsed) / float64(b.interval)
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// 预留一个令牌 返回需要等待的时间
+func (b *bucket) reserve(now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens * float64(b.interval))
+}
+
+// 放弃预留的令牌
+func (b *bucket) cancel() {
+	b.tokens++
+}
+
+// 令牌已满 说明一段时间没有发送 可以回收
+func (b *bucket) idle(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.capacity
+}
@@ -0,0 +1,72 @@
+package sendqueue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBucketPerSecond(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	cases := []struct {
+		name   string
+		offset time.Duration // 相对start的发送时间
+		wait   time.Duration
+	}{
+		{"first", 0, 0},
+		{"second", 0, 0},
+		{"burst exhausted", 0, 500 * time.Millisecond},
+		{"queued behind", 0, time.Second},
+		{"partly refilled", 250 * time.Millisecond, 1250 * time.Millisecond},
+	}
+	// 每秒2次
+	b := newBucket(2, time.Second, start)
+	for _, tc := range cases {
+		if got := b.reserve(start.Add(tc.offset)); got != tc.wait {
+			t.Errorf("%s: wait = %v, want %v", tc.name, got, tc.wait)
+		}
+	}
+}
+
+func TestBucketDailyQuota(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	cases := []struct {
+		name   string
+		offset time.Duration
+		wait   time.Duration
+	}{
+		{"first", 0, 0},
+		{"second", time.Hour, 0},
+		{"quota used", 2 * time.Hour, 10 * time.Hour}, // 每12小时恢复一次 已恢复2小时
+		{"cancelled", 0, 0},                           // 取消上一条的预留
+		{"next day", 26 * time.Hour, 0},
+		{"next day second", 26 * time.Hour, 0},
+		{"next day used", 26 * time.Hour, 12 * time.Hour},
+	}
+	// 每天2次 额度平滑恢复 一天后恢复满
+	b := newBucket(2, 24*time.Hour, start)
+	for _, tc := range cases {
+		if tc.name == "cancelled" {
+			b.cancel()
+			continue
+		}
+		if got := b.reserve(start.Add(tc.offset)); got != tc.wait {
+			t.Errorf("%s: wait = %v, want %v", tc.name, got, tc.wait)
+		}
+	}
+}
+
+func TestBucketSetLimit(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newBucket(10, time.Second, now)
+	b.setLimit(2, time.Second)
+	if b.tokens != 2 {
+		t.Fatalf("tokens = %v, want 2", b.tokens)
+	}
+	if !b.idle(now) {
+		t.Error("full bucket should be idle")
+	}
+	b.reserve(now)
+	if b.idle(now) {
+		t.Error("bucket with reserved token should not be idle")
+	}
+}
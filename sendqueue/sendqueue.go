@@ -0,0 +1,176 @@
+// 发送调度 位于handlers和openapi之间 按全局和每个群 用户 子频道的令牌桶排队发送
+// 主动消息和被动回复分别计数 遇到qq的频率限制错误时退避重试
+package sendqueue
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/metrics"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/errs"
+)
+
+// ErrQueueTimeout 需要排队的时间超过send_queue_wait
+var ErrQueueTimeout = errors.New("发送过于频繁 排队超时")
+
+// 第一次重试前的等待时间 之后依次加倍 测试中会缩短
+var retryBackoff = time.Second
+
+// 回收空闲令牌桶的间隔
+const cleanupInterval = 10 * time.Minute
+
+// qq开放平台表示频率限制的错误码
+var rateLimitCodes = map[int]bool{
+	22009: true, // 消息发送超频
+	20028: true, // 子频道消息触发限频
+}
+
+// Target 发送目标 Scope为group user或channel 频道私信按user计数
+type Target struct {
+	Scope string
+	ID    string
+}
+
+type scheduler struct {
+	mu          sync.Mutex
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+}
+
+var sched = &scheduler{
+	buckets:     make(map[string]*bucket),
+	lastCleanup: time.Now(),
+}
+
+// IsPassive 带有msg_id或event_id的信息是被动回复
+func IsPassive(msg *dto.MessageToCreate) bool {
+	return msg.MsgID != "" || msg.EventID != ""
+}
+
+// Send 在令牌桶中排队 取得令牌后调用send
+// passive为false时计入主动消息的配额 遇到频率限制错误时按1 2 4秒退避重试
+func Send(target Target, passive bool, send func() (*dto.Message, error)) (*dto.Message, error) {
+	mode := "active"
+	if passive {
+		mode = "passive"
+	}
+	retries := config.GetSendRetry()
+	backoff := retryBackoff
+	for attempt := 0; ; attempt++ {
+		wait, err := sched.acquire(target, passive)
+		if err != nil {
+			mylog.Printf("发送到%s[%s]需要排队%v 超过等待上限", target.Scope, target.ID, wait)
+			metrics.ObserveSend(mode, "timeout")
+			return nil, err
+		}
+		metrics.ObserveSendWait(mode, wait)
+		time.Sleep(wait)
+
+		resp, err := send()
+		switch {
+		case err == nil:
+			metrics.ObserveSend(mode, "ok")
+			return resp, nil
+		case !IsRateLimited(err):
+			metrics.ObserveSend(mode, "failed")
+			return resp, err
+		}
+		metrics.ObserveSend(mode, "rate_limited")
+		if attempt >= retries {
+			return resp, err
+		}
+		mylog.Printf("发送到%s[%s]触发频率限制 %v后重试: %v", target.Scope, target.ID, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// IsRateLimited 判断是否是qq开放平台的频率限制错误
+func IsRateLimited(err error) bool {
+	if err == nil {
+		return false
+	}
+	sdkErr := errs.Error(err)
+	if sdkErr.Code() == 429 {
+		return true
+	}
+	var body struct {
+		Code int `json:"code"`
+	}
+	if json.Unmarshal([]byte(sdkErr.Text()), &body) != nil {
+		return false
+	}
+	return rateLimitCodes[body.Code]
+}
+
+// 从全局和目标的令牌桶中各预留一个令牌 返回需要等待的时间
+// 等待时间超过上限时放弃预留 返回ErrQueueTimeout
+func (s *scheduler) acquire(target Target, passive bool) (time.Duration, error) {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanup(now)
+
+	var wait time.Duration
+	reserved := make([]*bucket, 0, 2)
+	for _, b := range s.bucketsFor(target, passive, now) {
+		if d := b.reserve(now); d > wait {
+			wait = d
+		}
+		reserved = append(reserved, b)
+	}
+	if wait > config.GetSendQueueWait() {
+		for _, b := range reserved {
+			b.cancel()
+		}
+		return wait, ErrQueueTimeout
+	}
+	return wait, nil
+}
+
+// 发送需要经过的令牌桶 被动回复每秒计数 主动消息每天计数 getter返回0的不限制
+func (s *scheduler) bucketsFor(target Target, passive bool, now time.Time) []*bucket {
+	var buckets []*bucket
+	if rate := config.GetSendRateGlobal(); rate > 0 {
+		buckets = append(buckets, s.bucket("global", rate, time.Second, now))
+	}
+	key := target.Scope + ":" + target.ID
+	if passive {
+		if rate := config.GetSendRatePassive(); rate > 0 {
+			buckets = append(buckets, s.bucket(key+":passive", rate, time.Second, now))
+		}
+	} else if quota := config.GetSendActiveQuota(); quota > 0 {
+		buckets = append(buckets, s.bucket(key+":active", quota, 24*time.Hour, now))
+	}
+	return buckets
+}
+
+// 取出令牌桶 不存在时创建 并应用当前的配置
+func (s *scheduler) bucket(key string, capacity int, period time.Duration, now time.Time) *bucket {
+	b, ok := s.buckets[key]
+	if !ok {
+		b = newBucket(capacity, period, now)
+		s.buckets[key] = b
+		return b
+	}
+	b.setLimit(capacity, period)
+	return b
+}
+
+// 定期回收已经恢复满的令牌桶 避免目标越来越多
+func (s *scheduler) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < cleanupInterval {
+		return
+	}
+	s.lastCleanup = now
+	for key, b := range s.buckets {
+		if b.idle(now) {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package sendqueue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/template"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/errs"
+)
+
+// 使用默认配置模板 主动消息改为每天2条 send_queue_wait为10秒 send_retry为3次
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "gensokyo-sendqueue-test")
+	if err != nil {
+		panic(err)
+	}
+	conf := strings.Replace(template.ConfigTemplate, "send_active_quota : 0 ", "send_active_quota : 2 ", 1)
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte(conf), 0644); err != nil {
+		panic(err)
+	}
+	if _, err := config.LoadConfig(path); err != nil {
+		panic(err)
+	}
+	retryBackoff = time.Millisecond
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// 替换为空的调度器 测试之间互不影响
+func resetScheduler() {
+	sched = &scheduler{buckets: make(map[string]*bucket), lastCleanup: time.Now()}
+}
+
+func TestAcquireQueueTimeout(t *testing.T) {
+	resetScheduler()
+	target := Target{Scope: "group", ID: "timeout"}
+	cases := []struct {
+		name    string
+		passive bool
+		err     error
+	}{
+		{"active 1", false, nil},
+		{"active 2", false, nil},
+		{"active quota used", false, ErrQueueTimeout}, // 需要等待约12小时
+		{"still timeout", false, ErrQueueTimeout},     // 超时不占用额度
+		{"passive not affected", true, nil},
+	}
+	for _, tc := range cases {
+		wait, err := sched.acquire(target, tc.passive)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%s: err = %v, want %v", tc.name, err, tc.err)
+		}
+		if err == nil && wait != 0 {
+			t.Errorf("%s: wait = %v, want 0", tc.name, wait)
+		}
+	}
+	// 其他目标的额度单独计算
+	if _, err := sched.acquire(Target{Scope: "group", ID: "other"}, false); err != nil {
+		t.Errorf("other target: %v", err)
+	}
+}
+
+func TestSendRetry(t *testing.T) {
+	rateLimited := errs.New(429, "too many requests")
+	sendLimited := errs.New(500, `{"code":22009,"message":"msg limit exceed"}`)
+	channelLimited := errs.New(500, `{"code":20028,"message":"channel limit"}`)
+	failed := errs.New(500, `{"code":11255,"message":"invalid request"}`)
+	cases := []struct {
+		name  string
+		errs  []error // 每次调用返回的错误 超出后返回成功
+		calls int
+		err   error
+	}{
+		{name: "ok", calls: 1},
+		{name: "429 then ok", errs: []error{rateLimited}, calls: 2},
+		{name: "22009 then ok", errs: []error{sendLimited, sendLimited}, calls: 3},
+		{name: "20028 retries exhausted", errs: []error{channelLimited, channelLimited, channelLimited, channelLimited, channelLimited}, calls: 4, err: channelLimited},
+		{name: "other error not retried", errs: []error{failed}, calls: 1, err: failed},
+		{name: "plain error not retried", errs: []error{errors.New("network")}, calls: 1, err: errors.New("network")},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resetScheduler()
+			calls := 0
+			_, err := Send(Target{Scope: "group", ID: "retry"}, true, func() (*dto.Message, error) {
+				calls++
+				if calls <= len(tc.errs) {
+					return nil, tc.errs[calls-1]
+				}
+				return &dto.Message{ID: "ok"}, nil
+			})
+			if calls != tc.calls {
+				t.Errorf("calls = %d, want %d", calls, tc.calls)
+			}
+			if (err == nil) != (tc.err == nil) || (err != nil && err.Error() != tc.err.Error()) {
+				t.Errorf("err = %v, want %v", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestIsRateLimited(t *testing.T) {
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errs.New(429, "too many requests"), true},
+		{errs.New(500, `{"code":22009}`), true},
+		{errs.New(500, `{"code":20028}`), true},
+		{errs.New(500, `{"code":304003}`), false},
+		{errs.New(500, "not json"), false},
+		{errors.New("network"), false},
+	}
+	for _, tc := range cases {
+		if got := IsRateLimited(tc.err); got != tc.want {
+			t.Errorf("IsRateLimited(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
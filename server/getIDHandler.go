@@ -12,7 +12,7 @@ func GetIDHandler(c *gin.Context) {
 	idOrRow := c.Query("id")
 	typeVal, err := strconv.Atoi(c.Query("type"))
 
-	if err != nil || (typeVal != 1 && typeVal != 2) {
+	if err != nil || typeVal < 1 || typeVal > 4 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid type"})
 		return
 	}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo/callapi"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/stats"
+	"github.com/hoshinonyaruko/gensokyo/wsclient"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+// HTTPAPIClient 实现callapi.Client,不写入socket,而是捕获handler的回执用于同步返回http响应
+type HTTPAPIClient struct {
+	mu       sync.Mutex
+	response map[string]interface{}
+}
+
+// 确保HTTPAPIClient实现了callapi.Client接口
+var _ callapi.Client = &HTTPAPIClient{}
+
+// SendMessage 捕获handler发出的回执
+// 部分handler(如文本+图片)会多次发送回执,保留第一次的结果,但之后的失败回执会覆盖之前的成功回执
+func (c *HTTPAPIClient) SendMessage(message map[string]interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.response == nil {
+		c.response = message
+		return nil
+	}
+	if status, _ := message["status"].(string); status == "failed" {
+		c.response = message
+	}
+	return nil
+}
+
+// Response 返回捕获到的回执,handler没有发送回执时返回nil
+func (c *HTTPAPIClient) Response() map[string]interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.response
+}
+
+// 使用闭包结构 因为gin需要c *gin.Context固定签名
+func HTTPAPIHandlerWithDependencies(api openapi.OpenAPI, apiV2 openapi.OpenAPI) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		httpAPIHandler(api, apiV2, c)
+	}
+}
+
+// 处理onebot v11 http api请求 action名称取自请求路径
+func httpAPIHandler(api openapi.OpenAPI, apiV2 openapi.OpenAPI, c *gin.Context) {
+	// 与正向ws共用ws_server_token
+	if !validateAccessToken(c) {
+		return
+	}
+
+	stats.AddPacketReceived()
+	action := strings.Trim(c.FullPath(), "/")
+
+	params, err := parseHTTPAPIParams(c)
+	if err != nil {
+		mylog.Printf("http api解析参数失败: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"retcode": 1400,
+			"data":    nil,
+			"message": "invalid params: " + err.Error(),
+		})
+		return
+	}
+
+	// 复用ActionMessage的反序列化逻辑,兼容group_id/user_id为数字或字符串
+	raw, err := json.Marshal(map[string]interface{}{
+		"action": action,
+		"params": params,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "failed", "retcode": 1400, "data": nil, "message": err.Error()})
+		return
+	}
+	var message callapi.ActionMessage
+	if err := json.Unmarshal(raw, &message); err != nil {
+		mylog.Printf("http api解析action失败: %v, 原始数据: %s", err, string(raw))
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":  "failed",
+			"retcode": 1400,
+			"data":    nil,
+			"message": "invalid params: " + err.Error(),
+		})
+		return
+	}
+
+	mylog.Println("Received from HTTP API onebotv11 client:", wsclient.TruncateMessage(message, 500))
+
+	client := &HTTPAPIClient{}
+	callapi.CallAPIFromDict(client, api, apiV2, message)
+
+	response := client.Response()
+	if response == nil {
+		// handler出错提前返回,没有发送任何回执
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "failed",
+			"retcode": -1,
+			"data":    nil,
+			"message": "action " + action + " returned no response",
+		})
+		return
+	}
+	stats.AddPacketSent()
+	c.JSON(http.StatusOK, response)
+}
+
+// 请求体的大小上限 100MB的文件经base64编码后约为134MB
+const maxHTTPAPIBodySize = 160 << 20
+
+// 从query、表单或json body中解析参数,后者优先
+func parseHTTPAPIParams(c *gin.Context) (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+	for key, values := range c.Request.URL.Query() {
+		if key == "access_token" || len(values) == 0 {
+			continue
+		}
+		params[key] = convertHTTPAPIValue(key, values[0])
+	}
+
+	if c.Request.Method != http.MethodPost {
+		return params, nil
+	}
+
+	// base64的媒体文件可能很大 但不能无限制读取
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxHTTPAPIBodySize)
+	contentType := c.ContentType()
+	switch {
+	case strings.HasPrefix(contentType, "application/json"):
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(body) == 0 {
+			return params, nil
+		}
+		var bodyParams map[string]interface{}
+		if err := json.Unmarshal(body, &bodyParams); err != nil {
+			return nil, err
+		}
+		for key, value := range bodyParams {
+			params[key] = value
+		}
+	case strings.HasPrefix(contentType, "application/x-www-form-urlencoded"),
+		strings.HasPrefix(contentType, "multipart/form-data"):
+		if err := c.Request.ParseMultipartForm(32 << 20); err != nil && err != http.ErrNotMultipart {
+			return nil, err
+		}
+		for key, values := range c.Request.PostForm {
+			if len(values) == 0 {
+				continue
+			}
+			params[key] = convertHTTPAPIValue(key, values[0])
+		}
+	}
+	return params, nil
+}
+
+// query和表单里只有这些参数还原为数字 其余保持字符串 避免echo 名片等被改写
+// emoji_id可能带有前导0 不在其中
+var httpAPINumberKeys = map[string]bool{
+	"group_id":   true,
+	"user_id":    true,
+	"message_id": true,
+	"duration":   true,
+	"emoji_type": true,
+	"count":      true,
+	"times":      true,
+}
+
+// 还原为布尔值的参数
+var httpAPIBoolKeys = map[string]bool{
+	"auto_escape": true,
+	"enable":      true,
+	"set":         true,
+	"hidetip":     true,
+	"no_cache":    true,
+}
+
+// query和表单里的值都是字符串,把已知的数字和布尔参数还原成json对应的类型
+func convertHTTPAPIValue(key, value string) interface{} {
+	switch {
+	case httpAPIBoolKeys[key]:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	case httpAPINumberKeys[key]:
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	}
+	return value
+}
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/template"
+)
+
+// 使用默认配置模板 ws_server_token为12345
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	dir, err := os.MkdirTemp("", "gensokyo-server-test")
+	if err != nil {
+		panic(err)
+	}
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte(template.ConfigTemplate), 0644); err != nil {
+		panic(err)
+	}
+	if _, err := config.LoadConfig(path); err != nil {
+		panic(err)
+	}
+	code := m.Run()
+	os.RemoveAll(dir)
+	// url包在init中于当前目录创建的短链接数据库
+	os.Remove("gensokyo.db")
+	os.Exit(code)
+}
+
+// 用请求构造gin的上下文
+func testContext(req *http.Request) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	return c, w
+}
+
+func TestParseHTTPAPIParams(t *testing.T) {
+	cases := []struct {
+		name        string
+		method      string
+		target      string
+		contentType string
+		body        string
+		want        map[string]interface{}
+	}{
+		{
+			name:   "query",
+			method: http.MethodGet,
+			target: "/send_group_msg?group_id=123&message=456&access_token=x",
+			want:   map[string]interface{}{"group_id": int64(123), "message": "456"},
+		},
+		{
+			name:   "string keys stay strings",
+			method: http.MethodGet,
+			target: "/set_msg_emoji_like?echo=42&emoji_id=007&card=true&guild_id=789",
+			want:   map[string]interface{}{"echo": "42", "emoji_id": "007", "card": "true", "guild_id": "789"},
+		},
+		{
+			name:   "bool keys",
+			method: http.MethodGet,
+			target: "/set_group_whole_ban?group_id=1&enable=true&set=0",
+			want:   map[string]interface{}{"group_id": int64(1), "enable": true, "set": false},
+		},
+		{
+			name:   "invalid number stays string",
+			method: http.MethodGet,
+			target: "/send_private_msg?user_id=abc",
+			want:   map[string]interface{}{"user_id": "abc"},
+		},
+		{
+			name:        "form",
+			method:      http.MethodPost,
+			target:      "/send_private_msg",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "user_id=10001&message=123&nickname=0",
+			want:        map[string]interface{}{"user_id": int64(10001), "message": "123", "nickname": "0"},
+		},
+		{
+			name:        "json body overrides query",
+			method:      http.MethodPost,
+			target:      "/send_group_msg?group_id=1",
+			contentType: "application/json",
+			body:        `{"group_id":"2","message":[{"type":"text","data":{"text":"hi"}}]}`,
+			want: map[string]interface{}{
+				"group_id": "2",
+				"message":  []interface{}{map[string]interface{}{"type": "text", "data": map[string]interface{}{"text": "hi"}}},
+			},
+		},
+		{
+			name:        "empty json body",
+			method:      http.MethodPost,
+			target:      "/get_login_info",
+			contentType: "application/json",
+			want:        map[string]interface{}{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.contentType != "" {
+				req.Header.Set("Content-Type", tc.contentType)
+			}
+			c, _ := testContext(req)
+			got, err := parseHTTPAPIParams(c)
+			if err != nil {
+				t.Fatalf("parseHTTPAPIParams: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("got %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseHTTPAPIParamsInvalidJSON(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/send_group_msg", strings.NewReader("{"))
+	req.Header.Set("Content-Type", "application/json")
+	c, _ := testContext(req)
+	if _, err := parseHTTPAPIParams(c); err == nil {
+		t.Fatal("expected error for invalid json")
+	}
+}
+
+func TestValidateAccessToken(t *testing.T) {
+	cases := []struct {
+		name   string
+		header string
+		query  string
+		ok     bool
+		status int
+	}{
+		{name: "bearer", header: "Bearer 12345", ok: true},
+		{name: "token", header: "Token 12345", ok: true},
+		{name: "raw header", header: "12345", ok: true},
+		{name: "query", query: "?access_token=12345", ok: true},
+		{name: "missing", status: http.StatusUnauthorized},
+		{name: "wrong", header: "Bearer 54321", status: http.StatusForbidden},
+		{name: "header wins over query", header: "Bearer 1", query: "?access_token=12345", status: http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/get_status"+tc.query, nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			c, w := testContext(req)
+			if got := validateAccessToken(c); got != tc.ok {
+				t.Fatalf("validateAccessToken = %v, want %v", got, tc.ok)
+			}
+			if !tc.ok && w.Code != tc.status {
+				t.Errorf("status = %d, want %d", w.Code, tc.status)
+			}
+		})
+	}
+}
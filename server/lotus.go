@@ -0,0 +1,131 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/idmap"
+	"github.com/hoshinonyaruko/gensokyo/lotus"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+)
+
+// LotusAuth 校验lotus_secret 未设置密钥时只允许本机访问
+func LotusAuth() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		secret := config.GetLotusSecret()
+		if secret == "" {
+			if !isLoopbackRequest(c) {
+				mylog.Printf("未设置lotus_secret,拒绝来自%s的lotus请求", c.Request.RemoteAddr)
+				c.AbortWithStatusJSON(http.StatusForbidden, lotus.ErrorResponse{Error: "lotus_secret is not configured"})
+				return
+			}
+			c.Next()
+			return
+		}
+		token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(secret)) != 1 {
+			mylog.Printf("lotus请求鉴权失败,来源: %s", c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, lotus.ErrorResponse{Error: "invalid lotus secret"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// LoopbackOnly 只允许本机访问 用于只给自己调用的接口
+func LoopbackOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isLoopbackRequest(c) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// 请求是否来自本机 使用连接的地址 不信任X-Forwarded-For等请求头
+func isLoopbackRequest(c *gin.Context) bool {
+	host, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+	if err != nil {
+		host = c.Request.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// LotusIdmapHandler 批量执行idmap操作 结果与请求按顺序一一对应
+func LotusIdmapHandler(c *gin.Context) {
+	var req lotus.IdmapRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, lotus.ErrorResponse{Error: "invalid request body"})
+		return
+	}
+	if len(req.Ops) > lotus.MaxBatchSize {
+		c.JSON(http.StatusBadRequest, lotus.ErrorResponse{Error: "too many ops"})
+		return
+	}
+
+	results := make([]lotus.IdmapResult, len(req.Ops))
+	for i, op := range req.Ops {
+		results[i] = handleLotusIdmapOp(op)
+	}
+	c.JSON(http.StatusOK, lotus.IdmapResponse{Results: results})
+}
+
+func handleLotusIdmapOp(op lotus.IdmapOp) lotus.IdmapResult {
+	var result lotus.IdmapResult
+	var err error
+	switch op.Op {
+	case lotus.OpStoreID:
+		result.Row, err = idmap.StoreIDv2(op.ID)
+	case lotus.OpLookupID:
+		result.Row, err = idmap.LookupIDv2(op.ID)
+	case lotus.OpRetrieveID:
+		result.ID, err = idmap.RetrieveRowByIDv2(op.Row)
+	case lotus.OpWriteConfig:
+		err = idmap.WriteConfigv2(op.Section, op.Key, op.Value)
+	case lotus.OpReadConfig:
+		result.Value, err = idmap.ReadConfigv2(op.Section, op.Key)
+	default:
+		return lotus.IdmapResult{Error: "unknown op: " + op.Op, Code: lotus.CodeInvalid}
+	}
+	if err == idmap.ErrKeyNotFound {
+		return lotus.IdmapResult{Error: "ID not found", Code: lotus.CodeNotFound}
+	} else if err != nil {
+		return lotus.IdmapResult{Error: err.Error(), Code: lotus.CodeInternal}
+	}
+	return result
+}
+
+// LotusMediaHandler 上传base64图片到图床 与/uploadpic共用频率限制
+func LotusMediaHandler(rateLimiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !rateLimiter.CheckAndUpdateRateLimit(c.ClientIP()) {
+			c.JSON(http.StatusTooManyRequests, lotus.ErrorResponse{Error: "rate limit exceeded"})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxMediaFormSize)
+		var req lotus.MediaRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, lotus.ErrorResponse{Error: "invalid request body"})
+			return
+		}
+		var imageURL string
+		var status int
+		var err error
+		if req.MediaType != "" {
+			imageURL, status, err = saveBase64Media(req.MediaType, req.Base64Media, req.FileName)
+		} else {
+			imageURL, status, err = saveBase64Image(req.Base64Image)
+		}
+		if err != nil {
+			c.JSON(status, lotus.ErrorResponse{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, lotus.MediaResponse{URL: imageURL})
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// 默认配置没有lotus_secret 只允许本机访问
+func TestLotusAuthWithoutSecret(t *testing.T) {
+	cases := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		status     int
+	}{
+		{name: "ipv4 loopback", remoteAddr: "127.0.0.1:50000", status: http.StatusOK},
+		{name: "ipv6 loopback", remoteAddr: "[::1]:50000", status: http.StatusOK},
+		{name: "remote", remoteAddr: "203.0.113.5:50000", status: http.StatusForbidden},
+		{name: "forged forwarded header", remoteAddr: "203.0.113.5:50000", forwarded: "127.0.0.1", status: http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/lotus/idmap", strings.NewReader(`{"ops":[]}`))
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwarded)
+			}
+			c, _ := testContext(req)
+			LotusAuth()(c)
+			if tc.status == http.StatusOK && c.IsAborted() {
+				t.Fatalf("request aborted with %d", c.Writer.Status())
+			}
+			if tc.status != http.StatusOK && (!c.IsAborted() || c.Writer.Status() != tc.status) {
+				t.Fatalf("aborted = %v, status = %d, want %d", c.IsAborted(), c.Writer.Status(), tc.status)
+			}
+		})
+	}
+}
@@ -13,7 +13,10 @@ import (
 	_ "image/jpeg"
 	_ "image/png"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -23,6 +26,9 @@ import (
 
 const (
 	MaximumImageSize        = 10 * 1024 * 1024
+	MaximumMediaSize        = 20 * 1024 * 1024
+	MaximumVideoSize        = 100 * 1024 * 1024
+	MaximumFileSize         = 100 * 1024 * 1024
 	AllowedUploadsPerMinute = 100
 	MaxRequests             = 30
 	RequestInterval         = time.Minute
@@ -49,63 +55,148 @@ func UploadBase64ImageHandler(rateLimiter *RateLimiter) gin.HandlerFunc {
 		}
 
 		base64Image := c.PostForm("base64Image")
-		// Print the length of the received base64 data
-		mylog.Println("Received base64 data length:", len(base64Image), "characters")
-
-		imageBytes, err := base64.StdEncoding.DecodeString(base64Image)
+		imageURL, status, err := saveBase64Image(base64Image)
 		if err != nil {
-			mylog.Println("Error while decoding base64:", err) // Print error while decoding
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid base64 data"})
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{"url": imageURL})
 
-		imageFormat, err := getImageFormat(imageBytes)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "undefined picture format1"})
-			return
-		}
+	}
+}
 
-		fileExt := getFileExtensionFromImageFormat(imageFormat)
-		if fileExt == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported image format2"})
-			return
-		}
+// 保存base64图片到channel_temp 返回图床url 出错时返回对应的http状态码
+func saveBase64Image(base64Image string) (string, int, error) {
+	// Print the length of the received base64 data
+	mylog.Println("Received base64 data length:", len(base64Image), "characters")
 
-		fileName := generateRandomMd5() + "." + fileExt
-		directoryPath := "./channel_temp/"
-		savePath := directoryPath + fileName
+	imageBytes, err := base64.StdEncoding.DecodeString(base64Image)
+	if err != nil {
+		mylog.Println("Error while decoding base64:", err) // Print error while decoding
+		return "", http.StatusBadRequest, errors.New("invalid base64 data")
+	}
 
-		// Create the directory if it doesn't exist
-		err = os.MkdirAll(directoryPath, 0755)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error creating directory"})
+	imageFormat, err := getImageFormat(imageBytes)
+	if err != nil {
+		return "", http.StatusBadRequest, errors.New("undefined picture format1")
+	}
+
+	fileExt := getFileExtensionFromImageFormat(imageFormat)
+	if fileExt == "" {
+		return "", http.StatusBadRequest, errors.New("unsupported image format2")
+	}
+
+	return saveToChannelTemp(imageBytes, fileExt)
+}
+
+// 闭包,图床保存语音等其他媒体文件 media_type决定文件后缀
+func UploadBase64MediaHandler(rateLimiter *RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ipAddress := c.ClientIP()
+		if !rateLimiter.CheckAndUpdateRateLimit(ipAddress) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
 			return
 		}
 
-		err = os.WriteFile(savePath, imageBytes, 0644)
+		// 默认表单大小上限为10MB 按最大的媒体文件放宽 base64后体积约为4/3
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxMediaFormSize)
+		mediaURL, status, err := saveBase64Media(c.PostForm("mediaType"), c.PostForm("base64Media"), c.PostForm("fileName"))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "error saving file"})
+			c.JSON(status, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{"url": mediaURL})
+	}
+}
 
-		serverAddress := config.GetServer_dir()
-		serverPort := config.GetPortValue()
-		if serverAddress == "" {
-			// Handle the case where the server address is not configured
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "server address is not configured"})
-			return
-		}
+// 图床支持的媒体类型 保存的文件后缀和大小上限
+var mediaTypes = map[string]struct {
+	ext     string
+	maxSize int
+}{
+	"record": {"silk", MaximumMediaSize},
+	"video":  {"mp4", MaximumVideoSize},
+	"file":   {"bin", MaximumFileSize},
+}
 
-		// 根据serverPort确定协议
-		protocol := "http"
-		if serverPort == "443" {
-			protocol = "https"
+// 媒体上传表单的大小上限
+const maxMediaFormSize = MaximumFileSize/3*4 + 1024*1024
+
+// 保存base64媒体文件到channel_temp 返回图床url 文件带有原文件名时保留文件名和后缀
+func saveBase64Media(mediaType, base64Media, fileName string) (string, int, error) {
+	media, ok := mediaTypes[mediaType]
+	if !ok {
+		return "", http.StatusBadRequest, fmt.Errorf("unsupported media type: %s", mediaType)
+	}
+	mediaBytes, err := base64.StdEncoding.DecodeString(base64Media)
+	if err != nil {
+		return "", http.StatusBadRequest, errors.New("invalid base64 data")
+	}
+	if len(mediaBytes) > media.maxSize {
+		return "", http.StatusRequestEntityTooLarge, errors.New("media file too large")
+	}
+	if name := sanitizeFileName(fileName); mediaType == "file" && name != "" {
+		// 放在随机目录下 避免同名文件互相覆盖
+		return saveFileToChannelTemp(mediaBytes, generateRandomMd5()+"/"+name)
+	}
+	return saveToChannelTemp(mediaBytes, media.ext)
+}
+
+// 只保留文件名部分 去掉路径和不可见字符
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
 		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
 
-		imageURL := fmt.Sprintf("%s://%s:%s/channel_temp/%s", protocol, serverAddress, serverPort, fileName)
-		c.JSON(http.StatusOK, gin.H{"url": imageURL})
+// 以随机文件名保存到channel_temp 返回可公网访问的url
+func saveToChannelTemp(data []byte, fileExt string) (string, int, error) {
+	return saveFileToChannelTemp(data, generateRandomMd5()+"."+fileExt)
+}
+
+// 保存到channel_temp下的相对路径fileName 返回可公网访问的url
+func saveFileToChannelTemp(data []byte, fileName string) (string, int, error) {
+	savePath := filepath.Join("./channel_temp", filepath.FromSlash(fileName))
+	directoryPath := filepath.Dir(savePath)
+
+	// Create the directory if it doesn't exist
+	err := os.MkdirAll(directoryPath, 0755)
+	if err != nil {
+		return "", http.StatusInternalServerError, errors.New("error creating directory")
+	}
+
+	err = os.WriteFile(savePath, data, 0644)
+	if err != nil {
+		return "", http.StatusInternalServerError, errors.New("error saving file")
+	}
+
+	serverAddress := config.GetServer_dir()
+	serverPort := config.GetPortValue()
+	if serverAddress == "" {
+		// Handle the case where the server address is not configured
+		return "", http.StatusInternalServerError, errors.New("server address is not configured")
+	}
+
+	// 根据serverPort确定协议
+	protocol := "http"
+	if serverPort == "443" {
+		protocol = "https"
+	}
 
+	escaped := strings.Split(fileName, "/")
+	for i, part := range escaped {
+		escaped[i] = url.PathEscape(part)
 	}
+	fileURL := fmt.Sprintf("%s://%s:%s/channel_temp/%s", protocol, serverAddress, serverPort, strings.Join(escaped, "/"))
+	return fileURL, http.StatusOK, nil
 }
 
 // 检查是否超过调用频率限制
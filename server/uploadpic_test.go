@@ -0,0 +1,24 @@
+package server
+
+import "testing"
+
+func TestSanitizeFileName(t *testing.T) {
+	cases := []struct {
+		name string
+		want string
+	}{
+		{"report.pdf", "report.pdf"},
+		{"文档 v2.docx", "文档 v2.docx"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\a\data.zip`, "data.zip"},
+		{"a\x00b\n.txt", "ab.txt"},
+		{"", ""},
+		{"..", ""},
+		{"/", ""},
+	}
+	for _, tc := range cases {
+		if got := sanitizeFileName(tc.name); got != tc.want {
+			t.Errorf("sanitizeFileName(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
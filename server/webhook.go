@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/stats"
+	"github.com/tencent-connect/botgo/dto"
+	"github.com/tencent-connect/botgo/event"
+	"github.com/tencent-connect/botgo/interaction/signature"
+	"github.com/tencent-connect/botgo/interaction/webhook"
+	"github.com/tencent-connect/botgo/openapi"
+)
+
+// 回调地址验证 botgo中没有定义该opcode
+const opCallbackValidation dto.OPCode = 13
+
+// 回调请求体的大小上限 事件数据都很小 签名校验前先限制读取的大小
+const maxWebhookBodySize = 1 << 20
+
+// 回调地址验证的请求数据
+type callbackValidationData struct {
+	PlainToken string `json:"plain_token"`
+	EventTs    string `json:"event_ts"`
+}
+
+// 回调地址验证的响应
+type callbackValidationResponse struct {
+	PlainToken string `json:"plain_token"`
+	Signature  string `json:"signature"`
+}
+
+// WebhookHandler 处理qq开放平台的http回调 使用client_secret校验签名
+// 事件交给event.ParseAndHandle,由main中getHandlerByName注册的handler处理
+func WebhookHandler(c *gin.Context) {
+	traceID := c.GetHeader(openapi.TraceIDKey)
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxWebhookBodySize))
+	if err != nil {
+		mylog.Printf("读取webhook回调失败: %v, traceID: %s", err, traceID)
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.Status(http.StatusRequestEntityTooLarge)
+			return
+		}
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	payload := &dto.WSPayload{}
+	if err := json.Unmarshal(body, payload); err != nil {
+		mylog.Printf("解析webhook回调失败: %v, traceID: %s", err, traceID)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	// 包括回调地址验证在内的每个请求都必须带有合法的签名
+	secret := config.GetClientSecret()
+	if pass, err := signature.Verify(secret, c.Request.Header, body); err != nil || !pass {
+		mylog.Printf("webhook签名校验失败: %v, traceID: %s", err, traceID)
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	stats.AddCallbackReceived()
+
+	switch payload.OPCode {
+	case opCallbackValidation:
+		handleCallbackValidation(c, secret, body)
+	case dto.WSHeartbeat:
+		seq, _ := payload.Data.(float64)
+		c.String(http.StatusOK, webhook.GenHeartbeatACK(uint32(seq)))
+	case dto.WSDispatchEvent:
+		// 原始数据放入,parse的时候需要从里面提取d
+		payload.RawMessage = body
+		// 先应答再处理 避免处理较慢时回调超时被qq重试
+		go func() {
+			if err := event.ParseAndHandle(payload); err != nil {
+				mylog.Printf("处理webhook事件失败: %v, traceID: %s", err, traceID)
+			}
+		}()
+		c.String(http.StatusOK, webhook.GenDispatchACK(true))
+	default:
+		mylog.Printf("未知的webhook回调op: %d, traceID: %s", payload.OPCode, traceID)
+		c.Status(http.StatusOK)
+	}
+}
+
+// 回调地址验证 使用client_secret派生的ed25519私钥对event_ts+plain_token签名
+func handleCallbackValidation(c *gin.Context, secret string, body []byte) {
+	var req struct {
+		Data callbackValidationData `json:"d"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil || req.Data.PlainToken == "" {
+		mylog.Printf("解析回调地址验证请求失败: %v", err)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	// 签名内容与事件签名一致为timestamp+body,这里即event_ts+plain_token
+	header := http.Header{}
+	header.Set(signature.HeaderTimestamp, req.Data.EventTs)
+	sig, err := signature.Generate(secret, header, []byte(req.Data.PlainToken))
+	if err != nil {
+		mylog.Printf("生成回调地址验证签名失败: %v", err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, callbackValidationResponse{
+		PlainToken: req.Data.PlainToken,
+		Signature:  sig,
+	})
+}
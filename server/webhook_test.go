@@ -0,0 +1,92 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/tencent-connect/botgo/interaction/signature"
+)
+
+// 用client_secret为回调请求签名
+func signWebhook(t *testing.T, secret, timestamp, body string) string {
+	t.Helper()
+	header := http.Header{}
+	header.Set(signature.HeaderTimestamp, timestamp)
+	sig, err := signature.Generate(secret, header, []byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestWebhookHandler(t *testing.T) {
+	secret := config.GetClientSecret()
+	validation := `{"op":13,"d":{"plain_token":"Arq0D5A61EgUu4OxUvOp","event_ts":"1725442341"}}`
+	heartbeat := `{"op":1,"d":42}`
+	cases := []struct {
+		name      string
+		body      string
+		signature string // 为空时不带签名
+		status    int
+		response  string // 不为空时对比响应
+	}{
+		{name: "signed validation", body: validation, signature: "valid", status: http.StatusOK},
+		{name: "unsigned validation", body: validation, status: http.StatusUnauthorized},
+		{name: "forged validation", body: validation, signature: strings.Repeat("0", 128), status: http.StatusUnauthorized},
+		{name: "signed heartbeat", body: heartbeat, signature: "valid", status: http.StatusOK, response: `{"op":11,"d":42}`},
+		{name: "unsigned heartbeat", body: heartbeat, status: http.StatusUnauthorized},
+		{name: "invalid json", body: "{", signature: "valid", status: http.StatusBadRequest},
+		{name: "oversized body", body: `{"op":1,"d":"` + strings.Repeat("a", maxWebhookBodySize) + `"}`, signature: "valid", status: http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			const timestamp = "1725442341"
+			req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(tc.body))
+			if tc.signature != "" {
+				sig := tc.signature
+				if sig == "valid" {
+					sig = signWebhook(t, secret, timestamp, tc.body)
+				}
+				req.Header.Set(signature.HeaderSig, sig)
+				req.Header.Set(signature.HeaderTimestamp, timestamp)
+			}
+			c, w := testContext(req)
+			WebhookHandler(c)
+			if c.Writer.Status() != tc.status {
+				t.Fatalf("status = %d, want %d", c.Writer.Status(), tc.status)
+			}
+			if tc.response != "" && strings.TrimSpace(w.Body.String()) != tc.response {
+				t.Errorf("response = %s, want %s", w.Body.String(), tc.response)
+			}
+		})
+	}
+}
+
+// 回调地址验证的响应是对event_ts+plain_token的签名
+func TestWebhookValidationResponse(t *testing.T) {
+	secret := config.GetClientSecret()
+	body := `{"op":13,"d":{"plain_token":"Arq0D5A61EgUu4OxUvOp","event_ts":"1725442341"}}`
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body))
+	req.Header.Set(signature.HeaderSig, signWebhook(t, secret, "1725442400", body))
+	req.Header.Set(signature.HeaderTimestamp, "1725442400")
+	c, w := testContext(req)
+	WebhookHandler(c)
+
+	var resp callbackValidationResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", w.Body.String(), err)
+	}
+	if resp.PlainToken != "Arq0D5A61EgUu4OxUvOp" {
+		t.Errorf("plain_token = %s", resp.PlainToken)
+	}
+	header := http.Header{}
+	header.Set(signature.HeaderSig, resp.Signature)
+	header.Set(signature.HeaderTimestamp, "1725442341")
+	if ok, err := signature.Verify(secret, header, []byte(resp.PlainToken)); err != nil || !ok {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
@@ -11,7 +11,9 @@ import (
 	"github.com/hoshinonyaruko/gensokyo/Processor"
 	"github.com/hoshinonyaruko/gensokyo/callapi"
 	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/metrics"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 	"github.com/hoshinonyaruko/gensokyo/wsclient"
 	"github.com/tencent-connect/botgo/openapi"
 )
@@ -40,36 +42,8 @@ func WsHandlerWithDependencies(api openapi.OpenAPI, apiV2 openapi.OpenAPI, p *Pr
 
 // 处理正向ws客户端的连接
 func wsHandler(api openapi.OpenAPI, apiV2 openapi.OpenAPI, p *Processor.Processors, c *gin.Context) {
-	// 先从请求头中尝试获取token
-	tokenFromHeader := c.Request.Header.Get("Authorization")
-	token := ""
-	if tokenFromHeader != "" {
-		if strings.HasPrefix(tokenFromHeader, "Token ") {
-			// 从 "Token " 后面提取真正的token值
-			token = strings.TrimPrefix(tokenFromHeader, "Token ")
-		} else if strings.HasPrefix(tokenFromHeader, "Bearer ") {
-			// 从 "Bearer " 后面提取真正的token值
-			token = strings.TrimPrefix(tokenFromHeader, "Bearer ")
-		} else {
-			// 直接使用token值
-			token = tokenFromHeader
-		}
-	} else {
-		// 如果请求头中没有token，则从URL参数中获取
-		token = c.Query("access_token")
-	}
-
-	if token == "" {
-		mylog.Printf("Connection failed due to missing token. Headers: %v", c.Request.Header)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing token"})
-		return
-	}
-
-	// 使用GetWsServerToken()来获取有效的token
-	validToken := config.GetWsServerToken()
-	if token != validToken {
-		mylog.Printf("Connection failed due to incorrect token. Headers: %v, Provided token: %s", c.Request.Header, tokenFromHeader)
-		c.JSON(http.StatusForbidden, gin.H{"error": "Incorrect token"})
+	// 校验token 与http api共用同一套规则
+	if !validateAccessToken(c) {
 		return
 	}
 
@@ -107,6 +81,9 @@ func wsHandler(api openapi.OpenAPI, apiV2 openapi.OpenAPI, p *Processor.Processo
 		mylog.Printf("Error sending connection success message: %v\n", err)
 	}
 
+	metrics.IncWsClients("forward")
+	defer metrics.DecWsClients("forward")
+
 	// 在defer语句之前运行
 	defer func() {
 		// 移除客户端从WsServerClients
@@ -133,7 +110,45 @@ func wsHandler(api openapi.OpenAPI, apiV2 openapi.OpenAPI, p *Processor.Processo
 	}
 }
 
+// 校验正向连接(ws/http api)携带的token,失败时直接写回错误响应
+func validateAccessToken(c *gin.Context) bool {
+	// 先从请求头中尝试获取token
+	tokenFromHeader := c.Request.Header.Get("Authorization")
+	token := ""
+	if tokenFromHeader != "" {
+		if strings.HasPrefix(tokenFromHeader, "Token ") {
+			// 从 "Token " 后面提取真正的token值
+			token = strings.TrimPrefix(tokenFromHeader, "Token ")
+		} else if strings.HasPrefix(tokenFromHeader, "Bearer ") {
+			// 从 "Bearer " 后面提取真正的token值
+			token = strings.TrimPrefix(tokenFromHeader, "Bearer ")
+		} else {
+			// 直接使用token值
+			token = tokenFromHeader
+		}
+	} else {
+		// 如果请求头中没有token，则从URL参数中获取
+		token = c.Query("access_token")
+	}
+
+	if token == "" {
+		mylog.Printf("Connection failed due to missing token. Headers: %v", c.Request.Header)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing token"})
+		return false
+	}
+
+	// 使用GetWsServerToken()来获取有效的token
+	validToken := config.GetWsServerToken()
+	if token != validToken {
+		mylog.Printf("Connection failed due to incorrect token. Headers: %v, Provided token: %s", c.Request.Header, tokenFromHeader)
+		c.JSON(http.StatusForbidden, gin.H{"error": "Incorrect token"})
+		return false
+	}
+	return true
+}
+
 func processWSMessage(client *WebSocketServerClient, msg []byte) {
+	stats.AddPacketReceived()
 	var message callapi.ActionMessage
 	err := json.Unmarshal(msg, &message)
 	if err != nil {
@@ -157,8 +172,10 @@ func (c *WebSocketServerClient) SendMessage(message map[string]interface{}) erro
 	err = c.Conn.WriteMessage(websocket.TextMessage, msgBytes)
 	if err != nil {
 		mylog.Printf("WebSocket服务端发送失败: %v", err)
+		stats.AddPacketLost()
 		return err
 	}
+	stats.AddPacketSent()
 	mylog.Println("WebSocket服务端发送成功")
 	return nil
 }
@@ -0,0 +1,58 @@
+// 语音格式检查 qq富媒体接口的语音(file_type=3)只接受silk
+// 程序内还没有silk编码器 wav mp3等格式会以明确的错误拒绝 需要调用方先转码为silk
+package silk
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var silkHeader = []byte("#!SILK_V3")
+
+// ErrNotSilk 语音不是silk 暂不支持转码
+var ErrNotSilk = errors.New("record is not silk, transcoding is not supported")
+
+// ErrUnsupportedFormat 无法识别的语音格式
+var ErrUnsupportedFormat = errors.New("unsupported audio format")
+
+// IsSilk 判断是否已经是silk 腾讯的silk在头部前多一个0x02
+func IsSilk(data []byte) bool {
+	if len(data) > 0 && data[0] == 0x02 {
+		data = data[1:]
+	}
+	return bytes.HasPrefix(data, silkHeader)
+}
+
+// Detect 识别语音的格式 返回wav或mp3
+func Detect(data []byte) (string, error) {
+	switch {
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return "wav", nil
+	case isMP3(data):
+		return "mp3", nil
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+// mp3以ID3标签或帧同步字开头
+func isMP3(data []byte) bool {
+	if bytes.HasPrefix(data, []byte("ID3")) {
+		return true
+	}
+	return len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0
+}
+
+// Encode 返回可以直接上传的silk语音
+// 已是silk时原样返回 能识别的wav mp3返回ErrNotSilk 其他数据返回格式错误
+func Encode(data []byte) ([]byte, error) {
+	if IsSilk(data) {
+		return data, nil
+	}
+	format, err := Detect(data)
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%w: %s", ErrNotSilk, format)
+}
@@ -0,0 +1,36 @@
+package silk
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	silkData := append([]byte{0x02}, []byte("#!SILK_V3\x00\x01")...)
+	cases := []struct {
+		name    string
+		data    []byte
+		wantErr error
+	}{
+		{"tencent silk passthrough", silkData, nil},
+		{"silk passthrough", []byte("#!SILK_V3\x00\x01"), nil},
+		{"wav needs transcoding", []byte("RIFF\x24\x00\x00\x00WAVEfmt "), ErrNotSilk},
+		{"mp3 needs transcoding", []byte{0xFF, 0xFB, 0x90, 0x00}, ErrNotSilk},
+		{"id3 needs transcoding", []byte("ID3\x04\x00"), ErrNotSilk},
+		{"ogg", []byte("OggS\x00\x02"), ErrUnsupportedFormat},
+		{"unknown", []byte("hello"), ErrUnsupportedFormat},
+		{"empty", nil, ErrUnsupportedFormat},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Encode(tc.data)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Encode error = %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr == nil && !bytes.Equal(got, tc.data) {
+				t.Errorf("silk data was modified")
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+// 进程级的统计数据,用于get_status和心跳元事件
+package stats
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Statistics onebot v11 get_status中的stat字段
+type Statistics struct {
+	PacketReceived  uint64 `json:"packet_received"`
+	PacketSent      uint64 `json:"packet_sent"`
+	PacketLost      uint64 `json:"packet_lost"`
+	MessageReceived uint64 `json:"message_received"`
+	MessageSent     uint64 `json:"message_sent"`
+	DisconnectTimes uint64 `json:"disconnect_times"`
+	ReconnectTimes  uint64 `json:"reconnect_times"`
+	LostTimes       uint64 `json:"lost_times"`
+	LastMessageTime int64  `json:"last_message_time"`
+	MediaCacheHit   uint64 `json:"media_cache_hit"`
+	MediaCacheMiss  uint64 `json:"media_cache_miss"`
+}
+
+var (
+	packetReceived  atomic.Uint64 // 收到onebot应用端的数据包
+	packetSent      atomic.Uint64 // 成功发给onebot应用端的数据包
+	packetLost      atomic.Uint64 // 发给onebot应用端失败的数据包
+	messageReceived atomic.Uint64 // 收到的qq消息
+	messageSent     atomic.Uint64 // 成功发出的qq消息
+	disconnectTimes atomic.Uint64 // 反向ws断开次数
+	reconnectTimes  atomic.Uint64 // 反向ws重连成功次数
+	lostTimes       atomic.Uint64 // 网关连接断开次数
+	lastMessageTime atomic.Int64
+	mediaCacheHit   atomic.Uint64 // 富媒体file_info缓存命中
+	mediaCacheMiss  atomic.Uint64 // 富媒体file_info缓存未命中
+	online          atomic.Bool   // 网关session是否在线
+	webhookMode     atomic.Bool   // 使用http回调接收事件 没有网关session
+	lastCallback    atomic.Int64  // 最后一次通过签名校验的http回调时间
+)
+
+// 超过这个时间没有收到http回调(包括qq的心跳回调)时 webhook模式视为离线
+const callbackTimeout = 5 * time.Minute
+
+// AddPacketReceived 收到onebot应用端的数据包
+func AddPacketReceived() {
+	packetReceived.Add(1)
+}
+
+// AddPacketSent 成功发送数据包给onebot应用端
+func AddPacketSent() {
+	packetSent.Add(1)
+}
+
+// AddPacketLost 发送数据包给onebot应用端失败
+func AddPacketLost() {
+	packetLost.Add(1)
+}
+
+// AddMessageReceived 收到qq消息 同时更新最后消息时间
+func AddMessageReceived() {
+	messageReceived.Add(1)
+	lastMessageTime.Store(time.Now().Unix())
+}
+
+// AddMessageSent 成功发出qq消息
+func AddMessageSent() {
+	messageSent.Add(1)
+}
+
+// AddDisconnect 反向ws连接断开,开始重连
+func AddDisconnect() {
+	disconnectTimes.Add(1)
+}
+
+// AddReconnect 反向ws重连成功
+func AddReconnect() {
+	reconnectTimes.Add(1)
+}
+
+// AddMediaCacheHit 富媒体file_info缓存命中 跳过上传
+func AddMediaCacheHit() {
+	mediaCacheHit.Add(1)
+}
+
+// AddMediaCacheMiss 富媒体file_info缓存未命中 需要上传
+func AddMediaCacheMiss() {
+	mediaCacheMiss.Add(1)
+}
+
+// SetOnline 设置网关session状态 掉线时计入lost_times
+func SetOnline(state bool) {
+	if old := online.Swap(state); old && !state {
+		lostTimes.Add(1)
+	}
+}
+
+// SetWebhookMode 使用http回调接收事件 在线状态由回调的情况决定
+func SetWebhookMode() {
+	webhookMode.Store(true)
+}
+
+// AddCallbackReceived 收到通过签名校验的http回调
+func AddCallbackReceived() {
+	lastCallback.Store(time.Now().Unix())
+}
+
+// IsOnline 网关session是否在线 webhook模式下为最近是否收到过qq的回调
+func IsOnline() bool {
+	if webhookMode.Load() {
+		last := lastCallback.Load()
+		return last != 0 && time.Since(time.Unix(last, 0)) < callbackTimeout
+	}
+	return online.Load()
+}
+
+// Snapshot 获取当前的统计数据
+func Snapshot() Statistics {
+	return Statistics{
+		PacketReceived:  packetReceived.Load(),
+		PacketSent:      packetSent.Load(),
+		PacketLost:      packetLost.Load(),
+		MessageReceived: messageReceived.Load(),
+		MessageSent:     messageSent.Load(),
+		DisconnectTimes: disconnectTimes.Load(),
+		ReconnectTimes:  reconnectTimes.Load(),
+		LostTimes:       lostTimes.Load(),
+		LastMessageTime: lastMessageTime.Load(),
+		MediaCacheHit:   mediaCacheHit.Load(),
+		MediaCacheMiss:  mediaCacheMiss.Load(),
+	}
+}
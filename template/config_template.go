@@ -16,6 +16,16 @@ settings:
   command_whitelist: ["help", "pr", "re", "info", "bp", "bind"]  #指令白名单，只有这些指令会上报到ws服务器，留空则所有消息都上报
   auto_reply : true                #是否对所有收到的消息自动回复（不会上报给onebot应用）
   config_auto_reload : false         #配置文件热加载，检测到config.yml变动时自动重启程序应用新配置
+  group_reply_window : 300          #群被动回复的有效期(秒) 超过后msg_id不能再用于被动回复
+  c2c_reply_window : 3600           #单聊被动回复的有效期(秒)
+  group_reply_fallback : ["event", "active"]  #群被动回复过期后按顺序尝试 event:使用该群最近事件的event_id被动回复 active:作为主动消息发送 设为[]时拒绝发送并在回执中说明
+  c2c_reply_fallback : ["event", "active"]    #单聊被动回复过期后的处理 同上
+  send_rate_global : 20             #所有发送每秒最多的次数 超过时排队 0为默认值 -1为不限制(以下同)
+  send_rate_passive : 5             #每个群 用户 子频道被动回复每秒最多的次数
+  send_active_quota : 0             #每个群 用户 子频道每天最多的主动消息数 与被动回复分开计数 默认不限制
+  send_queue_wait : 10              #排队等待发送的最长时间(秒) 超过时直接返回失败 -1为不等待
+  send_retry : 3                    #遇到qq的频率限制错误时的重试次数 间隔从1秒开始依次加倍 -1为不重试
+  audit_wait : 0                    #频道主动消息进入审核时 回执等待审核结果的秒数 0为立即返回audit_id 结果通过message_audit通知上报 注意等待期间会阻塞同一连接上的其他action
 
   ## 公域机器人指令处理选项
   remove_prefix : true  #是否忽略公域机器人指令前第一个/
@@ -27,16 +37,21 @@ settings:
     - "DirectMessageHandler"                         # 私域频道私信(dms)
     - "ReadyHandler"                                # 连接成功
     - "ErrorNotifyHandler"                         # 连接关闭
-    # - "GuildEventHandler"                          # 频道事件
-    # - "MemberEventHandler"                         # 频道成员新增
-    # - "ChannelEventHandler"                        # 频道事件
+    # - "GuildEventHandler"                          # 机器人加入退出频道 上报为notice
+    # - "MemberEventHandler"                         # 频道成员变更 上报为notice
+    # - "ChannelEventHandler"                        # 子频道创建 更新 删除 上报为notice
     # - "CreateMessageHandler"                       # 频道不at信息 私域机器人需要开启 公域机器人开启会连接失败
     # - "InteractionHandler"                         # 添加频道互动回应 卡片按钮data回调事件
+    # - "MessageReactionHandler"                     # 频道消息表情表态 上报为notice
+    # - "MessageAuditHandler"                        # 频道主动消息审核结果 上报为message_audit通知 并补全审核中消息的message_id
+    # - "MessageDeleteHandler"                       # 私域频道消息撤回 上报为notice 与CreateMessageHandler相同 公域机器人开启会连接失败
+    # - "PublicMessageDeleteHandler"                 # 公域频道at消息撤回 上报为notice
+    # - "DirectMessageDeleteHandler"                 # 频道私信撤回 上报为notice
     - "GroupATMessageEventHandler"                 # 群at信息 仅频道机器人时候需要注释
     - "C2CMessageEventHandler"                     # 群私聊 仅频道机器人时候需要注释
     # - "ThreadEventHandler"                         # 频道发帖事件 仅频道私域机器人可用
 
-  global_channel_to_group: true                      # 是否将频道转换成群 默认true
+  global_channel_to_group: true                      # 是否将频道转换成群 默认true 每个子频道是一个群 频道成员变更等频道级别的通知会对频道下收到过信息或事件的每个子频道分别上报
   global_private_to_channel: false                   # 是否将私聊转换成频道 如果是群场景 会将私聊转为群(方便提审\测试)
   array: false
 
@@ -46,6 +61,8 @@ settings:
   lotus: false                                       # lotus特性默认为false,当为true时,将会连接到另一个lotus为false的gensokyo。
                                                      # 使用它提供的图床和idmaps服务(场景:同一个机器人在不同服务器运行,或内网需要发送base64图)。
                                                      # 如果需要发送base64图片,需要设置正确的公网server_dir和开放对应的port
+  lotus_secret: ""                                   # lotus两端共用的密钥,两端需填写一致。为空时idmaps和图床接口只允许本机访问
+  lotus_timeout: 5                                   # lotus请求的超时时间 单位秒
 
 
   ## 正向WebSocket连接配置
@@ -53,6 +70,15 @@ settings:
   master_id : ["1","2"]     #群场景尚未开放获取管理员和列表能力,手动从日志中获取需要设置为管理,的user_id并填入(适用插件有权限判断场景)
   enable_ws_server: true    #是否启用正向ws服务器 监听server_dir:port/ws
   ws_server_token : "12345" #正向ws的token 不启动正向ws可忽略
+  enable_http_api: false    #是否启用onebot v11 http api 监听server_dir:port/动作名(如/send_group_msg) 鉴权与正向ws共用ws_server_token
+  http_post_address: []     #http上报地址(反向http) 支持多个["http://127.0.0.1:5700/","",""] 事件会以post形式上报 并处理快速操作
+  http_post_secret: []      #http上报签名密钥,按顺序与http_post_address一一对应,设置后会携带X-Signature: sha1=HMAC签名,留空不签名
+  http_post_timeout: 5      #http上报超时时间 单位秒 超时后放弃本次上报和快速操作
+  enable_webhook: false     #使用qq开放平台的http回调(webhook)接收事件,代替websocket网关 需要在q.qq.com配置回调地址 使用client_secret校验签名
+  webhook_path: "/webhook"  #http回调的路径 回调地址为https://server_dir/webhook_path qq要求443 80 8080 8443端口
+  enable_metrics: false     #是否启用prometheus监控 监听server_dir:port/metrics 包含事件 action openapi耗时 ws连接数等指标
+  idmap_backend: "bolt"     #idmap存储后端 bolt或sqlite bolt会独占idmap.db,需要其他程序同时读写时使用sqlite 切换前用-migrate-idmap bolt:sqlite迁移数据
+  idmap_sqlite_path: "idmap.sqlite" #sqlite后端的数据库文件路径
   identify_file: true  #自动生成域名校验文件,在q.qq.com配置信息URL,在server_dir填入自己已备案域名,正确解析到机器人所在服务器ip地址,机器人即可发送链接
   crt: "" #证书路径 从你的域名服务商或云服务商申请签发SSL证书(qq要求SSL)
   key: "" #密钥路径 Apache（crt文件、key文件）示例: "C:\\123.key" \需要双写成\\
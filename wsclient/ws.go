@@ -12,7 +12,9 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/hoshinonyaruko/gensokyo/callapi"
 	"github.com/hoshinonyaruko/gensokyo/config"
+	"github.com/hoshinonyaruko/gensokyo/metrics"
 	"github.com/hoshinonyaruko/gensokyo/mylog"
+	"github.com/hoshinonyaruko/gensokyo/stats"
 	"github.com/tencent-connect/botgo/openapi"
 )
 
@@ -69,6 +71,7 @@ func (c *WebSocketClient) SendMessage(message map[string]interface{}) error {
 	err = c.conn.WriteMessage(websocket.TextMessage, msgBytes)
 	if err != nil {
 		mylog.Printf("WebSocket客户端发送失败: %v", err)
+		stats.AddPacketLost()
 		if !c.isReconnecting {
 			go c.Reconnect()
 		}
@@ -79,12 +82,16 @@ func (c *WebSocketClient) SendMessage(message map[string]interface{}) error {
 		return err
 	}
 
+	stats.AddPacketSent()
 	mylog.DebugPrintln("WebSocket客户端发送成功")
 	return nil
 }
 
 // 处理onebotv11应用端发来的信息
 func (c *WebSocketClient) handleIncomingMessages(ctx context.Context, cancel context.CancelFunc) {
+	// 每个连接只有一个读协程,以它的生命周期统计反向ws连接数
+	metrics.IncWsClients("reverse")
+	defer metrics.DecWsClients("reverse")
 	defer func() {
 		// notify that the reader goroutine has exited
 		select {
@@ -135,6 +142,7 @@ func (client *WebSocketClient) Reconnect() {
 	client.isReconnecting = true
 	client.reconnectAttempts = 0
 	client.mutex.Unlock()
+	stats.AddDisconnect()
 
 	defer func() {
 		client.mutex.Lock()
@@ -177,6 +185,7 @@ func (client *WebSocketClient) Reconnect() {
 				_ = oldConn.Close()
 			}
 
+			stats.AddReconnect()
 			// 重发失败的消息
 			go newClient.processFailedMessages(oldSendFailures)
 			mylog.Println("Successfully reconnected to WebSocket after " + fmt.Sprintf("%d", attempts) + " attempts.")
@@ -204,6 +213,7 @@ func (c *WebSocketClient) recvMessage(msg []byte) {
 	c.lastHeartbeatTime = time.Now()
 	c.mutex.Unlock()
 
+	stats.AddPacketReceived()
 	var message callapi.ActionMessage
 	err := json.Unmarshal(msg, &message)
 	if err != nil {
@@ -256,19 +266,10 @@ func (c *WebSocketClient) sendHeartbeat(ctx context.Context, botID uint64) {
 					"app_enabled":     true,
 					"app_good":        true,
 					"app_initialized": true,
-					"good":            true,
-					"online":          true,
+					"good":            stats.IsOnline(),
+					"online":          stats.IsOnline(),
 					"plugins_good":    nil,
-					"stat": map[string]int{
-						"packet_received":   34933,
-						"packet_sent":       8513,
-						"packet_lost":       0,
-						"message_received":  24674,
-						"message_sent":      1663,
-						"disconnect_times":  0,
-						"lost_times":        0,
-						"last_message_time": int(time.Now().Unix()) - 10,
-					},
+					"stat":            stats.Snapshot(),
 				},
 				"interval": 10000,
 			}